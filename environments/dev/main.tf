@@ -54,6 +54,78 @@ variable "domains" {
   default     = ["example.com"]
 }
 
+variable "allowed_origins" {
+  description = "Origins allowed to call the function from a browser (CORS)"
+  type        = list(string)
+  default     = ["*"]
+}
+
+variable "max_request_bytes" {
+  description = "Largest request body the function accepts"
+  type        = number
+  default     = 65536
+}
+
+variable "labels" {
+  description = "Labels applied to resources that support them"
+  type        = map(string)
+  default     = {}
+}
+
+variable "name_suffix" {
+  description = "Appended to resource names so concurrent deployments into one project don't collide"
+  type        = string
+  default     = ""
+}
+
+variable "ingress_settings" {
+  description = "Where the function accepts traffic from; ALLOW_INTERNAL_AND_GCLB limits it to the load balancer"
+  type        = string
+  default     = "ALLOW_ALL"
+}
+
+variable "min_instances" {
+  description = "Function instances kept warm; 0 lets it scale to zero when idle"
+  type        = number
+  default     = 0
+}
+
+variable "max_instances" {
+  description = "Upper bound on concurrent function instances"
+  type        = number
+  default     = 10
+}
+
+variable "enable_pubsub_trigger" {
+  description = "Trigger the function from a Pub/Sub topic instead of over HTTP; the load balancer is then left out"
+  type        = bool
+  default     = false
+}
+
+variable "https_redirect" {
+  description = "Redirect plain HTTP requests to the load balancer to HTTPS"
+  type        = bool
+  default     = false
+}
+
+variable "enable_cdn" {
+  description = "Cache the load balancer's responses in Cloud CDN"
+  type        = bool
+  default     = false
+}
+
+variable "vpc_connector" {
+  description = "Serverless VPC Access connector the function's egress goes through; null for none"
+  type        = string
+  default     = null
+}
+
+variable "vpc_connector_egress_settings" {
+  description = "Which egress uses vpc_connector: PRIVATE_RANGES_ONLY or ALL_TRAFFIC"
+  type        = string
+  default     = null
+}
+
 # Use the main infrastructure module
 module "hello_world_infrastructure" {
   source = "../../"
@@ -62,6 +134,24 @@ module "hello_world_infrastructure" {
   region      = var.region
   environment = var.environment
   domains     = var.domains
+
+  allowed_origins   = var.allowed_origins
+  max_request_bytes = var.max_request_bytes
+  labels            = var.labels
+  ingress_settings  = var.ingress_settings
+  name_suffix       = var.name_suffix
+  min_instances     = var.min_instances
+  max_instances     = var.max_instances
+  https_redirect    = var.https_redirect
+  enable_cdn        = var.enable_cdn
+
+  enable_pubsub_trigger = var.enable_pubsub_trigger
+
+  # The timeout and concurrency tests make the function sleep with ?delay=
+  enable_delay_param = true
+
+  vpc_connector                 = var.vpc_connector
+  vpc_connector_egress_settings = var.vpc_connector_egress_settings
 }
 
 # Outputs
@@ -73,4 +163,24 @@ output "load_balancer_url" {
 output "function_url" {
   description = "The URL of the Cloud Function"
   value       = module.hello_world_infrastructure.function_url
-} 
\ No newline at end of file
+}
+
+output "service_account_email" {
+  description = "The email of the service account the function runs as"
+  value       = module.hello_world_infrastructure.service_account_email
+}
+
+output "function_name" {
+  description = "The name of the Cloud Function"
+  value       = module.hello_world_infrastructure.function_name
+} 
+
+output "backend_service_name" {
+  description = "The name of the load balancer backend service"
+  value       = module.hello_world_infrastructure.backend_service_name
+}
+
+output "source_bucket_name" {
+  description = "The name of the bucket holding the function source archives"
+  value       = module.hello_world_infrastructure.source_bucket_name
+}
@@ -25,6 +25,18 @@ variable "region" {
   default     = "us-central1"
 }
 
+variable "labels" {
+  description = "Labels applied to resources that support them"
+  type        = map(string)
+  default     = {}
+}
+
+variable "name_suffix" {
+  description = "Appended to resource names so concurrent deployments into one project don't collide"
+  type        = string
+  default     = ""
+}
+
 # Use the main module
 module "hello_world_infrastructure" {
   source = "../../"
@@ -32,6 +44,11 @@ module "hello_world_infrastructure" {
   project_id  = var.project_id
   region      = var.region
   environment = "test"
+  labels      = var.labels
+  name_suffix = var.name_suffix
+
+  # Honour ?delay= like dev; prd leaves it off
+  enable_delay_param = true
 }
 
 # Outputs
@@ -1,5 +1,6 @@
 terraform {
-  required_version = ">= 1.0"
+  # moved blocks need 1.1
+  required_version = ">= 1.1"
   required_providers {
     google = {
       source  = "hashicorp/google"
@@ -54,11 +55,95 @@ variable "domains" {
   default     = ["example.com"]
 }
 
+variable "allowed_origins" {
+  description = "Origins allowed to call the function from a browser (CORS)"
+  type        = list(string)
+  default     = ["*"]
+}
+
+variable "max_request_bytes" {
+  description = "Largest request body the function accepts"
+  type        = number
+  default     = 65536
+}
+
+variable "enable_delay_param" {
+  description = "Honour the function's ?delay= query parameter; for tests only, never in production"
+  type        = bool
+  default     = false
+}
+
+variable "enable_pubsub_trigger" {
+  description = "Trigger the function from a Pub/Sub topic instead of over HTTP; there is then no load balancer, and function_url, load_balancer_url and backend_service_name are null"
+  type        = bool
+  default     = false
+}
+
+variable "labels" {
+  description = "Labels applied to resources that support them"
+  type        = map(string)
+  default     = {}
+}
+
+variable "name_suffix" {
+  description = "Appended to resource names so concurrent deployments into one project don't collide; lowercase letters and digits, at most 8"
+  type        = string
+  default     = ""
+
+  validation {
+    condition     = can(regex("^[a-z0-9]{0,8}$", var.name_suffix))
+    error_message = "name_suffix must be at most 8 lowercase letters and digits."
+  }
+}
+
+variable "ingress_settings" {
+  description = "Where the function accepts traffic from; ALLOW_INTERNAL_AND_GCLB limits it to the load balancer"
+  type        = string
+  default     = "ALLOW_ALL"
+}
+
+variable "min_instances" {
+  description = "Function instances kept warm; 0 lets it scale to zero when idle"
+  type        = number
+  default     = 0
+}
+
+variable "max_instances" {
+  description = "Upper bound on concurrent function instances"
+  type        = number
+  default     = 10
+}
+
+variable "https_redirect" {
+  description = "Redirect plain HTTP requests to the load balancer to HTTPS"
+  type        = bool
+  default     = false
+}
+
+variable "enable_cdn" {
+  description = "Cache the load balancer's responses in Cloud CDN"
+  type        = bool
+  default     = false
+}
+
+variable "vpc_connector" {
+  description = "Serverless VPC Access connector the function's egress goes through; null for none"
+  type        = string
+  default     = null
+}
+
+variable "vpc_connector_egress_settings" {
+  description = "Which egress uses vpc_connector: PRIVATE_RANGES_ONLY or ALL_TRAFFIC"
+  type        = string
+  default     = null
+}
+
 # Enable required APIs first
 module "apis" {
   source = "./modules/apis"
   
-  project_id = var.project_id
+  project_id    = var.project_id
+  enable_pubsub = var.enable_pubsub_trigger
 }
 
 # Cloud Function Module
@@ -68,6 +153,20 @@ module "cloud_function" {
   project_id   = var.project_id
   region       = var.region
   environment  = var.environment
+
+  allowed_origins   = var.allowed_origins
+  max_request_bytes = var.max_request_bytes
+  labels            = var.labels
+  ingress_settings  = var.ingress_settings
+  name_suffix       = var.name_suffix
+  min_instances     = var.min_instances
+  max_instances     = var.max_instances
+
+  enable_delay_param    = var.enable_delay_param
+  enable_pubsub_trigger = var.enable_pubsub_trigger
+
+  vpc_connector                 = var.vpc_connector
+  vpc_connector_egress_settings = var.vpc_connector_egress_settings
   
   # Wait for APIs to be enabled
   depends_on = [module.apis]
@@ -77,33 +176,66 @@ module "cloud_function" {
 module "cloud_armor" {
   source = "./modules/cloud_armor"
   
-  project_id = var.project_id
+  project_id  = var.project_id
+  environment = var.environment
+  name_suffix = var.name_suffix
   
   # Wait for APIs to be enabled
   depends_on = [module.apis]
 }
 
-# Load Balancer Module (creates NEG and uses backend service)
+# Load Balancer Module (creates NEG and uses backend service). A function
+# triggered from Pub/Sub has no HTTPS endpoint for it to serve, so it is left out
 module "load_balancer" {
   source = "./modules/load_balancer"
+  count  = var.enable_pubsub_trigger ? 0 : 1
   
   project_id                = var.project_id
   region                    = var.region
   cloud_function            = module.cloud_function.function
   security_policy_self_link = module.cloud_armor.security_policy_self_link
   domain_name               = length(var.domains) > 0 ? var.domains[0] : "example.com"
+  environment               = var.environment
+  name_suffix               = var.name_suffix
+  https_redirect            = var.https_redirect
+  enable_cdn                = var.enable_cdn
   
   # Wait for APIs and Cloud Armor
   depends_on = [module.apis, module.cloud_armor]
 }
 
+moved {
+  from = module.load_balancer
+  to   = module.load_balancer[0]
+}
+
 # Outputs
 output "load_balancer_url" {
   description = "The URL of the load balancer"
-  value       = module.load_balancer.load_balancer_url
+  value       = one(module.load_balancer[*].load_balancer_url)
 }
 
 output "function_url" {
   description = "The URL of the Cloud Function"
   value       = module.cloud_function.function_url
-} 
\ No newline at end of file
+}
+
+output "service_account_email" {
+  description = "The email of the service account the function runs as"
+  value       = module.cloud_function.service_account_email
+}
+
+output "function_name" {
+  description = "The name of the Cloud Function"
+  value       = module.cloud_function.function_name
+} 
+
+output "backend_service_name" {
+  description = "The name of the load balancer backend service"
+  value       = one(module.load_balancer[*].backend_service_name)
+}
+
+output "source_bucket_name" {
+  description = "The name of the bucket holding the function source archives"
+  value       = module.cloud_function.source_bucket_name
+}
@@ -47,6 +47,16 @@ resource "google_project_service" "iam" {
   disable_on_destroy = false
 }
 
+# Only needed when the function is triggered from Pub/Sub
+resource "google_project_service" "pubsub" {
+  count   = var.enable_pubsub ? 1 : 0
+  project = var.project_id
+  service = "pubsub.googleapis.com"
+
+  disable_dependent_services = false
+  disable_on_destroy = false
+}
+
 # Wait for APIs to be fully enabled before proceeding
 resource "time_sleep" "wait_for_apis" {
   depends_on = [
@@ -55,7 +65,8 @@ resource "time_sleep" "wait_for_apis" {
     google_project_service.cloud_storage,
     google_project_service.cloud_logging,
     google_project_service.cloud_build,
-    google_project_service.iam
+    google_project_service.iam,
+    google_project_service.pubsub
   ]
 
   create_duration = "60s"
@@ -1,4 +1,10 @@
 variable "project_id" {
   description = "The GCP project ID"
   type        = string
-} 
\ No newline at end of file
+}
+
+variable "enable_pubsub" {
+  description = "Enable the Pub/Sub API, for a function triggered from a topic"
+  type        = bool
+  default     = false
+}
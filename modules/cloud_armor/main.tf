@@ -1,6 +1,10 @@
+locals {
+  name_suffix = var.name_suffix == "" ? "" : "-${var.name_suffix}"
+}
+
 # Cloud Armor Security Policy - Direct implementation
 resource "google_compute_security_policy" "policy" {
-  name        = "hello-world-policy"
+  name        = "hello-world-policy-${var.environment}${local.name_suffix}"
   description = "Hello World Security Policy"
   project     = var.project_id
 
@@ -55,7 +59,7 @@ resource "google_compute_security_policy" "policy" {
 
 # Health check for the backend service
 resource "google_compute_health_check" "health_check" {
-  name                = "hello-world-health-check"
+  name                = "hello-world-health-check-${var.environment}${local.name_suffix}"
   description         = "Health check for hello world function"
   timeout_sec         = 5
   check_interval_sec  = 10
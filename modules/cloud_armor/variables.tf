@@ -1,4 +1,15 @@
 variable "project_id" {
   description = "The GCP project ID"
   type        = string
-} 
\ No newline at end of file
+} 
+
+variable "environment" {
+  description = "Environment name (dev, test, prd), part of every resource name so environments can share a project"
+  type        = string
+}
+
+variable "name_suffix" {
+  description = "Appended to resource names, after a dash, so concurrent deployments into one project don't collide"
+  type        = string
+  default     = ""
+}
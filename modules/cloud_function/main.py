@@ -1,7 +1,37 @@
 import os
 import json
+import base64
+import math
+import time
 from typing import Any
 
+# HTTP methods the function answers; anything else gets a JSON 405
+ALLOWED_METHODS = ('GET', 'POST', 'OPTIONS')
+
+# Request header that opts a caller into feature-flagged behaviour
+FEATURE_FLAG_HEADER = 'X-Feature-Flag'
+
+# Request bodies above MAX_REQUEST_BYTES get a JSON 413
+DEFAULT_MAX_REQUEST_BYTES = 65536
+
+# Sent with every response; the function is only reachable over HTTPS
+SECURITY_HEADERS = {
+    'X-Content-Type-Options': 'nosniff',
+    'Strict-Transport-Security': 'max-age=31536000; includeSubDomains',
+}
+
+# Path answered with a bare "ok" for readiness polling, without the greeting
+# logic, so polls stay cheap and out of real request metrics
+HEALTH_PATH = '/healthz'
+
+# Longest ?delay= the function will sleep for; Cloud Functions' own timeout
+# never exceeds 540 seconds, so a longer sleep would only hold the instance
+MAX_DELAY_SECONDS = 540
+
+# ?delay= is ignored unless this environment variable is "1", so callers
+# can't tie up instances where it isn't set, such as prd
+ENABLE_DELAY_PARAM_ENV = 'ENABLE_DELAY_PARAM'
+
 
 def hello_world(request: Any) -> str:
     """
@@ -17,37 +47,156 @@ def hello_world(request: Any) -> str:
     """
     # Get environment from environment variable
     environment = os.environ.get('ENV', 'unknown')
+    request_headers = getattr(request, 'headers', None) or {}
     
     # Business logic - independent of cloud provider
-    message = _generate_hello_message(environment)
+    message = _generate_hello_message(environment, request_headers.get(FEATURE_FLAG_HEADER))
     
     # Handle CORS for browser requests
-    headers = {
-        'Access-Control-Allow-Origin': '*',
-        'Access-Control-Allow-Methods': 'GET, POST, OPTIONS',
-        'Access-Control-Allow-Headers': 'Content-Type'
-    }
+    headers = _cors_headers(request_headers.get('Origin'))
+    headers.update(SECURITY_HEADERS)
     
     # Handle preflight requests
     if request.method == 'OPTIONS':
         return ('', 204, headers)
+
+    # Reject unsupported methods with a JSON error instead of a greeting
+    if request.method not in ALLOWED_METHODS:
+        error_headers = dict(headers)
+        error_headers['Content-Type'] = 'application/json'
+        error_headers['Allow'] = ', '.join(ALLOWED_METHODS)
+        error = {'error': f'Method {request.method} not allowed'}
+        return (json.dumps(error), 405, error_headers)
+
+    # Answer health checks before doing any real work
+    if getattr(request, 'path', None) == HEALTH_PATH:
+        return ('ok', 200, headers)
+
+    # Reject oversized bodies before doing any work with them
+    max_bytes = int(os.environ.get('MAX_REQUEST_BYTES', DEFAULT_MAX_REQUEST_BYTES))
+    if _body_too_large(request, max_bytes):
+        error_headers = dict(headers)
+        error_headers['Content-Type'] = 'application/json'
+        error = {'error': f'Request body exceeds {max_bytes} bytes'}
+        return (json.dumps(error), 413, error_headers)
+
+    # Sleep before answering so tests can exercise the function timeout
+    delay = _requested_delay(request)
+    if delay:
+        time.sleep(delay)
     
     # Return response with headers
     return (message, 200, headers)
 
 
-def _generate_hello_message(environment: str) -> str:
+def hello_event(event: dict, context: Any) -> None:
+    """
+    Background function entry point for a Pub/Sub trigger.
+    Logs each message with its event ID, which Pub/Sub sets to the message
+    ID, so a publisher can find out its message was processed.
+    
+    Args:
+        event: The Pub/Sub message, with base64 encoded data
+        context: Event metadata, including the event ID
+    """
+    data = base64.b64decode(event.get('data') or '').decode('utf-8', 'replace')
+    print(f"Processed message {getattr(context, 'event_id', 'unknown')}: {data}")
+
+
+def _body_too_large(request: Any, max_bytes: int) -> bool:
+    """
+    Check whether the request body is larger than max_bytes.
+    The declared Content-Length is trusted when present; otherwise at most
+    max_bytes + 1 bytes are read from the stream to find out.
+    
+    Args:
+        request: Flask request object (HTTP trigger)
+        max_bytes: The largest accepted body size
+        
+    Returns:
+        bool: True when the body is over the limit
+    """
+    content_length = getattr(request, 'content_length', None)
+    if content_length is not None:
+        return content_length > max_bytes
+    stream = getattr(request, 'stream', None)
+    if stream is None:
+        return False
+    return len(stream.read(max_bytes + 1)) > max_bytes
+
+
+def _requested_delay(request: Any) -> float:
+    """
+    Read the number of seconds the caller asked the function to sleep.
+    Missing, malformed and negative values mean no delay, as does any value
+    unless ENABLE_DELAY_PARAM is set; large values are capped at
+    MAX_DELAY_SECONDS.
+    
+    Args:
+        request: Flask request object (HTTP trigger)
+        
+    Returns:
+        float: Seconds to sleep before responding
+    """
+    if os.environ.get(ENABLE_DELAY_PARAM_ENV) != '1':
+        return 0.0
+    args = getattr(request, 'args', None) or {}
+    try:
+        delay = float(args.get('delay', 0))
+    except (TypeError, ValueError):
+        return 0.0
+    if math.isnan(delay) or delay <= 0:
+        return 0.0
+    return min(delay, MAX_DELAY_SECONDS)
+
+
+def _cors_headers(origin: Any) -> dict:
+    """
+    Build the CORS headers for a request from the given origin.
+    Origins come from the comma separated ALLOWED_ORIGINS variable; "*"
+    allows any origin, otherwise only listed origins get an allow header.
+    
+    Args:
+        origin: The request's Origin header, if any
+        
+    Returns:
+        dict: Headers to add to the response
+    """
+    allowed_origins = [
+        allowed.strip()
+        for allowed in os.environ.get('ALLOWED_ORIGINS', '*').split(',')
+        if allowed.strip()
+    ]
+    headers = {
+        'Access-Control-Allow-Methods': 'GET, POST, OPTIONS',
+        'Access-Control-Allow-Headers': f'Content-Type, {FEATURE_FLAG_HEADER}'
+    }
+    
+    if '*' in allowed_origins:
+        headers['Access-Control-Allow-Origin'] = '*'
+    elif origin and origin in allowed_origins:
+        headers['Access-Control-Allow-Origin'] = origin
+        headers['Vary'] = 'Origin'
+    
+    return headers
+
+
+def _generate_hello_message(environment: str, feature_flag: Any = None) -> str:
     """
     Pure business logic function to generate hello message.
     This is testable and independent of any framework.
     
     Args:
         environment: The environment name
+        feature_flag: The caller's X-Feature-Flag header, if any
         
     Returns:
         str: Formatted hello message
     """
-    return f"Hello, World! Environment: {environment}"
+    message = f"Hello, World! Environment: {environment}"
+    if feature_flag == 'beta':
+        message += " (beta)"
+    return message
 
 
 # For local testing
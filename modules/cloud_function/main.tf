@@ -1,13 +1,44 @@
+locals {
+  # "-<suffix>" when a suffix is set, so the default names are unchanged
+  name_suffix = var.name_suffix == "" ? "" : "-${var.name_suffix}"
+}
+
 # Create a storage bucket for the Cloud Function source code
 resource "google_storage_bucket" "function_source" {
-  name                        = "${var.project_id}-function-source-${var.environment}"
+  name                        = "${var.project_id}-function-source-${var.environment}${local.name_suffix}"
   location                    = var.region
   uniform_bucket_level_access = true
   force_destroy              = true
+  labels                      = var.labels
 
   versioning {
     enabled = true
   }
+
+  # Each deploy uploads a new archive and deletes the old one, which
+  # versioning keeps as a noncurrent version; Cloud Functions keeps its own
+  # copy of the deployed source, so those only cost storage
+  lifecycle_rule {
+    condition {
+      days_since_noncurrent_time = var.source_retention_days
+      with_state                 = "ARCHIVED"
+    }
+    action {
+      type = "Delete"
+    }
+  }
+
+  # Exported logs expire by age. The rule is limited to them so it never
+  # expires the live source archive, which Terraform would then recreate
+  lifecycle_rule {
+    condition {
+      age            = var.source_retention_days
+      matches_prefix = ["cloudfunctions.googleapis.com/"]
+    }
+    action {
+      type = "Delete"
+    }
+  }
 }
 
 # Create source code archive
@@ -31,19 +62,52 @@ resource "google_storage_bucket_object" "function_source" {
   source = data.archive_file.function_source.output_path
 }
 
+# Dedicated least-privilege identity for the function instead of the
+# project's default App Engine service account (which holds roles/editor)
+resource "google_service_account" "function" {
+  account_id   = "hello-world-${var.environment}${local.name_suffix}"
+  display_name = "Hello World function (${var.environment})"
+}
+
+# Topic the function is triggered from when enable_pubsub_trigger is set
+resource "google_pubsub_topic" "events" {
+  count  = var.enable_pubsub_trigger ? 1 : 0
+  name   = "hello-world-events-${var.environment}${local.name_suffix}"
+  labels = var.labels
+}
+
 # Cloud Function
 resource "google_cloudfunctions_function" "hello_world" {
-  name                  = "hello-world-${var.environment}"
+  name                  = "hello-world-${var.environment}${local.name_suffix}"
   runtime              = "python310"
-  entry_point          = "hello_world"
+  entry_point          = var.enable_pubsub_trigger ? "hello_event" : "hello_world"
   source_archive_bucket = google_storage_bucket.function_source.name
   source_archive_object = google_storage_bucket_object.function_source.name
-  trigger_http         = true
+  trigger_http         = var.enable_pubsub_trigger ? null : true
   available_memory_mb  = 128
   timeout             = 60
+  service_account_email = google_service_account.function.email
+  labels                = var.labels
+  ingress_settings      = var.ingress_settings
+  min_instances         = var.min_instances
+  max_instances         = var.max_instances
+
+  vpc_connector                 = var.vpc_connector
+  vpc_connector_egress_settings = var.vpc_connector_egress_settings
   
-  environment_variables = {
-    ENV = var.environment
+  # ENABLE_DELAY_PARAM is only set where ?delay= may be honoured
+  environment_variables = merge({
+    ENV               = var.environment
+    ALLOWED_ORIGINS   = join(",", var.allowed_origins)
+    MAX_REQUEST_BYTES = var.max_request_bytes
+  }, { for key, value in { ENABLE_DELAY_PARAM = "1" } : key => value if var.enable_delay_param })
+
+  dynamic "event_trigger" {
+    for_each = google_pubsub_topic.events
+    content {
+      event_type = "google.pubsub.topic.publish"
+      resource   = event_trigger.value.id
+    }
   }
 
   depends_on = [
@@ -66,7 +130,7 @@ resource "google_cloudfunctions_function_iam_binding" "invoker" {
 
 # Enable Cloud Logging
 resource "google_logging_project_sink" "function_logs" {
-  name        = "function-logs-${var.environment}"
+  name        = "hello-world-logs-${var.environment}${local.name_suffix}"
   destination = "storage.googleapis.com/${google_storage_bucket.function_source.name}"
   filter      = "resource.type=cloud_function AND resource.labels.function_name=${google_cloudfunctions_function.hello_world.name}"
 
@@ -8,7 +8,17 @@ output "function_url" {
   value       = google_cloudfunctions_function.hello_world.https_trigger_url
 }
 
+output "service_account_email" {
+  description = "The email of the service account the function runs as"
+  value       = google_service_account.function.email
+}
+
 output "function_name" {
   description = "The name of the Cloud Function"
   value       = google_cloudfunctions_function.hello_world.name
-} 
\ No newline at end of file
+} 
+
+output "source_bucket_name" {
+  description = "The name of the bucket holding the function source archives"
+  value       = google_storage_bucket.function_source.name
+}
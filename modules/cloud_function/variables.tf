@@ -11,4 +11,96 @@ variable "region" {
 variable "environment" {
   description = "Environment name (dev, test, prd)"
   type        = string
-} 
\ No newline at end of file
+}
+
+variable "allowed_origins" {
+  description = "Origins allowed to call the function from a browser (CORS); [\"*\"] allows any origin"
+  type        = list(string)
+  default     = ["*"]
+}
+
+variable "max_request_bytes" {
+  description = "Largest request body the function accepts; bigger bodies get a 413"
+  type        = number
+  default     = 65536
+}
+
+variable "enable_delay_param" {
+  description = "Honour the ?delay= query parameter, which makes the function sleep before answering; for tests only, never in production"
+  type        = bool
+  default     = false
+}
+
+variable "enable_pubsub_trigger" {
+  description = "Trigger the function from a Pub/Sub topic it creates, through the hello_event entry point, instead of over HTTP"
+  type        = bool
+  default     = false
+}
+
+variable "labels" {
+  description = "Labels applied to the function and its source bucket"
+  type        = map(string)
+  default     = {}
+} 
+
+variable "source_retention_days" {
+  description = "Days after which replaced source archives, and exported logs, are deleted from the source bucket"
+  type        = number
+  default     = 30
+}
+
+variable "ingress_settings" {
+  description = "Where the function accepts traffic from: ALLOW_ALL, ALLOW_INTERNAL_AND_GCLB or ALLOW_INTERNAL_ONLY"
+  type        = string
+  default     = "ALLOW_ALL"
+
+  validation {
+    condition     = contains(["ALLOW_ALL", "ALLOW_INTERNAL_AND_GCLB", "ALLOW_INTERNAL_ONLY"], var.ingress_settings)
+    error_message = "ingress_settings must be ALLOW_ALL, ALLOW_INTERNAL_AND_GCLB or ALLOW_INTERNAL_ONLY."
+  }
+}
+
+variable "name_suffix" {
+  description = "Appended to resource names, after a dash, so concurrent deployments into one project don't collide"
+  type        = string
+  default     = ""
+}
+
+variable "min_instances" {
+  description = "Instances kept warm; 0 lets the function scale to zero when idle"
+  type        = number
+  default     = 0
+
+  validation {
+    condition     = var.min_instances >= 0
+    error_message = "min_instances must not be negative."
+  }
+}
+
+variable "max_instances" {
+  description = "Upper bound on concurrent instances, which caps cost under load"
+  type        = number
+  default     = 10
+
+  validation {
+    condition     = var.max_instances >= 1
+    error_message = "max_instances must be at least 1."
+  }
+}
+
+variable "vpc_connector" {
+  description = "Serverless VPC Access connector, name or self link, that egress goes through to reach private resources; null for none"
+  type        = string
+  default     = null
+}
+
+variable "vpc_connector_egress_settings" {
+  description = "Which egress uses vpc_connector: PRIVATE_RANGES_ONLY or ALL_TRAFFIC; null for the provider default"
+  type        = string
+  default     = null
+
+  validation {
+    condition     = var.vpc_connector_egress_settings == null ? true : contains(["PRIVATE_RANGES_ONLY", "ALL_TRAFFIC"], var.vpc_connector_egress_settings)
+    error_message = "vpc_connector_egress_settings must be PRIVATE_RANGES_ONLY or ALL_TRAFFIC."
+  }
+}
@@ -1,6 +1,10 @@
+locals {
+  name_suffix = var.name_suffix == "" ? "" : "-${var.name_suffix}"
+}
+
 # Serverless Network Endpoint Group for Cloud Function
 resource "google_compute_region_network_endpoint_group" "neg" {
-  name                  = "cloud-function-neg"
+  name                  = "hello-world-neg-${var.environment}${local.name_suffix}"
   network_endpoint_type = "SERVERLESS"
   region                = var.region
 
@@ -13,14 +17,33 @@ resource "google_compute_region_network_endpoint_group" "neg" {
 
 # Backend service for the load balancer
 resource "google_compute_backend_service" "backend_service" {
-  name                            = "hello-world-backend"
+  name                            = "hello-world-backend-${var.environment}${local.name_suffix}"
   description                     = "Backend service for hello world function"
   protocol                        = "HTTP"
   port_name                       = "http"
   timeout_sec                     = 30
-  enable_cdn                      = false
+  enable_cdn                      = var.enable_cdn
   connection_draining_timeout_sec = 60
 
+  # The function sends no Cache-Control, so cache every response briefly;
+  # X-Feature-Flag changes the response and is part of the cache key
+  dynamic "cdn_policy" {
+    for_each = var.enable_cdn ? [1] : []
+    content {
+      cache_mode  = "FORCE_CACHE_ALL"
+      default_ttl = var.cdn_default_ttl
+      client_ttl  = var.cdn_default_ttl
+      max_ttl     = var.cdn_default_ttl
+
+      cache_key_policy {
+        include_host         = true
+        include_protocol     = true
+        include_query_string = true
+        include_http_headers = ["X-Feature-Flag"]
+      }
+    }
+  }
+
   # Attach Cloud Armor security policy
   security_policy = var.security_policy_self_link
 
@@ -42,20 +65,34 @@ resource "google_compute_backend_service" "backend_service" {
 
 # URL Map
 resource "google_compute_url_map" "url_map" {
-  name            = "hello-world-url-map"
+  name            = "hello-world-url-map-${var.environment}${local.name_suffix}"
   description     = "URL map for hello world application"
   default_service = google_compute_backend_service.backend_service.id
 }
 
+# URL Map that sends plain HTTP requests to their HTTPS equivalent
+resource "google_compute_url_map" "https_redirect" {
+  count = var.https_redirect ? 1 : 0
+
+  name        = "hello-world-https-redirect-${var.environment}${local.name_suffix}"
+  description = "Redirects HTTP to HTTPS for hello world application"
+
+  default_url_redirect {
+    https_redirect         = true
+    redirect_response_code = "MOVED_PERMANENTLY_DEFAULT"
+    strip_query            = false
+  }
+}
+
 # HTTP Target Proxy
 resource "google_compute_target_http_proxy" "http_proxy" {
-  name    = "hello-world-http-proxy"
-  url_map = google_compute_url_map.url_map.id
+  name    = "hello-world-http-proxy-${var.environment}${local.name_suffix}"
+  url_map = var.https_redirect ? google_compute_url_map.https_redirect[0].id : google_compute_url_map.url_map.id
 }
 
 # Global Forwarding Rule (HTTP)
 resource "google_compute_global_forwarding_rule" "http_forwarding_rule" {
-  name       = "hello-world-http-forwarding-rule"
+  name       = "hello-world-http-forwarding-rule-${var.environment}${local.name_suffix}"
   target     = google_compute_target_http_proxy.http_proxy.id
   port_range = "80"
   ip_protocol = "TCP"
@@ -63,7 +100,7 @@ resource "google_compute_global_forwarding_rule" "http_forwarding_rule" {
 
 # Optional: SSL Certificate and HTTPS setup
 resource "google_compute_managed_ssl_certificate" "ssl_cert" {
-  name = "hello-world-ssl-cert"
+  name = "hello-world-ssl-cert-${var.environment}${local.name_suffix}"
 
   managed {
     domains = [var.domain_name != "" ? var.domain_name : "example.com"]
@@ -72,14 +109,14 @@ resource "google_compute_managed_ssl_certificate" "ssl_cert" {
 
 # HTTPS Target Proxy
 resource "google_compute_target_https_proxy" "https_proxy" {
-  name             = "hello-world-https-proxy"
+  name             = "hello-world-https-proxy-${var.environment}${local.name_suffix}"
   url_map          = google_compute_url_map.url_map.id
   ssl_certificates = [google_compute_managed_ssl_certificate.ssl_cert.id]
 }
 
 # Global Forwarding Rule (HTTPS)
 resource "google_compute_global_forwarding_rule" "https_forwarding_rule" {
-  name       = "hello-world-https-forwarding-rule"
+  name       = "hello-world-https-forwarding-rule-${var.environment}${local.name_suffix}"
   target     = google_compute_target_https_proxy.https_proxy.id
   port_range = "443"
   ip_protocol = "TCP"
@@ -26,4 +26,9 @@ output "backend_service" {
 output "backend_service_id" {
   description = "The ID of the backend service"
   value       = google_compute_backend_service.backend_service.id
-} 
\ No newline at end of file
+} 
+
+output "backend_service_name" {
+  description = "The name of the backend service"
+  value       = google_compute_backend_service.backend_service.name
+}
@@ -22,4 +22,33 @@ variable "domain_name" {
   description = "Domain name for SSL certificate (optional)"
   type        = string
   default     = ""
-} 
\ No newline at end of file
+} 
+
+variable "environment" {
+  description = "Environment name (dev, test, prd), part of every resource name so environments can share a project"
+  type        = string
+}
+
+variable "name_suffix" {
+  description = "Appended to resource names, after a dash, so concurrent deployments into one project don't collide"
+  type        = string
+  default     = ""
+}
+
+variable "https_redirect" {
+  description = "Redirect plain HTTP requests to HTTPS instead of serving them; needs a real domain_name for the certificate"
+  type        = bool
+  default     = false
+}
+
+variable "enable_cdn" {
+  description = "Serve responses through Cloud CDN, caching each for cdn_default_ttl seconds"
+  type        = bool
+  default     = false
+}
+
+variable "cdn_default_ttl" {
+  description = "Seconds Cloud CDN caches a response for when enable_cdn is set"
+  type        = number
+  default     = 60
+}
@@ -0,0 +1,463 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gruntwork-io/terratest/modules/logger"
+	"github.com/gruntwork-io/terratest/modules/retry"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+
+	"hello-world-test/gcptest"
+)
+
+// initAndApply runs terraform init and apply, skipping the test when the
+// project isn't set up for deployments. It returns false when init failed.
+func initAndApply(t testing.TB, terraformOptions *terraform.Options) bool {
+	t.Helper()
+
+	if !initTerraform(t, terraformOptions) {
+		return false
+	}
+
+	applyTerraform(t, terraformOptions)
+	return true
+}
+
+// initTerraform runs terraform init and reports whether it succeeded.
+func initTerraform(t testing.TB, terraformOptions *terraform.Options) bool {
+	t.Helper()
+
+	// Note: This will fail if APIs are not enabled or billing is not configured
+	// The test serves to validate the terraform configuration syntax and dependencies
+	start := time.Now()
+	err := initWithRetry(t, terraformOptions, defaultInitAttempts)
+	if err != nil {
+		t.Logf("Terraform init failed (expected if APIs not enabled): %v", err)
+		return false
+	}
+
+	// Logged so the effect of a plugin cache on provider downloads shows up
+	pluginCache := terraformOptions.EnvVars["TF_PLUGIN_CACHE_DIR"]
+	if pluginCache == "" {
+		pluginCache = "none, set TF_PLUGIN_CACHE_DIR to reuse providers"
+	}
+	t.Logf("Terraform init took %s (plugin cache: %s)", time.Since(start).Round(time.Millisecond), pluginCache)
+	return true
+}
+
+// defaultInitAttempts is how many times initTerraform runs terraform init
+// before giving up on transient errors.
+const defaultInitAttempts = 4
+
+// transientInitErrors are substrings of terraform init failures caused by the
+// network or a partial provider download rather than by the configuration.
+var transientInitErrors = []string{
+	"connection reset by peer",
+	"connection refused",
+	"i/o timeout",
+	"TLS handshake timeout",
+	"Client.Timeout exceeded",
+	"unexpected EOF",
+	"no such host",
+	"502 Bad Gateway",
+	"503 Service Unavailable",
+	"504 Gateway Timeout",
+	"incorrect checksum",
+	"checksum mismatch",
+	"doesn't match any of the checksums",
+}
+
+// initWithRetry runs terraform init up to attempts times, backing off between
+// attempts, as long as each failure is transient (see isTransientInitError).
+// Any other error is returned straight away.
+func initWithRetry(t testing.TB, terraformOptions *terraform.Options, attempts int) error {
+	t.Helper()
+
+	// Retry here only, not in terratest's own retry loop as well
+	initOptions, err := terraformOptions.Clone()
+	if err != nil {
+		return fmt.Errorf("could not copy terraform options: %w", err)
+	}
+	initOptions.MaxRetries = 0
+
+	for attempt := 1; ; attempt++ {
+		_, err = terraform.InitE(t, initOptions)
+		if err == nil || !isTransientInitError(err) {
+			return err
+		}
+		if attempt >= attempts {
+			return fmt.Errorf("terraform init still failing after %d attempts: %w", attempts, err)
+		}
+		backoff := gcptest.BackoffWithJitter(attempt)
+		t.Logf("Terraform init failed with a transient error, retrying in %s (attempt %d/%d): %v", backoff, attempt, attempts, err)
+		time.Sleep(backoff)
+	}
+}
+
+// isTransientInitError reports whether a terraform init failure looks like a
+// network problem or an interrupted provider download.
+func isTransientInitError(err error) bool {
+	return containsAny(err.Error(), transientInitErrors)
+}
+
+func TestIsTransientInitError(t *testing.T) {
+	cases := []struct {
+		name      string
+		err       string
+		transient bool
+	}{
+		{"connection reset", `Error: Failed to install provider: Error while installing hashicorp/google v4.85.0: read tcp 10.0.0.2:51234->140.82.112.4:443: read: connection reset by peer`, true},
+		{"registry timeout", `Error: Failed to query available provider packages: could not connect to registry.terraform.io: Get "https://registry.terraform.io/v1/providers/hashicorp/google/versions": net/http: request canceled (Client.Timeout exceeded while awaiting headers)`, true},
+		{"dns", `Error: Failed to query available provider packages: dial tcp: lookup registry.terraform.io: no such host`, true},
+		{"partial download", `Error: Failed to install provider: Error while installing hashicorp/google v4.85.0: archive has incorrect checksum zh:0123 (expected zh:4567)`, true},
+		{"registry outage", `Error: Failed to query available provider packages: registry.terraform.io responded with 503 Service Unavailable`, true},
+		{"unknown provider", `Error: Failed to query available provider packages: provider registry registry.terraform.io does not have a provider named registry.terraform.io/hashicorp/googel`, false},
+		{"version constraint", `Error: Failed to query available provider packages: no available releases match the given constraints ~> 99.0`, false},
+		{"syntax", `Error: Argument or block definition required on main.tf line 3`, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.transient, isTransientInitError(errors.New(tc.err)))
+		})
+	}
+}
+
+// applyTerraform runs terraform apply within the TF_APPLY_TIMEOUT budget, in
+// the TF_WORKSPACE workspace when one is set and none was pinned explicitly.
+// Errors are sorted by classifyApplyError into skips, for projects that
+// aren't set up for the test, and failures.
+func applyTerraform(t testing.TB, terraformOptions *terraform.Options) {
+	t.Helper()
+
+	if err := runApply(t, terraformOptions); err != nil {
+		reportApplyError(t, terraformOptions, err)
+	}
+}
+
+// runApply is applyTerraform without the skips and failures.
+func runApply(t testing.TB, terraformOptions *terraform.Options) error {
+	t.Helper()
+
+	useEnvWorkspace(t, terraformOptions)
+	return applyWithTimeout(t, terraformOptions, envDuration(t, "TF_APPLY_TIMEOUT", defaultApplyTimeout))
+}
+
+// reportApplyError skips or fails the test for an apply error, as
+// classifyApplyError decides.
+func reportApplyError(t testing.TB, terraformOptions *terraform.Options, err error) {
+	t.Helper()
+
+	decision := classifyApplyError(err)
+	if decision.checkPermissions {
+		projectID, _ := terraformOptions.Vars["project_id"].(string)
+		missing, checkErr := gcptest.MissingProjectPermissions(projectID, deployPermissions)
+		decision = permissionDeniedDecision(missing, checkErr)
+	}
+	if decision.Skip {
+		t.Skipf("Skipping test due to %s: %v", decision.Reason, err)
+	}
+	t.Fatalf("%s: %v", decision.Reason, err)
+}
+
+// skipReason is classifyApplyError's verdict on an apply error. Skip is set
+// when the error says the project or credentials aren't set up for the test
+// rather than that the configuration is broken; Reason describes the error.
+// checkPermissions marks permission errors, which only skip once
+// permissionDeniedDecision confirms the caller's own credentials are short.
+type skipReason struct {
+	Skip             bool
+	Reason           string
+	checkPermissions bool
+}
+
+// applyErrorClasses are checked in order, so more specific matches (a
+// billing 403) come before broader ones (any 403).
+var applyErrorClasses = []struct {
+	matches func(msg string) bool
+	skipReason
+}{
+	{
+		func(msg string) bool { return strings.Contains(msg, "billing") },
+		skipReason{Skip: true, Reason: "billing account issue"},
+	},
+	{
+		func(msg string) bool {
+			return strings.Contains(msg, "SERVICE_DISABLED") ||
+				(strings.Contains(msg, "API") && strings.Contains(msg, "not been used"))
+		},
+		skipReason{Skip: true, Reason: "API not enabled"},
+	},
+	{
+		func(msg string) bool { return containsAny(msg, quotaExceededErrors) },
+		skipReason{Skip: true, Reason: "quota exceeded"},
+	},
+	{
+		func(msg string) bool { return containsAny(msg, permissionDeniedErrors) },
+		skipReason{Reason: "Terraform apply was denied permission", checkPermissions: true},
+	},
+	{
+		func(msg string) bool { return containsAny(msg, alreadyExistsErrors) },
+		skipReason{Reason: "Terraform apply failed because a resource already exists (leaked by an earlier run? see TestCleanupOrphans)"},
+	},
+}
+
+// Substrings GCP and the Google provider use for each class of apply error.
+var (
+	quotaExceededErrors    = []string{"Quota exceeded", "QUOTA_EXCEEDED", "quotaExceeded", "Error 429"}
+	permissionDeniedErrors = []string{"Error 403", "PERMISSION_DENIED", "Permission denied", "does not have permission"}
+	alreadyExistsErrors    = []string{"Error 409", "alreadyExists", "already exists"}
+)
+
+// classifyApplyError decides whether an error from applyWithTimeout should
+// skip or fail the test. Timeouts and unrecognized errors fail.
+func classifyApplyError(err error) skipReason {
+	if errors.Is(err, errApplyTimeout) {
+		return skipReason{Reason: "Terraform apply hung"}
+	}
+
+	msg := err.Error()
+	for _, class := range applyErrorClasses {
+		if class.matches(msg) {
+			return class.skipReason
+		}
+	}
+	return skipReason{Reason: "Terraform apply failed"}
+}
+
+// deployPermissions are the project permissions deploying the dev
+// environment needs, one per kind of resource it manages.
+var deployPermissions = []string{
+	"cloudfunctions.functions.create",
+	"cloudfunctions.functions.setIamPolicy",
+	"compute.backendServices.create",
+	"compute.globalForwardingRules.create",
+	"compute.healthChecks.create",
+	"compute.networkEndpointGroups.create",
+	"compute.securityPolicies.create",
+	"compute.sslCertificates.create",
+	"compute.targetHttpProxies.create",
+	"compute.targetHttpsProxies.create",
+	"compute.urlMaps.create",
+	"iam.serviceAccounts.actAs",
+	"iam.serviceAccounts.create",
+	"logging.sinks.create",
+	"serviceusage.services.enable",
+	"storage.buckets.create",
+	"storage.objects.create",
+}
+
+// permissionDeniedDecision turns a permission error into a skip only when the
+// caller's credentials lack some of deployPermissions (missing). When they
+// hold them all, the denial comes from the configuration, e.g. a role it
+// grants or a service account it acts as, and the test fails; so does an
+// error checkErr from checking them.
+func permissionDeniedDecision(missing []string, checkErr error) skipReason {
+	if checkErr != nil {
+		return skipReason{Reason: fmt.Sprintf("Terraform apply was denied permission (could not check the caller's permissions: %v)", checkErr)}
+	}
+	if len(missing) > 0 {
+		return skipReason{Skip: true, Reason: "missing permissions " + strings.Join(missing, ", ")}
+	}
+	return skipReason{Reason: "Terraform apply was denied permission although the credentials hold every deploy permission"}
+}
+
+func TestPermissionDeniedDecision(t *testing.T) {
+	decision := permissionDeniedDecision([]string{"iam.serviceAccounts.create"}, nil)
+	assert.True(t, decision.Skip)
+	assert.Contains(t, decision.Reason, "iam.serviceAccounts.create")
+
+	decision = permissionDeniedDecision(nil, nil)
+	assert.False(t, decision.Skip, "Denials the caller's credentials don't explain are failures")
+	assert.Contains(t, decision.Reason, "denied permission")
+
+	decision = permissionDeniedDecision(nil, errors.New("rpc error: code = Unavailable"))
+	assert.False(t, decision.Skip)
+	assert.Contains(t, decision.Reason, "Unavailable")
+}
+
+func containsAny(s string, substrings []string) bool {
+	for _, substring := range substrings {
+		if strings.Contains(s, substring) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestClassifyApplyError(t *testing.T) {
+	cases := []struct {
+		name     string
+		err      error
+		skip     bool
+		contains string
+	}{
+		{"timeout", fmt.Errorf("%w after 10m0s", errApplyTimeout), false, "hung"},
+		{"billing", errors.New("Error 403: The billing account for the owning project is disabled in state absent"), true, "billing"},
+		{"api disabled", errors.New("Error 403: Cloud Functions API has not been used in project 123 before or it is disabled"), true, "API not enabled"},
+		{"service disabled", errors.New("googleapi: Error 403: ..., reason: SERVICE_DISABLED"), true, "API not enabled"},
+		{"region", errors.New("Error 400: Invalid location: moon-central1"), false, "Terraform apply failed"},
+		{"quota", errors.New("Error 403: Quota exceeded for quota metric 'Write requests', quotaExceeded"), true, "quota"},
+		{"rate limit", errors.New("googleapi: Error 429: Too many requests"), true, "quota"},
+		{"permission", errors.New("Error 403: Permission 'iam.serviceAccounts.create' denied on resource, forbidden, PERMISSION_DENIED"), false, "denied permission"},
+		{"already exists", errors.New("Error 409: Service account hello-world-dev already exists within project, alreadyExists"), false, "already exists"},
+		{"other", errors.New("Error: Unsupported argument"), false, "Terraform apply failed"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			decision := classifyApplyError(tc.err)
+			assert.Equal(t, tc.skip, decision.Skip)
+			assert.Contains(t, decision.Reason, tc.contains)
+		})
+	}
+}
+
+// defaultApplyTimeout bounds a single terraform apply unless TF_APPLY_TIMEOUT is set.
+const defaultApplyTimeout = 10 * time.Minute
+
+// errApplyTimeout is wrapped by applyWithTimeout when apply exceeds its budget,
+// as opposed to apply itself reporting an error.
+var errApplyTimeout = errors.New("terraform apply timed out")
+
+// applyInterruptGrace is how long an interrupted terraform apply gets to save
+// its state and release the lock before it is killed.
+const applyInterruptGrace = 2 * time.Minute
+
+// applyWithTimeout runs terraform apply, with terraform.ApplyE's arguments and
+// retries, and interrupts it once timeout has passed. It only returns after
+// terraform has exited, so the best-effort destroy that follows a timeout
+// never races the apply; the deferred destroy in the test gets another go at
+// whatever that destroy leaves behind.
+func applyWithTimeout(t testing.TB, terraformOptions *terraform.Options, timeout time.Duration) error {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	options, args := terraform.GetCommonOptions(terraformOptions, terraform.FormatArgs(terraformOptions,
+		append([]string{"apply", "-input=false", "-auto-approve"}, terraformOptions.ExtraArgs.Apply...)...)...)
+	_, err := retry.DoWithRetryableErrorsE(t, "terraform apply", options.RetryableTerraformErrors,
+		options.MaxRetries, options.TimeBetweenRetries, func() (string, error) {
+			return runTerraformContext(ctx, t, options, args...)
+		})
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		t.Logf("Terraform apply exceeded %s, attempting best-effort destroy", timeout)
+		if _, err := terraform.DestroyE(t, terraformOptions); err != nil {
+			t.Logf("Best-effort destroy after apply timeout failed: %v", err)
+		}
+		return fmt.Errorf("%w after %s", errApplyTimeout, timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("terraform apply failed: %w", err)
+	}
+	return nil
+}
+
+// runTerraformContext runs the terraform CLI of options with args, logging
+// its output through options.Logger, and returns that output. When ctx is
+// done terraform is interrupted, as Ctrl-C would, and killed if it is still
+// running applyInterruptGrace later.
+func runTerraformContext(ctx context.Context, t testing.TB, options *terraform.Options, args ...string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	cmd := exec.CommandContext(ctx, options.TerraformBinary, args...)
+	cmd.Dir = options.TerraformDir
+	cmd.Env = os.Environ()
+	for key, value := range options.EnvVars {
+		cmd.Env = append(cmd.Env, key+"="+value)
+	}
+	cmd.Cancel = func() error { return cmd.Process.Signal(os.Interrupt) }
+	cmd.WaitDelay = applyInterruptGrace
+
+	var output bytes.Buffer
+	log := &loggerWriter{t: t, logger: options.Logger}
+	cmd.Stdout = io.MultiWriter(&output, log)
+	cmd.Stderr = io.MultiWriter(&output, log)
+
+	err := cmd.Run()
+	log.flush()
+	return output.String(), err
+}
+
+// loggerWriter logs what is written to it through logger one line at a time,
+// holding back a trailing partial line until flush.
+type loggerWriter struct {
+	t       testing.TB
+	logger  *logger.Logger
+	mu      sync.Mutex
+	partial []byte
+}
+
+func (w *loggerWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		w.log(string(w.partial[:i]))
+		w.partial = w.partial[i+1:]
+	}
+}
+
+func (w *loggerWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.partial) > 0 {
+		w.log(string(w.partial))
+		w.partial = nil
+	}
+}
+
+func (w *loggerWriter) log(line string) {
+	if w.logger == nil {
+		logger.Default.Logf(w.t, "%s", line)
+		return
+	}
+	w.logger.Logf(w.t, "%s", line)
+}
+
+func TestApplyWithTimeoutStopsTerraform(t *testing.T) {
+	dir := t.TempDir()
+	pidFile := filepath.Join(dir, "apply.pid")
+	// A stand-in for terraform whose apply hangs and whose destroy succeeds
+	script := filepath.Join(dir, "terraform")
+	body := "#!/bin/sh\nif [ \"$1\" = apply ]; then echo $$ > " + pidFile + "; exec sleep 60; fi\n"
+	if err := os.WriteFile(script, []byte(body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	options := &terraform.Options{TerraformBinary: script, TerraformDir: dir, Logger: logger.Discard, NoColor: true}
+	start := time.Now()
+	err := applyWithTimeout(t, options, 500*time.Millisecond)
+	assert.ErrorIs(t, err, errApplyTimeout)
+	assert.Less(t, time.Since(start), 30*time.Second, "The hung apply should be interrupted, not waited out")
+
+	data, readErr := os.ReadFile(pidFile)
+	if assert.NoError(t, readErr, "The stand-in apply should have started") {
+		pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+		assert.ErrorIs(t, syscall.Kill(pid, 0), syscall.ESRCH, "The apply should have exited before applyWithTimeout returned")
+	}
+}
@@ -0,0 +1,243 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	http_helper "github.com/gruntwork-io/terratest/modules/http-helper"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+
+	"hello-world-test/gcptest"
+)
+
+// metadataIdentityURL is the metadata server endpoint that mints identity
+// tokens for the instance's service account.
+const metadataIdentityURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/identity"
+
+// getIdentityToken returns a GCP identity token for audience, asking the
+// metadata server first (when running on GCP) and falling back to gcloud.
+func getIdentityToken(t *testing.T, audience string) string {
+	t.Helper()
+
+	client := &http.Client{Timeout: 2 * time.Second}
+	req, err := http.NewRequest(http.MethodGet, metadataIdentityURL+"?audience="+url.QueryEscape(audience), nil)
+	if err == nil {
+		req.Header.Set("Metadata-Flavor", "Google")
+		if resp, err := client.Do(req); err == nil {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK && len(body) > 0 {
+				return strings.TrimSpace(string(body))
+			}
+		}
+	}
+
+	// Service accounts can request a specific audience; user credentials can't,
+	// so retry without it before giving up
+	for _, args := range [][]string{
+		{"auth", "print-identity-token", "--audiences=" + audience},
+		{"auth", "print-identity-token"},
+	} {
+		out, err := exec.Command("gcloud", args...).Output()
+		if err == nil && len(strings.TrimSpace(string(out))) > 0 {
+			return strings.TrimSpace(string(out))
+		}
+	}
+
+	t.Fatalf("Could not mint an identity token for %s from the metadata server or gcloud", audience)
+	return ""
+}
+
+// authHeaders returns the Authorization header for calling a private function at functionURL.
+func authHeaders(t *testing.T, functionURL string) map[string]string {
+	t.Helper()
+
+	return map[string]string{"Authorization": "Bearer " + getIdentityToken(t, functionURL)}
+}
+
+// authedHttpGet performs a GET against a private function using an identity
+// token and returns the status code and body.
+func authedHttpGet(t *testing.T, functionURL string) (int, string) {
+	t.Helper()
+
+	return http_helper.HTTPDo(t, http.MethodGet, functionURL, nil, authHeaders(t, functionURL), nil)
+}
+
+// withImpersonation makes every terraform command run through opts act as
+// saEmail. Pair it with gcptest.Impersonate so the client helpers use the same
+// identity.
+func withImpersonation(opts *terraform.Options, saEmail string) {
+	if opts.EnvVars == nil {
+		opts.EnvVars = map[string]string{}
+	}
+	opts.EnvVars[gcptest.ImpersonateEnvVar] = saEmail
+}
+
+// identityFixtureDir holds a configuration that only outputs the identity the
+// Google provider runs as.
+const identityFixtureDir = "testdata/identity"
+
+// TestImpersonation checks that impersonating IMPERSONATE_SA (a service
+// account email) takes effect for both the client helpers and Terraform. It is
+// skipped when IMPERSONATE_SA is unset or the caller can't impersonate it, and
+// can't run in parallel since gcptest.Impersonate is process-wide.
+func TestImpersonation(t *testing.T) {
+	projectID := gcptest.GetProjectID(t)
+
+	saEmail := os.Getenv("IMPERSONATE_SA")
+	if saEmail == "" {
+		t.Skip("Skipping impersonation test: set IMPERSONATE_SA to a service account email")
+	}
+
+	gcptest.Impersonate(t, saEmail)
+	identity, err := gcptest.ActiveIdentity(context.Background())
+	if err != nil {
+		t.Skipf("Skipping impersonation test: could not act as %s; the caller needs "+
+			"roles/iam.serviceAccountTokenCreator on it: %v", saEmail, err)
+	}
+	assert.Equal(t, saEmail, identity, "Client helpers should run as the impersonated service account")
+
+	// A copy keeps the fixture's state out of the source tree
+	fixtureDir := t.TempDir()
+	config, err := os.ReadFile(filepath.Join(identityFixtureDir, "main.tf"))
+	if err != nil {
+		t.Fatalf("Could not read the identity fixture: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(fixtureDir, "main.tf"), config, 0o644); err != nil {
+		t.Fatalf("Could not copy the identity fixture: %v", err)
+	}
+
+	terraformOptions := buildOptions(t, fixtureDir, map[string]interface{}{
+		"project_id": projectID,
+	})
+	withImpersonation(terraformOptions, saEmail)
+
+	terraform.InitAndApply(t, terraformOptions)
+	assert.Equal(t, saEmail, terraform.Output(t, terraformOptions, "email"),
+		"Terraform should run as the impersonated service account")
+}
+
+func TestWithImpersonation(t *testing.T) {
+	opts := &terraform.Options{}
+	withImpersonation(opts, "tester@my-project.iam.gserviceaccount.com")
+
+	assert.Equal(t, "tester@my-project.iam.gserviceaccount.com", opts.EnvVars[gcptest.ImpersonateEnvVar])
+}
+
+// defaultAPIKeyHeader is the header TestAPIKeyEnforced sends the key in
+// unless API_KEY_HEADER says otherwise.
+const defaultAPIKeyHeader = "X-API-Key"
+
+// TestAPIKeyEnforced checks that the endpoint at API_KEY_URL only answers
+// requests carrying the key in API_KEY. The key is read from the environment
+// so it never lives in the repository; the test is skipped without both.
+func TestAPIKeyEnforced(t *testing.T) {
+	endpoint, validKey := os.Getenv("API_KEY_URL"), os.Getenv("API_KEY")
+	if endpoint == "" || validKey == "" {
+		t.Skip("Skipping API key test: set API_KEY_URL and API_KEY to a key-gated endpoint and a valid key")
+	}
+
+	headerName := os.Getenv("API_KEY_HEADER")
+	if headerName == "" {
+		headerName = defaultAPIKeyHeader
+	}
+	assertValidURL(t, endpoint)
+	assertAPIKeyEnforced(t, endpoint, headerName, validKey)
+}
+
+// assertAPIKeyEnforced GETs url without a key, with a wrong key and with
+// validKey in headerName, and fails the test, naming every case that
+// misbehaved, unless the first two are refused with a 401 or 403 and the
+// last one answers 200.
+func assertAPIKeyEnforced(t testing.TB, url, headerName, validKey string) {
+	t.Helper()
+
+	problems, err := apiKeyProblems(url, headerName, validKey)
+	if err != nil {
+		t.Fatalf("Could not check the API key of %s: %v", url, err)
+	}
+	if len(problems) > 0 {
+		t.Errorf("%s does not enforce its %s API key:\n  %s", url, headerName, strings.Join(problems, "\n  "))
+	}
+}
+
+// apiKeyProblems returns what assertAPIKeyEnforced would report for url.
+// Keys are never included, so the report can't leak the valid one.
+func apiKeyProblems(url, headerName, validKey string) ([]string, error) {
+	cases := []struct {
+		name   string
+		key    string
+		wantOK bool
+	}{
+		{"no key", "", false},
+		{"wrong key", "wrong-" + validKey, false},
+		{"valid key", validKey, true},
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	var problems []string
+	for _, c := range cases {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		if c.key != "" {
+			req.Header.Set(headerName, c.key)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("request with %s failed: %w", c.name, err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		refused := resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden
+		switch {
+		case c.wantOK && resp.StatusCode != http.StatusOK:
+			problems = append(problems, fmt.Sprintf("request with %s got %d, expected 200", c.name, resp.StatusCode))
+		case !c.wantOK && !refused:
+			problems = append(problems, fmt.Sprintf("request with %s got %d, expected 401 or 403", c.name, resp.StatusCode))
+		}
+	}
+	return problems, nil
+}
+
+func TestAPIKeyProblems(t *testing.T) {
+	gated := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get(defaultAPIKeyHeader) {
+		case "":
+			w.WriteHeader(http.StatusUnauthorized)
+		case "secret":
+		default:
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer gated.Close()
+
+	problems, err := apiKeyProblems(gated.URL, defaultAPIKeyHeader, "secret")
+	assert.NoError(t, err)
+	assert.Empty(t, problems)
+
+	// Any key at all gets in
+	open := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(defaultAPIKeyHeader) == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer open.Close()
+
+	problems, err = apiKeyProblems(open.URL, defaultAPIKeyHeader, "secret")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"request with wrong key got 200, expected 401 or 403"}, problems)
+}
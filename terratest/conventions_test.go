@@ -0,0 +1,247 @@
+package test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	tfjson "github.com/hashicorp/terraform-json"
+	"github.com/stretchr/testify/assert"
+)
+
+// requiredLabelsEnvVar lists, comma separated, the labels every resource
+// that supports labels must carry, e.g. env,owner,cost-center.
+const requiredLabelsEnvVar = "REQUIRED_LABELS"
+
+// resourceNamePrefixEnvVar is the prefix every resource name must start with.
+const resourceNamePrefixEnvVar = "RESOURCE_NAME_PREFIX"
+
+// assertResourceConventionsFromEnv runs assertResourceConventions with the
+// labels in REQUIRED_LABELS and the prefix in RESOURCE_NAME_PREFIX. Neither
+// is enforced unless set, since the dev environment predates the convention.
+func assertResourceConventionsFromEnv(t testing.TB, state *tfjson.State) {
+	t.Helper()
+
+	var requiredLabels []string
+	for _, label := range strings.Split(os.Getenv(requiredLabelsEnvVar), ",") {
+		if label = strings.TrimSpace(label); label != "" {
+			requiredLabels = append(requiredLabels, label)
+		}
+	}
+	namePrefix := os.Getenv(resourceNamePrefixEnvVar)
+	if len(requiredLabels) == 0 && namePrefix == "" {
+		t.Logf("Not checking resource conventions: set %s or %s to enforce them", requiredLabelsEnvVar, resourceNamePrefixEnvVar)
+		return
+	}
+	assertResourceConventions(t, state, requiredLabels, namePrefix)
+}
+
+// nameConventionExemptTypes are resource types whose name attribute isn't
+// held to the name prefix, with the reason why.
+var nameConventionExemptTypes = map[string]string{
+	"google_storage_bucket":        "bucket names share one global namespace, so they lead with the project ID",
+	"google_storage_bucket_object": "object names are paths within a bucket, not resource names",
+}
+
+// assertResourceConventions fails the test, listing every violation at once,
+// unless each resource in state that supports labels has all of
+// requiredLabels and each named resource's name, other than those of
+// nameConventionExemptTypes, starts with namePrefix. An empty namePrefix
+// skips the name check.
+func assertResourceConventions(t testing.TB, state *tfjson.State, requiredLabels []string, namePrefix string) {
+	t.Helper()
+
+	if problems := resourceConventionProblems(state, requiredLabels, namePrefix); len(problems) > 0 {
+		t.Errorf("%d resource convention violation(s):\n  %s", len(problems), strings.Join(problems, "\n  "))
+	}
+}
+
+// resourceConventionProblems describes every violation assertResourceConventions
+// reports. A resource type supports labels when its state has a labels
+// attribute, set or not.
+func resourceConventionProblems(state *tfjson.State, requiredLabels []string, namePrefix string) []string {
+	var problems []string
+	for _, resource := range managedResources(state) {
+		if labelValue, ok := resource.AttributeValues["labels"]; ok && len(requiredLabels) > 0 {
+			labels, _ := labelValue.(map[string]interface{})
+			var missing []string
+			for _, label := range requiredLabels {
+				if value, _ := labels[label].(string); value == "" {
+					missing = append(missing, label)
+				}
+			}
+			if len(missing) > 0 {
+				problems = append(problems, fmt.Sprintf("%s is missing label(s) %s", resource.Address, strings.Join(missing, ", ")))
+			}
+		}
+		if _, exempt := nameConventionExemptTypes[resource.Type]; exempt {
+			continue
+		}
+		if name, ok := resource.AttributeValues["name"].(string); ok && namePrefix != "" && !strings.HasPrefix(name, namePrefix) {
+			problems = append(problems, fmt.Sprintf("%s is named %q, which does not start with %q", resource.Address, name, namePrefix))
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+func TestResourceConventionProblems(t *testing.T) {
+	state := &tfjson.State{Values: &tfjson.StateValues{RootModule: &tfjson.StateModule{
+		Resources: []*tfjson.StateResource{{
+			Address: "google_compute_backend_service.backend_service",
+			Type:    "google_compute_backend_service",
+			Mode:    tfjson.ManagedResourceMode,
+			// Backend services have no labels, only a name
+			AttributeValues: map[string]interface{}{"name": "hello-world-backend"},
+		}, {
+			Address:         "google_compute_region_network_endpoint_group.neg",
+			Type:            "google_compute_region_network_endpoint_group",
+			Mode:            tfjson.ManagedResourceMode,
+			AttributeValues: map[string]interface{}{"name": "cloud-function-neg"},
+		}, {
+			Address:         "google_compute_global_forwarding_rule.http_forwarding_rule",
+			Type:            "google_compute_global_forwarding_rule",
+			Mode:            tfjson.ManagedResourceMode,
+			AttributeValues: map[string]interface{}{"name": "hello-world-http-forwarding-rule", "labels": nil},
+		}},
+		ChildModules: []*tfjson.StateModule{{
+			Resources: []*tfjson.StateResource{{
+				Address: "module.cloud_function.google_cloudfunctions_function.hello_world",
+				Type:    "google_cloudfunctions_function",
+				Mode:    tfjson.ManagedResourceMode,
+				AttributeValues: map[string]interface{}{
+					"name":   "hello-world-dev",
+					"labels": map[string]interface{}{"env": "dev", "owner": "platform", "cost-center": "1234"},
+				},
+			}, {
+				Address: "module.cloud_function.google_storage_bucket.function_source",
+				Type:    "google_storage_bucket",
+				Mode:    tfjson.ManagedResourceMode,
+				AttributeValues: map[string]interface{}{
+					"name":   "my-project-function-source",
+					"labels": map[string]interface{}{"env": "dev", "owner": ""},
+				},
+			}, {
+				Address:         "module.cloud_function.google_storage_bucket_object.function_source",
+				Type:            "google_storage_bucket_object",
+				Mode:            tfjson.ManagedResourceMode,
+				AttributeValues: map[string]interface{}{"name": "function-source-0123abcd.zip"},
+			}},
+		}},
+	}}}
+
+	assert.Equal(t, []string{
+		`google_compute_global_forwarding_rule.http_forwarding_rule is missing label(s) env, owner, cost-center`,
+		`google_compute_region_network_endpoint_group.neg is named "cloud-function-neg", which does not start with "hello-world"`,
+		`module.cloud_function.google_storage_bucket.function_source is missing label(s) owner, cost-center`,
+	}, resourceConventionProblems(state, []string{"env", "owner", "cost-center"}, "hello-world"))
+
+	assert.Empty(t, resourceConventionProblems(state, nil, ""), "Nothing is required by default")
+}
+
+// environmentNameExemptTypes are resource types whose name need not include
+// the environment, because it is only unique within a parent that does.
+var environmentNameExemptTypes = map[string]bool{
+	"google_storage_bucket_object": true,
+}
+
+// environmentNameAttributes are the attributes GCP resources are named by.
+var environmentNameAttributes = []string{"name", "account_id"}
+
+// TestResourceNamesIncludeEnvironment checks that every resource the modules
+// name, other than environmentNameExemptTypes, has var.environment in its
+// name. TestAllEnvironments applies every environment in parallel into one
+// project, where a name two environments share makes one apply fail.
+func TestResourceNamesIncludeEnvironment(t *testing.T) {
+	modules, err := filepath.Glob("../modules/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range modules {
+		problems, err := environmentNameProblems(dir)
+		if err != nil {
+			t.Fatalf("Could not read %s: %v", dir, err)
+		}
+		for _, problem := range problems {
+			t.Error(problem)
+		}
+	}
+}
+
+// environmentNameProblems lists the resources in the .tf files of dir whose
+// name attribute doesn't reference var.environment.
+func environmentNameProblems(dir string) ([]string, error) {
+	bodies, err := parseTerraformFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	for _, body := range bodies {
+		syntaxBody, ok := body.(*hclsyntax.Body)
+		if !ok {
+			continue
+		}
+		for _, block := range syntaxBody.Blocks {
+			if block.Type != "resource" || len(block.Labels) != 2 || environmentNameExemptTypes[block.Labels[0]] {
+				continue
+			}
+			for _, name := range environmentNameAttributes {
+				attribute, ok := block.Body.Attributes[name]
+				if ok && !referencesVariable(attribute.Expr, "environment") {
+					problems = append(problems, fmt.Sprintf("%s: %s.%s %s does not include var.environment",
+						dir, block.Labels[0], block.Labels[1], name))
+				}
+			}
+		}
+	}
+	return problems, nil
+}
+
+// referencesVariable reports whether expr refers to var.<variable>.
+func referencesVariable(expr hclsyntax.Expression, variable string) bool {
+	for _, traversal := range expr.Variables() {
+		if traversal.RootName() != "var" || len(traversal) < 2 {
+			continue
+		}
+		if attr, ok := traversal[1].(hcl.TraverseAttr); ok && attr.Name == variable {
+			return true
+		}
+	}
+	return false
+}
+
+func TestEnvironmentNameProblems(t *testing.T) {
+	dir := t.TempDir()
+	config := `resource "google_compute_url_map" "shared" {
+  name = "hello-world-url-map${local.name_suffix}"
+}
+
+resource "google_compute_url_map" "per_environment" {
+  name = "hello-world-url-map-${var.environment}${local.name_suffix}"
+}
+
+resource "google_service_account" "function" {
+  account_id = "hello-world-${var.name_suffix}"
+}
+
+resource "google_storage_bucket_object" "source" {
+  name = "source.zip"
+}
+`
+	if err := os.WriteFile(filepath.Join(dir, "main.tf"), []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err := environmentNameProblems(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		dir + ": google_compute_url_map.shared name does not include var.environment",
+		dir + ": google_service_account.function account_id does not include var.environment",
+	}, problems)
+}
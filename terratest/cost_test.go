@@ -0,0 +1,150 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+
+	"hello-world-test/gcptest"
+)
+
+// infracostBinary prices Terraform plans; see https://www.infracost.io.
+const infracostBinary = "infracost"
+
+// defaultCostBudgetUSD is the monthly cost a dev deployment may reach unless
+// COST_BUDGET_USD says otherwise. An idle function scaled to zero costs
+// close to nothing, so the budget is mostly the load balancer.
+const defaultCostBudgetUSD = 50.0
+
+// TestCostBudget plans the dev environment and fails when Infracost prices
+// it above COST_BUDGET_USD a month, to catch a change that would provision
+// something expensive by mistake. Without Infracost, or its API key, the test
+// is skipped before anything is initialized.
+func TestCostBudget(t *testing.T) {
+	projectID := gcptest.GetProjectID(t)
+	requireInfracost(t)
+
+	terraformOptions := buildOptions(t, devEnvironmentDir, map[string]interface{}{
+		"project_id": projectID,
+	})
+
+	if !initTerraform(t, terraformOptions) {
+		return
+	}
+	// Price a fresh deployment, not whatever the default workspace has
+	withWorkspace(t, terraformOptions, "terratest-cost")
+	defer deleteWorkspace(t, terraformOptions)
+
+	assertCostUnder(t, terraformOptions, envFloat(t, "COST_BUDGET_USD", defaultCostBudgetUSD))
+}
+
+// assertCostUnder fails the test when estimateCost puts the deployment in
+// terraformOptions above maxUSD a month.
+func assertCostUnder(t *testing.T, terraformOptions *terraform.Options, maxUSD float64) {
+	t.Helper()
+
+	cost := estimateCost(t, terraformOptions)
+	if cost > maxUSD {
+		t.Errorf("Planned deployment costs an estimated $%.2f a month, over the $%.2f budget", cost, maxUSD)
+		return
+	}
+	t.Logf("Planned deployment costs an estimated $%.2f a month (budget $%.2f)", cost, maxUSD)
+}
+
+// requireInfracost skips the test, logging that cost gating is unavailable,
+// unless Infracost is installed and has an API key, from INFRACOST_API_KEY
+// or `infracost auth login`.
+func requireInfracost(t *testing.T) {
+	t.Helper()
+
+	if _, err := exec.LookPath(infracostBinary); err != nil {
+		t.Skipf("Cost gating unavailable: %s is not installed", infracostBinary)
+	}
+	if os.Getenv("INFRACOST_API_KEY") != "" {
+		return
+	}
+	out, err := exec.Command(infracostBinary, "configure", "get", "api_key").Output()
+	if err != nil || strings.TrimSpace(string(out)) == "" {
+		t.Skipf("Cost gating unavailable: %s has no API key, set INFRACOST_API_KEY or run infracost auth login", infracostBinary)
+	}
+}
+
+// estimateCost plans terraformOptions and returns Infracost's estimate of the
+// planned resources' monthly cost in USD. Call requireInfracost first; an
+// API key Infracost rejects still skips the test.
+func estimateCost(t *testing.T, terraformOptions *terraform.Options) float64 {
+	t.Helper()
+
+	dir := t.TempDir()
+	terraformOptions.PlanFilePath = filepath.Join(dir, "plan.out")
+	defer func() { terraformOptions.PlanFilePath = "" }()
+	terraform.Plan(t, terraformOptions)
+
+	planJSON := filepath.Join(dir, "plan.json")
+	if err := os.WriteFile(planJSON, []byte(terraform.Show(t, terraformOptions)), 0o644); err != nil {
+		t.Fatalf("Could not write the plan for %s: %v", infracostBinary, err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(infracostBinary, "breakdown", "--path", planJSON, "--format", "json", "--no-color")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		output := strings.TrimSpace(stderr.String())
+		if strings.Contains(output, "INFRACOST_API_KEY") || strings.Contains(output, "infracost auth login") {
+			t.Skipf("Cost gating unavailable: %s has no API key: %s", infracostBinary, output)
+		}
+		t.Fatalf("%s breakdown failed: %v %s", infracostBinary, err, output)
+	}
+
+	cost, err := parseInfracostTotal(stdout.Bytes())
+	if err != nil {
+		t.Fatalf("Could not read the %s estimate: %v", infracostBinary, err)
+	}
+	return cost
+}
+
+// parseInfracostTotal returns the total monthly cost from the JSON output of
+// `infracost breakdown --format json`. Infracost leaves it null when nothing
+// in the plan has a price, which counts as free.
+func parseInfracostTotal(out []byte) (float64, error) {
+	var breakdown struct {
+		Currency         string  `json:"currency"`
+		TotalMonthlyCost *string `json:"totalMonthlyCost"`
+	}
+	if err := json.Unmarshal(out, &breakdown); err != nil {
+		return 0, fmt.Errorf("parsing infracost output: %w", err)
+	}
+	if breakdown.Currency != "" && breakdown.Currency != "USD" {
+		return 0, fmt.Errorf("infracost reports costs in %s, not USD", breakdown.Currency)
+	}
+	if breakdown.TotalMonthlyCost == nil {
+		return 0, nil
+	}
+	return strconv.ParseFloat(*breakdown.TotalMonthlyCost, 64)
+}
+
+func TestParseInfracostTotal(t *testing.T) {
+	cost, err := parseInfracostTotal([]byte(`{"version": "0.2", "currency": "USD", "totalMonthlyCost": "18.2650", "projects": []}`))
+	assert.NoError(t, err)
+	assert.Equal(t, 18.265, cost)
+
+	cost, err = parseInfracostTotal([]byte(`{"currency": "USD", "totalMonthlyCost": null}`))
+	assert.NoError(t, err)
+	assert.Zero(t, cost, "A plan without priced resources is free")
+
+	_, err = parseInfracostTotal([]byte(`{"currency": "EUR", "totalMonthlyCost": "3"}`))
+	assert.Error(t, err, "Budgets are in USD")
+
+	_, err = parseInfracostTotal([]byte(`not json`))
+	assert.Error(t, err)
+}
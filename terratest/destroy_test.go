@@ -0,0 +1,179 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+)
+
+// destroyAndVerify destroys the deployment, checks nothing was left behind and
+// deletes the test's workspace with deleteWorkspace. With
+// PRESERVE_ON_FAILURE=1 a failed test's deployment, and its workspace, are
+// kept instead, see preserveOnFailure.
+func destroyAndVerify(t testing.TB, terraformOptions *terraform.Options) {
+	t.Helper()
+
+	if preserveOnFailure(t, terraformOptions) {
+		resetWorkspace(t, terraformOptions)
+		return
+	}
+	destroyWithTimeout(t, terraformOptions, envDuration(t, "TF_DESTROY_TIMEOUT", defaultDestroyTimeout))
+	assertNoResidualResources(t, terraformOptions)
+	deleteWorkspace(t, terraformOptions)
+}
+
+// defaultDestroyTimeout bounds a terraform destroy unless TF_DESTROY_TIMEOUT is set.
+const defaultDestroyTimeout = 8 * time.Minute
+
+// destroyWithTimeout runs terraform destroy to completion and logs how long it
+// took. A destroy slower than budget fails the test once it has finished,
+// rather than being abandoned halfway, since it holds up CI and often points
+// at resources deleted in the wrong order.
+func destroyWithTimeout(t testing.TB, terraformOptions *terraform.Options, budget time.Duration) {
+	t.Helper()
+
+	start := time.Now()
+	_, err := terraform.DestroyE(t, terraformOptions)
+	elapsed := time.Since(start).Round(time.Second)
+	if err != nil {
+		t.Fatalf("Terraform destroy failed after %s: %v", elapsed, err)
+	}
+	if elapsed > budget {
+		t.Errorf("Terraform destroy took %s, over the %s budget (TF_DESTROY_TIMEOUT)", elapsed, budget)
+		return
+	}
+	t.Logf("Terraform destroy took %s (budget %s)", elapsed, budget)
+}
+
+// preserveOnFailureEnvVar keeps the deployment of a failed test around for
+// debugging instead of destroying it.
+const preserveOnFailureEnvVar = "PRESERVE_ON_FAILURE"
+
+// preserveOnFailure reports whether t failed with PRESERVE_ON_FAILURE=1 set,
+// in which case its deployment should be left in place. It logs where the
+// state is and the command that destroys the deployment by hand.
+func preserveOnFailure(t testing.TB, terraformOptions *terraform.Options) bool {
+	t.Helper()
+
+	if !t.Failed() || os.Getenv(preserveOnFailureEnvVar) != "1" {
+		return false
+	}
+	t.Logf("Test failed and %s=1 is set, preserving its deployment. State: %s", preserveOnFailureEnvVar, statePath(terraformOptions))
+	t.Logf("Destroy it when done with:\n  %s", destroyCommand(terraformOptions))
+	return true
+}
+
+// statePath returns where the state of the workspace terraformOptions is
+// pinned to lives: an object in the state bucket for the gcs backend, the
+// local state file otherwise.
+func statePath(terraformOptions *terraform.Options) string {
+	workspace := pinnedWorkspace(terraformOptions)
+	if workspace == "" {
+		workspace = "default"
+	}
+
+	if backend, err := readBackend(terraformOptions.TerraformDir); err == nil && backend.Type == "gcs" {
+		object := workspace + ".tfstate"
+		if prefix := strings.Trim(backend.Config["prefix"], "/"); prefix != "" {
+			object = prefix + "/" + object
+		}
+		return "gs://" + backend.Config["bucket"] + "/" + object
+	}
+	if workspace == "default" {
+		return filepath.Join(terraformOptions.TerraformDir, "terraform.tfstate")
+	}
+	return filepath.Join(terraformOptions.TerraformDir, "terraform.tfstate.d", workspace, "terraform.tfstate")
+}
+
+// destroyCommand returns a shell command that destroys the deployment
+// terraformOptions describes, with the same workspace and variables.
+func destroyCommand(terraformOptions *terraform.Options) string {
+	args := terraform.FormatArgs(terraformOptions, "destroy")
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellQuote(arg)
+	}
+
+	command := shellQuote(terraformOptions.TerraformBinary) + " " + strings.Join(quoted, " ")
+	if workspace := pinnedWorkspace(terraformOptions); workspace != "" {
+		command = "TF_WORKSPACE=" + shellQuote(workspace) + " " + command
+	}
+	return "cd " + shellQuote(terraformOptions.TerraformDir) + " && " + command
+}
+
+// shellQuote single-quotes s for a POSIX shell unless it is made only of
+// characters the shell leaves alone.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_=./:@,") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func TestPreserveOnFailureCommands(t *testing.T) {
+	// A backend fixture rather than an environment, so renaming a real state
+	// bucket does not break this test
+	backendDir := t.TempDir()
+	backend := `terraform {
+  backend "gcs" {
+    bucket = "terratest-state"
+    prefix = "/fixture/terraform/state/"
+  }
+}
+`
+	if err := os.WriteFile(filepath.Join(backendDir, "backend.tf"), []byte(backend), 0o644); err != nil {
+		t.Fatalf("Could not write the backend fixture: %v", err)
+	}
+
+	terraformOptions := &terraform.Options{
+		TerraformDir:    backendDir,
+		TerraformBinary: "terraform",
+		Vars:            map[string]interface{}{"project_id": "my-project"},
+		EnvVars:         map[string]string{"TF_WORKSPACE": "terratest-ingress"},
+	}
+
+	assert.Equal(t, "gs://terratest-state/fixture/terraform/state/terratest-ingress.tfstate", statePath(terraformOptions))
+	assert.Equal(t, "cd "+shellQuote(backendDir)+" && TF_WORKSPACE=terratest-ingress terraform destroy -var project_id=my-project -lock=false",
+		destroyCommand(terraformOptions))
+
+	// Without a backend block the state stays next to the configuration
+	terraformOptions.TerraformDir = t.TempDir()
+	assert.Equal(t, filepath.Join(terraformOptions.TerraformDir, "terraform.tfstate.d", "terratest-ingress", "terraform.tfstate"),
+		statePath(terraformOptions))
+	terraformOptions.EnvVars = nil
+	assert.Equal(t, filepath.Join(terraformOptions.TerraformDir, "terraform.tfstate"), statePath(terraformOptions))
+	assert.Equal(t, `'it'\''s here'`, shellQuote("it's here"))
+}
+
+// assertNoResidualResources fails the test with the leftover resource
+// addresses when the state still tracks anything after destroy.
+func assertNoResidualResources(t testing.TB, terraformOptions *terraform.Options) {
+	t.Helper()
+
+	out, err := terraform.RunTerraformCommandAndGetStdoutE(t, terraformOptions, "state", "list")
+	if err != nil {
+		t.Errorf("Could not list state after destroy, check for orphaned resources manually: %v", err)
+		return
+	}
+
+	if leftovers := parseStateList(out); len(leftovers) > 0 {
+		t.Errorf("Destroy left %d resource(s) behind, clean them up manually:\n  %s",
+			len(leftovers), strings.Join(leftovers, "\n  "))
+	}
+}
+
+// parseStateList returns the resource addresses printed by `terraform state list`.
+func parseStateList(out string) []string {
+	var addresses []string
+	for _, line := range strings.Split(out, "\n") {
+		if address := strings.TrimSpace(line); address != "" {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
@@ -0,0 +1,482 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	tfjson "github.com/hashicorp/terraform-json"
+	"github.com/stretchr/testify/assert"
+
+	"hello-world-test/gcptest"
+)
+
+// Policy limits for the deployed function and its source bucket, checked by
+// TestFunctionRuntimeConfig.
+const (
+	expectedFunctionRuntime   = "python310"
+	maxFunctionMemoryMB       = 256
+	maxFunctionTimeoutSeconds = 60
+	maxSourceRetentionDays    = 30
+)
+
+func TestFunctionRuntimeConfig(t *testing.T) {
+	projectID := gcptest.GetProjectID(t)
+
+	terraformOptions := buildOptions(t, devEnvironmentDir, map[string]interface{}{
+		"project_id": projectID,
+	})
+
+	if !initTerraform(t, terraformOptions) {
+		return
+	}
+	withWorkspace(t, terraformOptions, "terratest-runtime")
+	defer destroyAndVerify(t, terraformOptions)
+
+	applyTerraform(t, terraformOptions)
+
+	state := readState(t, terraformOptions)
+	assertFunctionEnvVars(t, state, map[string]string{"ENV": "dev"}, forbiddenFunctionEnvVars)
+	assertSecretsFromSecretManager(t, state, requiredFunctionSecrets)
+	assertIngressSettings(t, state, ingressAllowAll)
+	devThresholds := thresholdsFor(devEnvironment.name)
+	assertInstanceScaling(t, state, devThresholds.minInstances, devThresholds.maxInstances)
+
+	sourceDir := os.Getenv("FUNCTION_SOURCE_DIR")
+	if sourceDir == "" {
+		sourceDir = defaultFunctionSourceDir
+	}
+	expectedSHA, err := gcptest.SourceContentHash(sourceDir, functionSourceFiles)
+	if err != nil {
+		t.Fatalf("Could not hash the function source in %s: %v", sourceDir, err)
+	}
+	assertDeployedSourceHash(t, state, expectedSHA)
+	sourceBucket := getRequiredOutput(t, terraformOptions, "source_bucket_name")
+	gcptest.AssertBucketLifecycle(t, projectID, sourceBucket, maxSourceRetentionDays)
+	assertSourceBucketRegion(t, projectID, sourceBucket, state)
+
+	functions := stateResources(state, "google_cloudfunctions_function")
+	if len(functions) != 1 {
+		t.Fatalf("Expected exactly one Cloud Function in state, found %d", len(functions))
+	}
+	function := functions[0]
+
+	assert.Equal(t, expectedFunctionRuntime, function.AttributeValues["runtime"], "Function runtime")
+	assertRuntimeSupported(t, state, deprecatedFunctionRuntimes)
+
+	memory, _ := function.AttributeValues["available_memory_mb"].(float64)
+	assert.Greater(t, memory, 0.0, "Function memory should be set")
+	assert.LessOrEqual(t, memory, float64(maxFunctionMemoryMB), "Function memory is over policy")
+
+	timeout, _ := function.AttributeValues["timeout"].(float64)
+	assert.Greater(t, timeout, 0.0, "Function timeout should be set")
+	assert.LessOrEqual(t, timeout, float64(maxFunctionTimeoutSeconds), "Function timeout is over policy")
+}
+
+// assertSourceBucketRegion fails the test unless bucketName, the function's
+// source bucket, is in the region the Cloud Function in state runs in.
+func assertSourceBucketRegion(t testing.TB, projectID, bucketName string, state *tfjson.State) {
+	t.Helper()
+
+	function, err := deployedFunction(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	region, _ := function.AttributeValues["region"].(string)
+	if region == "" {
+		region, _ = function.AttributeValues["location"].(string)
+	}
+	if region == "" {
+		t.Fatalf("%s has no region in state", function.Address)
+	}
+	gcptest.AssertBucketRegion(t, projectID, bucketName, region)
+}
+
+// deprecatedFunctionRuntimes are Cloud Functions runtimes past their
+// deprecation date on https://cloud.google.com/functions/docs/runtime-support.
+// Add a runtime here once Google deprecates it, so deployments still on it
+// fail before it is decommissioned.
+var deprecatedFunctionRuntimes = []string{
+	"python37", "python38", "python39",
+	"nodejs6", "nodejs8", "nodejs10", "nodejs12", "nodejs14", "nodejs16",
+	"go111", "go113", "go116", "go118",
+	"java11",
+	"ruby26", "ruby27", "ruby30",
+	"php74", "php81",
+	"dotnet3",
+}
+
+// runtimeSupportURL lists the supported runtimes and their upgrade guides.
+const runtimeSupportURL = "https://cloud.google.com/functions/docs/runtime-support"
+
+// assertRuntimeSupported fails the test when the Cloud Function in state
+// runs on one of the deprecated runtimes.
+func assertRuntimeSupported(t testing.TB, state *tfjson.State, deprecated []string) {
+	t.Helper()
+
+	address, runtime, err := functionRuntime(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runtimeDeprecation(runtime, deprecated); err != nil {
+		t.Errorf("%s: %v", address, err)
+	}
+}
+
+// functionRuntime returns the address and runtime of the Cloud Function in
+// state, read from build_config for a 2nd gen function.
+func functionRuntime(state *tfjson.State) (string, string, error) {
+	function, err := deployedFunction(state)
+	if err != nil {
+		return "", "", err
+	}
+	attributes := function.AttributeValues
+	if function.Type == "google_cloudfunctions2_function" {
+		buildConfig, _ := attributes["build_config"].([]interface{})
+		if len(buildConfig) == 0 {
+			return "", "", fmt.Errorf("%s has no build_config", function.Address)
+		}
+		attributes, _ = buildConfig[0].(map[string]interface{})
+	}
+	runtime, _ := attributes["runtime"].(string)
+	if runtime == "" {
+		return "", "", fmt.Errorf("%s has no runtime", function.Address)
+	}
+	return function.Address, runtime, nil
+}
+
+// runtimeDeprecation returns an error pointing at the upgrade path when
+// runtime is one of deprecated.
+func runtimeDeprecation(runtime string, deprecated []string) error {
+	for _, d := range deprecated {
+		if runtime == d {
+			return fmt.Errorf("runtime %s is deprecated; move the function to a supported runtime of the same language, see %s",
+				runtime, runtimeSupportURL)
+		}
+	}
+	return nil
+}
+
+func TestAssertRuntimeSupported(t *testing.T) {
+	// Trimmed from `terraform show -json` of a 1st gen deployment
+	blob := `{
+  "format_version": "1.0",
+  "values": {"root_module": {"child_modules": [{
+    "address": "module.hello_world_infrastructure.module.cloud_function",
+    "resources": [{
+      "address": "module.hello_world_infrastructure.module.cloud_function.google_cloudfunctions_function.hello_world",
+      "mode": "managed",
+      "type": "google_cloudfunctions_function",
+      "name": "hello_world",
+      "values": {"name": "hello-world-dev", "runtime": "python38", "entry_point": "hello_world"}
+    }]
+  }]}}
+}`
+	var state tfjson.State
+	if err := json.Unmarshal([]byte(blob), &state); err != nil {
+		t.Fatal(err)
+	}
+
+	address, runtime, err := functionRuntime(&state)
+	assert.NoError(t, err)
+	assert.Equal(t, "module.hello_world_infrastructure.module.cloud_function.google_cloudfunctions_function.hello_world", address)
+	assert.Equal(t, "python38", runtime)
+
+	assert.ErrorContains(t, runtimeDeprecation(runtime, deprecatedFunctionRuntimes), "runtime python38 is deprecated")
+	assert.ErrorContains(t, runtimeDeprecation(runtime, deprecatedFunctionRuntimes), runtimeSupportURL)
+	assertRuntimeSupported(t, &state, []string{"python37"})
+
+	gen2 := &tfjson.State{Values: &tfjson.StateValues{RootModule: &tfjson.StateModule{Resources: []*tfjson.StateResource{{
+		Address:         "google_cloudfunctions2_function.hello_world",
+		Mode:            tfjson.ManagedResourceMode,
+		Type:            "google_cloudfunctions2_function",
+		AttributeValues: map[string]interface{}{"build_config": []interface{}{map[string]interface{}{"runtime": expectedFunctionRuntime}}},
+	}}}}}
+	_, runtime, err = functionRuntime(gen2)
+	assert.NoError(t, err)
+	assert.Equal(t, expectedFunctionRuntime, runtime)
+	assertRuntimeSupported(t, gen2, deprecatedFunctionRuntimes)
+}
+
+// defaultFunctionSourceDir holds the function source unless
+// FUNCTION_SOURCE_DIR points elsewhere; functionSourceFiles are the files the
+// cloud_function module zips into the source archive.
+const defaultFunctionSourceDir = "../modules/cloud_function"
+
+var functionSourceFiles = []string{"main.py", "requirements.txt"}
+
+// assertDeployedSourceHash downloads the source archive the Cloud Function in
+// state was deployed from and checks that its content hash (see
+// gcptest.ArchiveContentHash) is expectedSHA. A mismatch means the archive in
+// the bucket is stale.
+func assertDeployedSourceHash(t testing.TB, state *tfjson.State, expectedSHA string) {
+	t.Helper()
+
+	bucket, object := deployedArchive(t, state)
+	deployedSHA, err := gcptest.ArchiveContentHash(gcptest.DownloadObject(t, bucket, object))
+	if err != nil {
+		t.Fatalf("Could not hash gs://%s/%s: %v", bucket, object, err)
+	}
+	assert.Equal(t, expectedSHA, deployedSHA, "gs://%s/%s does not match the local function source", bucket, object)
+}
+
+// deployedArchive returns the bucket and object of the source archive the
+// Cloud Function in state was deployed from.
+func deployedArchive(t testing.TB, state *tfjson.State) (bucket, object string) {
+	t.Helper()
+
+	functions := stateResources(state, "google_cloudfunctions_function")
+	if len(functions) != 1 {
+		t.Fatalf("Expected exactly one Cloud Function in state, found %d", len(functions))
+	}
+	bucket, _ = functions[0].AttributeValues["source_archive_bucket"].(string)
+	object, _ = functions[0].AttributeValues["source_archive_object"].(string)
+	if bucket == "" || object == "" {
+		t.Fatalf("Function in state has no source archive (bucket %q, object %q)", bucket, object)
+	}
+	return bucket, object
+}
+
+// revisionTimeout bounds how long tests wait for a just-applied function to
+// serve its new code.
+const revisionTimeout = 5 * time.Minute
+
+// waitForRevision waits until functionName serves expectedRevision (see
+// gcptest.FunctionRevision) and fails the test, naming the revision still
+// being served, if it doesn't within timeout.
+func waitForRevision(t testing.TB, projectID, functionName, expectedRevision string, timeout time.Duration) {
+	t.Helper()
+
+	if err := gcptest.WaitForRevision(t, projectID, functionName, expectedRevision, timeout); err != nil {
+		t.Fatalf("Function is not serving the applied code: %v", err)
+	}
+}
+
+// deployedRevision is the revision the Cloud Function in state should be
+// serving: the gs:// URL of the archive it was deployed from.
+func deployedRevision(t testing.TB, state *tfjson.State) string {
+	t.Helper()
+
+	bucket, object := deployedArchive(t, state)
+	return fmt.Sprintf("gs://%s/%s", bucket, object)
+}
+
+// Ingress settings of the deployed function: open to the internet, or only
+// reachable through the load balancer and from inside the project's network.
+const (
+	ingressAllowAll           = "ALLOW_ALL"
+	ingressAllowInternalAndLB = "ALLOW_INTERNAL_AND_GCLB"
+)
+
+// assertIngressSettings checks that the Cloud Function in state has the
+// ingress_settings expected.
+func assertIngressSettings(t testing.TB, state *tfjson.State, expected string) {
+	t.Helper()
+
+	functions := stateResources(state, "google_cloudfunctions_function")
+	if len(functions) != 1 {
+		t.Fatalf("Expected exactly one Cloud Function in state, found %d", len(functions))
+	}
+	assert.Equal(t, expected, functions[0].AttributeValues["ingress_settings"], "Function ingress_settings")
+}
+
+// assertInstanceScaling checks that the Cloud Function in state scales
+// between minExpected and maxExpected instances, reading min_instances and
+// max_instances for a 1st gen function or service_config's instance counts
+// for a 2nd gen one.
+func assertInstanceScaling(t testing.TB, state *tfjson.State, minExpected, maxExpected int) {
+	t.Helper()
+
+	problem, err := instanceScalingProblem(state, minExpected, maxExpected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problem != "" {
+		t.Error(problem)
+	}
+}
+
+// instanceScalingProblem returns what assertInstanceScaling would report,
+// or "" when the function scales as expected.
+func instanceScalingProblem(state *tfjson.State, minExpected, maxExpected int) (string, error) {
+	function, attributes, err := functionRuntimeAttributes(state)
+	if err != nil {
+		return "", err
+	}
+	minKey, maxKey := "min_instances", "max_instances"
+	if function.Type == "google_cloudfunctions2_function" {
+		minKey, maxKey = "min_instance_count", "max_instance_count"
+	}
+
+	// Unset counts are null in state and mean the provider default of 0
+	minInstances, _ := attributes[minKey].(float64)
+	maxInstances, _ := attributes[maxKey].(float64)
+	if int(minInstances) != minExpected || int(maxInstances) != maxExpected {
+		return fmt.Sprintf("Expected %s to scale between %d and %d instances, it is configured for %d to %d",
+			function.Address, minExpected, maxExpected, int(minInstances), int(maxInstances)), nil
+	}
+	return "", nil
+}
+
+// functionRuntimeAttributes returns the one Cloud Function in state and the
+// attributes that configure how it runs: the resource's own for a 1st gen
+// function, its service_config for a 2nd gen one.
+func functionRuntimeAttributes(state *tfjson.State) (*tfjson.StateResource, map[string]interface{}, error) {
+	function, err := deployedFunction(state)
+	if err != nil {
+		return nil, nil, err
+	}
+	if function.Type != "google_cloudfunctions2_function" {
+		return function, function.AttributeValues, nil
+	}
+	serviceConfig, _ := function.AttributeValues["service_config"].([]interface{})
+	if len(serviceConfig) == 0 {
+		return nil, nil, fmt.Errorf("%s has no service_config", function.Address)
+	}
+	attributes, _ := serviceConfig[0].(map[string]interface{})
+	return function, attributes, nil
+}
+
+// deployedFunction returns the one 1st or 2nd gen Cloud Function in state.
+func deployedFunction(state *tfjson.State) (*tfjson.StateResource, error) {
+	functions := stateResources(state, "google_cloudfunctions_function")
+	if len(functions) == 0 {
+		functions = stateResources(state, "google_cloudfunctions2_function")
+	}
+	if len(functions) != 1 {
+		return nil, fmt.Errorf("expected exactly one Cloud Function in state, found %d", len(functions))
+	}
+	return functions[0], nil
+}
+
+// assertVPCConnector checks that the Cloud Function in state sends its
+// egress through expectedConnector with expectedEgress egress settings.
+func assertVPCConnector(t testing.TB, state *tfjson.State, expectedConnector, expectedEgress string) {
+	t.Helper()
+
+	function, attributes, err := functionRuntimeAttributes(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := vpcConnectorProblem(attributes, expectedConnector, expectedEgress); err != nil {
+		t.Errorf("%s: %v", function.Address, err)
+	}
+}
+
+// vpcConnectorProblem returns how a function's attributes differ from
+// routing egress through expectedConnector with expectedEgress, or nil when
+// they don't. A connector given by name matches the same connector's full
+// projects/.../connectors/<name> path.
+func vpcConnectorProblem(attributes map[string]interface{}, expectedConnector, expectedEgress string) error {
+	connector, _ := attributes["vpc_connector"].(string)
+	egress, _ := attributes["vpc_connector_egress_settings"].(string)
+	if connector == "" {
+		return fmt.Errorf("expected egress through VPC connector %s (%s), but no connector is configured (egress settings %q)",
+			expectedConnector, expectedEgress, egress)
+	}
+
+	sameConnector := connector == expectedConnector ||
+		!strings.Contains(expectedConnector, "/") && strings.HasSuffix(connector, "/connectors/"+expectedConnector) ||
+		!strings.Contains(connector, "/") && strings.HasSuffix(expectedConnector, "/connectors/"+connector)
+	if !sameConnector || egress != expectedEgress {
+		return fmt.Errorf("expected egress through VPC connector %s (%s), got %s (%s)",
+			expectedConnector, expectedEgress, connector, egress)
+	}
+	return nil
+}
+
+func TestAssertInstanceScaling(t *testing.T) {
+	firstGen := stateWithResources(&tfjson.StateResource{
+		Address:         "module.cloud_function.google_cloudfunctions_function.hello_world",
+		Type:            "google_cloudfunctions_function",
+		AttributeValues: map[string]interface{}{"min_instances": nil, "max_instances": 10.0},
+	})
+	assertInstanceScaling(t, firstGen, 0, 10)
+
+	secondGen := stateWithResources(&tfjson.StateResource{
+		Address: "module.cloud_function.google_cloudfunctions2_function.hello_world",
+		Type:    "google_cloudfunctions2_function",
+		AttributeValues: map[string]interface{}{"service_config": []interface{}{
+			map[string]interface{}{"min_instance_count": 1.0, "max_instance_count": 5.0},
+		}},
+	})
+	assertInstanceScaling(t, secondGen, 1, 5)
+
+	problem, err := instanceScalingProblem(firstGen, 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, "Expected module.cloud_function.google_cloudfunctions_function.hello_world to scale between 1 and 10 instances, it is configured for 0 to 10",
+		problem, "An unset min_instances is 0, not the 1 prd keeps warm")
+	problem, err = instanceScalingProblem(firstGen, 0, 20)
+	assert.NoError(t, err)
+	assert.Contains(t, problem, "between 0 and 20 instances, it is configured for 0 to 10")
+	problem, err = instanceScalingProblem(secondGen, 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, "Expected module.cloud_function.google_cloudfunctions2_function.hello_world to scale between 0 and 10 instances, it is configured for 1 to 5",
+		problem, "A 2nd gen function is read from service_config")
+
+	_, err = instanceScalingProblem(&tfjson.State{Values: &tfjson.StateValues{RootModule: &tfjson.StateModule{}}}, 0, 10)
+	assert.Error(t, err, "A state without a function can't be checked")
+}
+
+func TestVPCConnectorProblem(t *testing.T) {
+	const connector = "projects/my-project/locations/us-central1/connectors/private-egress"
+
+	assert.NoError(t, vpcConnectorProblem(map[string]interface{}{
+		"vpc_connector": connector, "vpc_connector_egress_settings": vpcEgressAllTraffic,
+	}, connector, vpcEgressAllTraffic))
+	assert.NoError(t, vpcConnectorProblem(map[string]interface{}{
+		"vpc_connector": connector, "vpc_connector_egress_settings": vpcEgressAllTraffic,
+	}, "private-egress", vpcEgressAllTraffic), "A connector name should match its full path")
+
+	assert.EqualError(t, vpcConnectorProblem(map[string]interface{}{
+		"vpc_connector": connector, "vpc_connector_egress_settings": "PRIVATE_RANGES_ONLY",
+	}, connector, vpcEgressAllTraffic),
+		"expected egress through VPC connector "+connector+" (ALL_TRAFFIC), got "+connector+" (PRIVATE_RANGES_ONLY)")
+	assert.ErrorContains(t, vpcConnectorProblem(map[string]interface{}{
+		"vpc_connector": "projects/my-project/locations/us-central1/connectors/other", "vpc_connector_egress_settings": vpcEgressAllTraffic,
+	}, "private-egress", vpcEgressAllTraffic), "got projects/my-project/locations/us-central1/connectors/other")
+
+	assert.ErrorContains(t, vpcConnectorProblem(map[string]interface{}{
+		"vpc_connector": nil, "vpc_connector_egress_settings": nil,
+	}, connector, vpcEgressAllTraffic), "no connector is configured")
+}
+
+// vpcConnectorEnvVar names an existing Serverless VPC Access connector in the
+// test region for TestFunctionVPCConnector.
+const vpcConnectorEnvVar = "VPC_CONNECTOR"
+
+// vpcEgressAllTraffic sends all of the function's egress, not only private
+// ranges, through its VPC connector.
+const vpcEgressAllTraffic = "ALL_TRAFFIC"
+
+// TestFunctionVPCConnector deploys the dev environment with all egress routed
+// through the connector VPC_CONNECTOR names, as an environment that reaches
+// private resources would be, and checks the deployed function uses it and
+// still answers. Creating a connector needs a VPC and a spare /28, so the
+// test uses an existing one and is skipped without it.
+func TestFunctionVPCConnector(t *testing.T) {
+	connector := os.Getenv(vpcConnectorEnvVar)
+	if connector == "" {
+		t.Skipf("Skipping VPC connector test: set %s to a connector in the test region", vpcConnectorEnvVar)
+	}
+	projectID := gcptest.GetProjectID(t)
+
+	terraformOptions := buildOptions(t, devEnvironmentDir, map[string]interface{}{
+		"project_id":                    projectID,
+		"vpc_connector":                 connector,
+		"vpc_connector_egress_settings": vpcEgressAllTraffic,
+	})
+
+	if !initTerraform(t, terraformOptions) {
+		return
+	}
+	withWorkspace(t, terraformOptions, "terratest-vpc")
+	defer destroyAndVerify(t, terraformOptions)
+
+	applyTerraform(t, terraformOptions)
+	assertVPCConnector(t, readState(t, terraformOptions), connector, vpcEgressAllTraffic)
+	checkFunctionURL(t, terraformOptions, devEnvironment)
+}
@@ -0,0 +1,178 @@
+package test
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	tfjson "github.com/hashicorp/terraform-json"
+	"github.com/stretchr/testify/assert"
+)
+
+// forbiddenFunctionEnvVars must never appear as plaintext environment
+// variables on the function; secrets belong in Secret Manager.
+var forbiddenFunctionEnvVars = []string{"API_KEY", "SECRET", "SECRET_KEY", "PASSWORD", "TOKEN", "GOOGLE_APPLICATION_CREDENTIALS"}
+
+// assertFunctionEnvVars checks the environment_variables of the Cloud Function
+// in state: every key in expected must be set to its value and none of
+// forbiddenKeys may be present.
+func assertFunctionEnvVars(t testing.TB, state *tfjson.State, expected map[string]string, forbiddenKeys []string) {
+	t.Helper()
+
+	functions := stateResources(state, "google_cloudfunctions_function")
+	if len(functions) != 1 {
+		t.Fatalf("Expected exactly one Cloud Function in state, found %d", len(functions))
+	}
+
+	envVars, _ := functions[0].AttributeValues["environment_variables"].(map[string]interface{})
+	for key, value := range expected {
+		actual, ok := envVars[key]
+		if assert.True(t, ok, "Function should set environment variable %s", key) {
+			assert.Equal(t, value, actual, "Function environment variable %s", key)
+		}
+	}
+	for _, key := range forbiddenKeys {
+		_, ok := envVars[key]
+		assert.False(t, ok, "Function must not expose %s as a plaintext environment variable", key)
+	}
+}
+
+// requiredFunctionSecrets are the environment variables the function must
+// read from Secret Manager. It needs none yet; list a key here together with
+// its secret_environment_variables entry in the cloud_function module.
+var requiredFunctionSecrets = []string{}
+
+// secretLookingSuffixes mark environment variable names that hold a secret,
+// so with requiredFunctionSecrets still empty a secret added as plaintext is
+// caught by its name.
+var secretLookingSuffixes = []string{"_KEY", "_PASSWORD", "_TOKEN", "_SECRET"}
+
+// assertSecretsFromSecretManager checks that every key in secretEnvKeys
+// reaches the Cloud Function in state through a secret_environment_variables
+// reference to a Secret Manager secret and version, that none of them is
+// also set as a plaintext environment variable, and that no plaintext
+// environment variable has a name ending in one of secretLookingSuffixes.
+func assertSecretsFromSecretManager(t testing.TB, state *tfjson.State, secretEnvKeys []string) {
+	t.Helper()
+
+	function, attributes, err := functionRuntimeAttributes(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems := secretProblems(attributes, secretEnvKeys); len(problems) > 0 {
+		t.Errorf("%s does not read its secrets from Secret Manager:\n  %s", function.Address, strings.Join(problems, "\n  "))
+	}
+}
+
+// secretProblems returns what assertSecretsFromSecretManager would report for
+// a function with attributes. Only key names are reported, never values.
+func secretProblems(attributes map[string]interface{}, secretEnvKeys []string) []string {
+	envVars, _ := attributes["environment_variables"].(map[string]interface{})
+	secrets := map[string]map[string]interface{}{}
+	entries, _ := attributes["secret_environment_variables"].([]interface{})
+	for _, entry := range entries {
+		if secret, ok := entry.(map[string]interface{}); ok {
+			key, _ := secret["key"].(string)
+			secrets[key] = secret
+		}
+	}
+
+	var problems []string
+	required := map[string]bool{}
+	for _, key := range secretEnvKeys {
+		required[key] = true
+	}
+	var plaintext []string
+	for key := range envVars {
+		plaintext = append(plaintext, key)
+	}
+	sort.Strings(plaintext)
+	for _, key := range plaintext {
+		if !required[key] && looksLikeSecret(key) {
+			problems = append(problems, fmt.Sprintf("%s looks like a secret but is set as a plaintext environment variable", key))
+		}
+	}
+
+	for _, key := range secretEnvKeys {
+		if _, ok := envVars[key]; ok {
+			problems = append(problems, fmt.Sprintf("%s is set as a plaintext environment variable", key))
+		}
+		secret, ok := secrets[key]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s has no secret_environment_variables entry", key))
+			continue
+		}
+		name, _ := secret["secret"].(string)
+		version, _ := secret["version"].(string)
+		if name == "" || version == "" {
+			problems = append(problems, fmt.Sprintf("%s references secret %q version %q, expected both to be set", key, name, version))
+		}
+	}
+	return problems
+}
+
+// looksLikeSecret reports whether an environment variable named key is named
+// like one holding a secret.
+func looksLikeSecret(key string) bool {
+	for _, suffix := range secretLookingSuffixes {
+		if strings.HasSuffix(strings.ToUpper(key), suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSecretProblems(t *testing.T) {
+	attributes := map[string]interface{}{
+		"environment_variables": map[string]interface{}{"ENV": "dev", "DB_PASSWORD": "hunter2"},
+		"secret_environment_variables": []interface{}{
+			map[string]interface{}{"key": "API_KEY", "project_id": "123456789", "secret": "hello-world-api-key", "version": "latest"},
+			map[string]interface{}{"key": "DB_PASSWORD", "project_id": "123456789", "secret": "db-password", "version": "3"},
+			map[string]interface{}{"key": "SIGNING_KEY", "project_id": "123456789", "secret": "signing-key", "version": ""},
+		},
+	}
+
+	assert.Equal(t, []string{"DB_PASSWORD looks like a secret but is set as a plaintext environment variable"},
+		secretProblems(attributes, []string{"API_KEY"}), "Secret-looking keys are caught without being required")
+	assert.Empty(t, secretProblems(map[string]interface{}{
+		"environment_variables": map[string]interface{}{"ENV": "dev", "KEYBOARD": "us"},
+	}, requiredFunctionSecrets))
+	assert.Equal(t, []string{
+		"GITHUB_TOKEN looks like a secret but is set as a plaintext environment variable",
+		"stripe_secret looks like a secret but is set as a plaintext environment variable",
+	}, secretProblems(map[string]interface{}{
+		"environment_variables": map[string]interface{}{"stripe_secret": "x", "GITHUB_TOKEN": "y", "ENV": "dev"},
+	}, requiredFunctionSecrets))
+	assert.Equal(t, []string{
+		"DB_PASSWORD is set as a plaintext environment variable",
+		`SIGNING_KEY references secret "signing-key" version "", expected both to be set`,
+		"WEBHOOK_TOKEN has no secret_environment_variables entry",
+	}, secretProblems(attributes, []string{"API_KEY", "DB_PASSWORD", "SIGNING_KEY", "WEBHOOK_TOKEN"}))
+	assert.NotContains(t, strings.Join(secretProblems(attributes, []string{"DB_PASSWORD"}), "\n"), "hunter2",
+		"Plaintext values must not end up in the report")
+
+	assert.Equal(t, []string{"API_KEY has no secret_environment_variables entry"},
+		secretProblems(map[string]interface{}{"secret_environment_variables": nil}, []string{"API_KEY"}))
+}
+
+func TestAssertFunctionEnvVars(t *testing.T) {
+	state := &tfjson.State{
+		Values: &tfjson.StateValues{
+			RootModule: &tfjson.StateModule{
+				ChildModules: []*tfjson.StateModule{{
+					Resources: []*tfjson.StateResource{{
+						Address: "module.cloud_function.google_cloudfunctions_function.hello_world",
+						Mode:    tfjson.ManagedResourceMode,
+						Type:    "google_cloudfunctions_function",
+						AttributeValues: map[string]interface{}{
+							"environment_variables": map[string]interface{}{"ENV": "dev", "ALLOWED_ORIGINS": "*"},
+						},
+					}},
+				}},
+			},
+		},
+	}
+
+	assertFunctionEnvVars(t, state, map[string]string{"ENV": "dev"}, forbiddenFunctionEnvVars)
+}
@@ -0,0 +1,303 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	http_helper "github.com/gruntwork-io/terratest/modules/http-helper"
+	"github.com/stretchr/testify/assert"
+
+	"hello-world-test/gcptest"
+)
+
+// featureFlagHeader opts a request into the function's beta behaviour.
+const featureFlagHeader = "X-Feature-Flag"
+
+// TestFunctionFeatureFlag checks that the function's response follows the
+// X-Feature-Flag header: beta callers get the "(beta)" suffix, others don't.
+func TestFunctionFeatureFlag(t *testing.T) {
+	projectID := gcptest.GetProjectID(t)
+
+	terraformOptions := buildOptions(t, devEnvironmentDir, map[string]interface{}{
+		"project_id": projectID,
+	})
+
+	if !initTerraform(t, terraformOptions) {
+		return
+	}
+	withWorkspace(t, terraformOptions, "terratest-feature-flag")
+	defer destroyAndVerify(t, terraformOptions)
+
+	applyTerraform(t, terraformOptions)
+
+	functionURL := checkFunctionRequest(t, terraformOptions, devEnvironment, functionRequest{
+		headers:      map[string]string{featureFlagHeader: "beta"},
+		expectedText: "(beta)",
+	})
+
+	_, body := http_helper.HTTPDo(t, http.MethodGet, functionURL, nil, nil, nil)
+	assert.NotContains(t, body, "(beta)", "Requests without the flag should get the regular greeting")
+}
+
+func TestFunctionErrorPaths(t *testing.T) {
+	projectID := gcptest.GetProjectID(t)
+
+	terraformOptions := buildOptions(t, devEnvironmentDir, map[string]interface{}{
+		"project_id": projectID,
+	})
+
+	if !initTerraform(t, terraformOptions) {
+		return
+	}
+	withWorkspace(t, terraformOptions, "terratest-error-paths")
+	defer destroyAndVerify(t, terraformOptions)
+
+	applyTerraform(t, terraformOptions)
+
+	functionURL := checkFunctionURL(t, terraformOptions, devEnvironment)
+	assertAllowedMethods(t, functionURL, functionAllowedMethods)
+
+	for _, method := range []string{http.MethodDelete, http.MethodPut, http.MethodPatch} {
+		t.Run(method, func(t *testing.T) {
+			statusCode, body := httpRequest(t, method, functionURL, nil)
+
+			assert.True(t, statusCode >= 400 && statusCode < 500,
+				"%s should be rejected with a 4xx, got %d (body: %q)", method, statusCode, body)
+
+			var errorBody map[string]interface{}
+			if err := json.Unmarshal([]byte(body), &errorBody); err != nil {
+				t.Fatalf("%s error response is not JSON: %v (body: %q)", method, err, body)
+			}
+			assert.Contains(t, errorBody, "error", "Error response should carry an error field")
+		})
+	}
+}
+
+// functionAllowedMethods are the methods the function serves; see
+// ALLOWED_METHODS in main.py, which also answers CORS preflights.
+var functionAllowedMethods = []string{http.MethodGet, http.MethodPost}
+
+// probedMethods are the methods assertAllowedMethods tries against an endpoint.
+var probedMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodPatch}
+
+// assertAllowedMethods sends each of probedMethods to url and fails the test,
+// listing every violation at once, unless the methods in allowed get a
+// non-405 response and the rest get a 405 whose Allow header lists the
+// allowed methods and none of the rejected ones.
+func assertAllowedMethods(t testing.TB, url string, allowed []string) {
+	t.Helper()
+
+	problems, err := methodProblems(url, allowed)
+	if err != nil {
+		t.Fatalf("Could not probe the methods of %s: %v", url, err)
+	}
+	if len(problems) > 0 {
+		t.Errorf("%s does not enforce its method allowlist %v:\n  %s", url, allowed, strings.Join(problems, "\n  "))
+	}
+}
+
+// methodProblems returns what assertAllowedMethods would report for url.
+func methodProblems(url string, allowed []string) ([]string, error) {
+	isAllowed := map[string]bool{}
+	for _, method := range allowed {
+		isAllowed[method] = true
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	var problems []string
+	for _, method := range probedMethods {
+		req, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("%s request failed: %w", method, err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		if isAllowed[method] {
+			if resp.StatusCode == http.StatusMethodNotAllowed {
+				problems = append(problems, fmt.Sprintf("%s is allowed but got 405", method))
+			}
+			continue
+		}
+		if resp.StatusCode != http.StatusMethodNotAllowed {
+			problems = append(problems, fmt.Sprintf("%s should get 405, got %d", method, resp.StatusCode))
+			continue
+		}
+
+		listed := map[string]bool{}
+		for _, m := range strings.Split(resp.Header.Get("Allow"), ",") {
+			listed[strings.ToUpper(strings.TrimSpace(m))] = true
+		}
+		for _, m := range probedMethods {
+			if isAllowed[m] != listed[m] {
+				problems = append(problems, fmt.Sprintf("%s got 405 with Allow %q, expected it to list exactly %v of %v",
+					method, resp.Header.Get("Allow"), allowed, probedMethods))
+				break
+			}
+		}
+	}
+	return problems, nil
+}
+
+func TestMethodProblems(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodPost:
+		case http.MethodPatch:
+			// Wrongly accepted
+		case http.MethodDelete:
+			// Rejected, but with an Allow header that omits POST
+			w.Header().Set("Allow", "GET, OPTIONS")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		default:
+			w.Header().Set("Allow", "GET, POST, OPTIONS")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer server.Close()
+
+	problems, err := methodProblems(server.URL, functionAllowedMethods)
+	if assert.NoError(t, err) && assert.Len(t, problems, 2) {
+		assert.Contains(t, problems[0], "DELETE got 405 with Allow")
+		assert.Equal(t, "PATCH should get 405, got 200", problems[1])
+	}
+
+	// Once PATCH is allowed, no Allow header lists it
+	problems, err = methodProblems(server.URL, []string{http.MethodGet, http.MethodPost, http.MethodPatch})
+	if assert.NoError(t, err) && assert.Len(t, problems, 2) {
+		assert.Contains(t, problems[0], "PUT got 405 with Allow")
+		assert.Contains(t, problems[1], "DELETE got 405 with Allow")
+	}
+}
+
+// corsAllowedOrigins is deployed as allowed_origins by TestFunctionCORS and
+// checked against the function's preflight responses.
+var corsAllowedOrigins = []string{"https://app.example.com", "https://admin.example.com"}
+
+func TestFunctionCORS(t *testing.T) {
+	projectID := gcptest.GetProjectID(t)
+
+	terraformOptions := buildOptions(t, devEnvironmentDir, map[string]interface{}{
+		"project_id":      projectID,
+		"allowed_origins": corsAllowedOrigins,
+	})
+
+	if !initTerraform(t, terraformOptions) {
+		return
+	}
+	withWorkspace(t, terraformOptions, "terratest-cors")
+	defer destroyAndVerify(t, terraformOptions)
+
+	applyTerraform(t, terraformOptions)
+
+	functionURL := checkFunctionURL(t, terraformOptions, devEnvironment)
+
+	for _, origin := range corsAllowedOrigins {
+		gcptest.AssertCORS(t, functionURL, origin, []string{http.MethodGet, http.MethodPost})
+	}
+	gcptest.AssertCORSRejected(t, functionURL, "https://evil.example.com")
+}
+
+// functionMaxRequestBytes is the body size limit TestFunctionRejectsLargeBody
+// deploys the function with.
+const functionMaxRequestBytes = 64 * 1024
+
+// TestFunctionRejectsLargeBody POSTs a body over the function's limit and
+// expects a 413 instead of a crash or timeout. LARGE_BODY_BYTES sets the
+// payload size, 1MB by default.
+func TestFunctionRejectsLargeBody(t *testing.T) {
+	projectID := gcptest.GetProjectID(t)
+
+	size := envInt(t, "LARGE_BODY_BYTES", 1<<20)
+	if size <= functionMaxRequestBytes {
+		t.Fatalf("LARGE_BODY_BYTES=%d must exceed the function's %d byte limit", size, functionMaxRequestBytes)
+	}
+
+	terraformOptions := buildOptions(t, devEnvironmentDir, map[string]interface{}{
+		"project_id":        projectID,
+		"max_request_bytes": functionMaxRequestBytes,
+	})
+
+	if !initTerraform(t, terraformOptions) {
+		return
+	}
+	withWorkspace(t, terraformOptions, "terratest-large-body")
+	defer destroyAndVerify(t, terraformOptions)
+
+	applyTerraform(t, terraformOptions)
+	functionURL := checkFunctionURL(t, terraformOptions, devEnvironment)
+
+	statusCode, body, err := gcptest.PostStream(functionURL, int64(size))
+	if err != nil {
+		t.Fatalf("POST of %d bytes to %s failed: %v", size, functionURL, err)
+	}
+	assert.Equal(t, http.StatusRequestEntityTooLarge, statusCode,
+		"A %d byte body should be rejected, got body %q", size, body)
+}
+
+// functionTimeoutMargin is how long after the configured timeout
+// TestFunctionTimeout still accepts the aborted response, covering request
+// routing and connection setup.
+const functionTimeoutMargin = 20 * time.Second
+
+// TestFunctionTimeout asks the function to sleep (via ?delay=) for longer than
+// the timeout recorded in state and expects Cloud Functions to abort the
+// request with a 408 or 504 within functionTimeoutMargin of that timeout.
+func TestFunctionTimeout(t *testing.T) {
+	projectID := gcptest.GetProjectID(t)
+
+	terraformOptions := buildOptions(t, devEnvironmentDir, map[string]interface{}{
+		"project_id": projectID,
+	})
+
+	if !initTerraform(t, terraformOptions) {
+		return
+	}
+	withWorkspace(t, terraformOptions, "terratest-timeout")
+	defer destroyAndVerify(t, terraformOptions)
+
+	applyTerraform(t, terraformOptions)
+	functionURL := checkFunctionURL(t, terraformOptions, devEnvironment)
+
+	functions := stateResources(readState(t, terraformOptions), "google_cloudfunctions_function")
+	if len(functions) != 1 {
+		t.Fatalf("Expected exactly one Cloud Function in state, found %d", len(functions))
+	}
+	timeoutSeconds, _ := functions[0].AttributeValues["timeout"].(float64)
+	if timeoutSeconds <= 0 {
+		t.Fatalf("Cloud Function in state has no timeout set")
+	}
+	timeout := time.Duration(timeoutSeconds) * time.Second
+
+	delay := timeout + functionTimeoutMargin
+	requestURL := withQuery(t, functionURL, url.Values{"delay": {strconv.Itoa(int(delay.Seconds()))}})
+
+	// The client outlives the delay so a function that ignores its timeout
+	// shows up as a late 200 rather than a client-side error
+	client := &http.Client{Timeout: delay + functionTimeoutMargin}
+	start := time.Now()
+	resp, err := client.Get(requestURL)
+	elapsed := time.Since(start)
+	if err != nil {
+		t.Fatalf("GET %s failed after %s: %v", requestURL, elapsed, err)
+	}
+	defer resp.Body.Close()
+
+	assert.Contains(t, []int{http.StatusRequestTimeout, http.StatusGatewayTimeout}, resp.StatusCode,
+		"A request sleeping %s should be aborted by the %s function timeout", delay, timeout)
+	assert.GreaterOrEqual(t, elapsed, timeout, "Request was aborted before the function timeout")
+	assert.LessOrEqual(t, elapsed, timeout+functionTimeoutMargin,
+		"Request was aborted more than %s after the %s function timeout", functionTimeoutMargin, timeout)
+}
@@ -0,0 +1,100 @@
+package gcptest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	compute "cloud.google.com/go/compute/apiv1"
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// WaitForBackendHealthy polls the health of every backend group attached to
+// the global backend service backendServiceName until all of them report
+// HEALTHY or timeout elapses, sleeping BackoffWithJitter between attempts.
+// Serverless NEGs don't support health checks, so a group that reports no
+// health at all counts as ready.
+func WaitForBackendHealthy(t testing.TB, projectID, backendServiceName string, timeout time.Duration) error {
+	t.Helper()
+
+	ctx := context.Background()
+	opts, err := clientOptions(ctx)
+	if err != nil {
+		return fmt.Errorf("could not create Compute backend services client: %w", err)
+	}
+	client, err := compute.NewBackendServicesRESTClient(ctx, opts...)
+	if err != nil {
+		return fmt.Errorf("could not create Compute backend services client: %w", err)
+	}
+	defer client.Close()
+
+	service, err := client.Get(ctx, &computepb.GetBackendServiceRequest{
+		Project:        projectID,
+		BackendService: backendServiceName,
+	})
+	if err != nil {
+		return fmt.Errorf("could not read backend service %s: %w", backendServiceName, err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	var lastProblems []string
+
+	for attempt := 1; ; attempt++ {
+		lastProblems = nil
+		for _, backend := range service.GetBackends() {
+			group := backend.GetGroup()
+			health, err := client.GetHealth(ctx, &computepb.GetHealthBackendServiceRequest{
+				Project:        projectID,
+				BackendService: backendServiceName,
+				ResourceGroupReferenceResource: &computepb.ResourceGroupReference{
+					Group: &group,
+				},
+			})
+			if status.Code(err) == codes.InvalidArgument {
+				t.Logf("Backend group %s does not report health, treating it as ready: %v", group, err)
+				continue
+			}
+			if err != nil {
+				lastProblems = append(lastProblems, fmt.Sprintf("%s: %v", group, err))
+				continue
+			}
+			lastProblems = append(lastProblems, UnhealthyBackends(group, health)...)
+		}
+
+		if len(lastProblems) == 0 {
+			t.Logf("Backend service %s is healthy after %d attempt(s)", backendServiceName, attempt)
+			return nil
+		}
+		backoff := BackoffWithJitter(attempt)
+		t.Logf("Attempt %d: backend service %s not healthy yet (%s), retrying in %s",
+			attempt, backendServiceName, strings.Join(lastProblems, "; "), backoff)
+
+		if time.Now().Add(backoff).After(deadline) {
+			break
+		}
+		time.Sleep(backoff)
+	}
+
+	return fmt.Errorf("backend service %s did not become healthy within %s: %s",
+		backendServiceName, timeout, strings.Join(lastProblems, "; "))
+}
+
+// UnhealthyBackends describes every endpoint in health that isn't HEALTHY.
+// It returns nil when all endpoints are healthy or none are reported.
+func UnhealthyBackends(group string, health *computepb.BackendServiceGroupHealth) []string {
+	var problems []string
+	for _, s := range health.GetHealthStatus() {
+		if state := s.GetHealthState(); state != computepb.HealthStatus_HEALTHY.String() {
+			endpoint := s.GetInstance()
+			if endpoint == "" {
+				endpoint = s.GetIpAddress()
+			}
+			problems = append(problems, fmt.Sprintf("%s endpoint %s is %s", group, endpoint, state))
+		}
+	}
+	return problems
+}
@@ -0,0 +1,24 @@
+package gcptest
+
+import (
+	"testing"
+
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestUnhealthyBackends(t *testing.T) {
+	health := &computepb.BackendServiceGroupHealth{
+		HealthStatus: []*computepb.HealthStatus{
+			{Instance: proto.String("vm-a"), HealthState: proto.String("HEALTHY")},
+			{Instance: proto.String("vm-b"), HealthState: proto.String("UNHEALTHY")},
+			{IpAddress: proto.String("10.0.0.3"), HealthState: proto.String("UNKNOWN")},
+		},
+	}
+
+	assert.Equal(t,
+		[]string{"neg endpoint vm-b is UNHEALTHY", "neg endpoint 10.0.0.3 is UNKNOWN"},
+		UnhealthyBackends("neg", health))
+	assert.Empty(t, UnhealthyBackends("neg", &computepb.BackendServiceGroupHealth{}))
+}
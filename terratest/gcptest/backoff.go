@@ -0,0 +1,67 @@
+package gcptest
+
+import (
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// Bounds for BackoffWithJitter. The ceiling for attempt n is
+// BackoffBase * 2^(n-1), capped at BackoffCap.
+const (
+	BackoffBase = 5 * time.Second
+	BackoffCap  = 60 * time.Second
+)
+
+var (
+	jitterMu   sync.Mutex
+	jitterRand = rand.New(rand.NewPCG(uint64(time.Now().UnixNano()), 0))
+)
+
+// BackoffWithJitter returns how long to sleep before retry attempt (counting
+// from 1), using exponential backoff with full jitter: a uniformly random
+// delay between zero and the attempt's ceiling. Randomizing the whole delay
+// keeps parallel tests from retrying against the same API in lockstep.
+func BackoffWithJitter(attempt int) time.Duration {
+	jitterMu.Lock()
+	defer jitterMu.Unlock()
+
+	return backoffWithJitter(jitterRand, attempt)
+}
+
+func backoffWithJitter(r *rand.Rand, attempt int) time.Duration {
+	return time.Duration(r.Int64N(int64(backoffCeiling(attempt)) + 1))
+}
+
+// BackoffFrom is BackoffWithJitter for polls with a base interval of their
+// own: it never returns less than base, and adds full jitter up to the
+// attempt's ceiling, base * 2^(n-1) capped at BackoffCap, so the first retry
+// waits exactly base and later ones spread out.
+func BackoffFrom(base time.Duration, attempt int) time.Duration {
+	jitterMu.Lock()
+	defer jitterMu.Unlock()
+
+	return backoffFrom(jitterRand, base, attempt)
+}
+
+func backoffFrom(r *rand.Rand, base time.Duration, attempt int) time.Duration {
+	ceiling := max(ceilingFrom(base, attempt), base)
+	return base + time.Duration(r.Int64N(int64(ceiling-base)+1))
+}
+
+// backoffCeiling is the largest delay BackoffWithJitter may return for attempt.
+func backoffCeiling(attempt int) time.Duration {
+	return ceilingFrom(BackoffBase, attempt)
+}
+
+// ceilingFrom doubles base for each attempt after the first, up to BackoffCap.
+func ceilingFrom(base time.Duration, attempt int) time.Duration {
+	ceiling := base
+	for i := 1; i < attempt && ceiling < BackoffCap; i++ {
+		ceiling *= 2
+	}
+	if ceiling > BackoffCap {
+		ceiling = BackoffCap
+	}
+	return ceiling
+}
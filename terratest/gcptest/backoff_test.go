@@ -0,0 +1,54 @@
+package gcptest
+
+import (
+	"math/rand/v2"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoffCeiling(t *testing.T) {
+	assert.Equal(t, BackoffBase, backoffCeiling(0))
+	assert.Equal(t, BackoffBase, backoffCeiling(1))
+	assert.Equal(t, 2*BackoffBase, backoffCeiling(2))
+	assert.Equal(t, 4*BackoffBase, backoffCeiling(3))
+	assert.Equal(t, BackoffCap, backoffCeiling(5))
+	assert.Equal(t, BackoffCap, backoffCeiling(1000))
+}
+
+func TestBackoffWithJitter(t *testing.T) {
+	seeded := func() *rand.Rand { return rand.New(rand.NewPCG(1, 2)) }
+
+	// The same seed gives the same delays
+	first, second := seeded(), seeded()
+	for attempt := 1; attempt <= 10; attempt++ {
+		assert.Equal(t, backoffWithJitter(first, attempt), backoffWithJitter(second, attempt))
+	}
+
+	r := seeded()
+	distinct := map[time.Duration]bool{}
+	for i := 0; i < 100; i++ {
+		for attempt := 1; attempt <= 8; attempt++ {
+			delay := backoffWithJitter(r, attempt)
+			assert.GreaterOrEqual(t, delay, time.Duration(0))
+			assert.LessOrEqual(t, delay, backoffCeiling(attempt))
+			distinct[delay] = true
+		}
+	}
+	assert.Greater(t, len(distinct), 1, "Delays should be jittered")
+}
+
+func TestBackoffFrom(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+
+	assert.Equal(t, 10*time.Millisecond, backoffFrom(r, 10*time.Millisecond, 1), "The first retry waits the base interval")
+	for attempt := 2; attempt <= 8; attempt++ {
+		delay := backoffFrom(r, 10*time.Millisecond, attempt)
+		assert.GreaterOrEqual(t, delay, 10*time.Millisecond)
+		assert.LessOrEqual(t, delay, ceilingFrom(10*time.Millisecond, attempt))
+	}
+
+	// A base above BackoffCap is kept rather than capped
+	assert.Equal(t, 2*BackoffCap, backoffFrom(r, 2*BackoffCap, 5))
+}
@@ -0,0 +1,153 @@
+package gcptest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+)
+
+// AssertBucketLifecycle reads the lifecycle rules of bucket and fails the test
+// for each of LifecycleProblems, so the bucket doesn't keep every source
+// archive forever. The check is skipped when the credentials can't read the
+// bucket's metadata in projectID.
+func AssertBucketLifecycle(t testing.TB, projectID, bucket string, maxAgeDays int64) {
+	t.Helper()
+
+	ctx := context.Background()
+	opts, err := clientOptions(ctx)
+	if err != nil {
+		t.Skipf("Skipping lifecycle check: could not create Cloud Storage client: %v", err)
+	}
+	client, err := storage.NewClient(ctx, opts...)
+	if err != nil {
+		t.Skipf("Skipping lifecycle check: could not create Cloud Storage client: %v", err)
+	}
+	defer client.Close()
+
+	attrs, err := client.Bucket(bucket).Attrs(ctx)
+	if err != nil {
+		if isAPIUnavailable(err) {
+			t.Skipf("Skipping lifecycle check: Cloud Storage metadata of gs://%s is not readable in %s: %v", bucket, projectID, err)
+		}
+		t.Fatalf("Could not read the attributes of gs://%s: %v", bucket, err)
+	}
+
+	for _, problem := range LifecycleProblems(attrs.Lifecycle, attrs.VersioningEnabled, maxAgeDays) {
+		t.Errorf("gs://%s %s", bucket, problem)
+	}
+}
+
+// LifecycleProblems describes what is wrong with lifecycle: it must delete
+// objects by age within maxAgeDays and, when the bucket is versioned, delete
+// noncurrent versions within maxAgeDays too, while leaving live objects of any
+// age alone unless a rule is limited to some prefixes. An age rule that
+// expires every live object would also expire the deployed source archive.
+func LifecycleProblems(lifecycle storage.Lifecycle, versioned bool, maxAgeDays int64) []string {
+	var problems []string
+	age, ok := ShortestDeleteAge(lifecycle)
+	if !ok {
+		problems = append(problems, "has no age-based delete rule, objects are kept forever")
+	} else if age > maxAgeDays {
+		problems = append(problems, fmt.Sprintf("deletes objects after %d days, over the %d day limit", age, maxAgeDays))
+	}
+	if !versioned {
+		return problems
+	}
+
+	days, ok := ShortestNoncurrentDeleteAge(lifecycle)
+	if !ok {
+		problems = append(problems, "is versioned without a days_since_noncurrent_time delete rule, noncurrent versions are kept forever")
+	} else if days > maxAgeDays {
+		problems = append(problems, fmt.Sprintf("deletes noncurrent versions after %d days, over the %d day limit", days, maxAgeDays))
+	}
+	for _, rule := range lifecycle.Rules {
+		if rule.Action.Type == storage.DeleteAction && rule.Condition.AgeInDays > 0 &&
+			rule.Condition.Liveness != storage.Archived && len(rule.Condition.MatchesPrefix) == 0 {
+			problems = append(problems, fmt.Sprintf("expires live objects after %d days, including the deployed source archive", rule.Condition.AgeInDays))
+		}
+	}
+	return problems
+}
+
+// ShortestDeleteAge returns the smallest age, in days, at which a rule in
+// lifecycle deletes objects, and false when no rule deletes by age.
+func ShortestDeleteAge(lifecycle storage.Lifecycle) (int64, bool) {
+	var shortest int64
+	found := false
+	for _, rule := range lifecycle.Rules {
+		if rule.Action.Type != storage.DeleteAction || rule.Condition.AgeInDays <= 0 {
+			continue
+		}
+		if !found || rule.Condition.AgeInDays < shortest {
+			shortest = rule.Condition.AgeInDays
+			found = true
+		}
+	}
+	return shortest, found
+}
+
+// ShortestNoncurrentDeleteAge returns the smallest days_since_noncurrent_time
+// at which a rule in lifecycle deletes noncurrent versions, and false when no
+// rule does.
+func ShortestNoncurrentDeleteAge(lifecycle storage.Lifecycle) (int64, bool) {
+	var shortest int64
+	found := false
+	for _, rule := range lifecycle.Rules {
+		if rule.Action.Type != storage.DeleteAction || rule.Condition.DaysSinceNoncurrentTime <= 0 {
+			continue
+		}
+		if !found || rule.Condition.DaysSinceNoncurrentTime < shortest {
+			shortest = rule.Condition.DaysSinceNoncurrentTime
+			found = true
+		}
+	}
+	return shortest, found
+}
+
+// AssertBucketRegion reads the location of bucket and fails the test unless
+// it is the single region expectedRegion, so the function doesn't fetch its
+// source across regions, e.g. from a bucket left at the US multi-region
+// default. The check is skipped when the credentials can't read the bucket's
+// metadata in projectID.
+func AssertBucketRegion(t testing.TB, projectID, bucket, expectedRegion string) {
+	t.Helper()
+
+	ctx := context.Background()
+	opts, err := clientOptions(ctx)
+	if err != nil {
+		t.Skipf("Skipping bucket region check: could not create Cloud Storage client: %v", err)
+	}
+	client, err := storage.NewClient(ctx, opts...)
+	if err != nil {
+		t.Skipf("Skipping bucket region check: could not create Cloud Storage client: %v", err)
+	}
+	defer client.Close()
+
+	attrs, err := client.Bucket(bucket).Attrs(ctx)
+	if err != nil {
+		if isAPIUnavailable(err) {
+			t.Skipf("Skipping bucket region check: Cloud Storage metadata of gs://%s is not readable in %s: %v", bucket, projectID, err)
+		}
+		t.Fatalf("Could not read the attributes of gs://%s: %v", bucket, err)
+	}
+
+	if err := BucketLocationProblem(attrs.Location, attrs.LocationType, expectedRegion); err != nil {
+		t.Errorf("gs://%s: %v", bucket, err)
+	}
+}
+
+// BucketLocationProblem returns why a bucket in location, of locationType
+// ("region", "dual-region" or "multi-region"), isn't in expectedRegion, or
+// nil when it is. Cloud Storage reports locations in upper case.
+func BucketLocationProblem(location, locationType, expectedRegion string) error {
+	if locationType != "" && locationType != "region" {
+		return fmt.Errorf("bucket is in the %s %s, not the region %s", locationType, location, expectedRegion)
+	}
+	if !strings.EqualFold(location, expectedRegion) {
+		return fmt.Errorf("bucket is in %s, not %s", location, expectedRegion)
+	}
+	return nil
+}
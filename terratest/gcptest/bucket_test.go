@@ -0,0 +1,85 @@
+package gcptest
+
+import (
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShortestDeleteAge(t *testing.T) {
+	rule := func(action string, age int64) storage.LifecycleRule {
+		return storage.LifecycleRule{
+			Action:    storage.LifecycleAction{Type: action},
+			Condition: storage.LifecycleCondition{AgeInDays: age},
+		}
+	}
+
+	age, ok := ShortestDeleteAge(storage.Lifecycle{Rules: []storage.LifecycleRule{
+		rule(storage.SetStorageClassAction, 7),
+		rule(storage.DeleteAction, 90),
+		rule(storage.DeleteAction, 30),
+	}})
+	assert.True(t, ok)
+	assert.EqualValues(t, 30, age)
+
+	// Delete rules without an age condition (e.g. by number of newer versions) don't count
+	_, ok = ShortestDeleteAge(storage.Lifecycle{Rules: []storage.LifecycleRule{
+		rule(storage.SetStorageClassAction, 7),
+		rule(storage.DeleteAction, 0),
+	}})
+	assert.False(t, ok)
+
+	_, ok = ShortestDeleteAge(storage.Lifecycle{})
+	assert.False(t, ok)
+}
+
+func TestShortestNoncurrentDeleteAge(t *testing.T) {
+	days, ok := ShortestNoncurrentDeleteAge(storage.Lifecycle{Rules: []storage.LifecycleRule{
+		{Action: storage.LifecycleAction{Type: storage.DeleteAction}, Condition: storage.LifecycleCondition{AgeInDays: 7}},
+		{Action: storage.LifecycleAction{Type: storage.DeleteAction}, Condition: storage.LifecycleCondition{DaysSinceNoncurrentTime: 30}},
+		{Action: storage.LifecycleAction{Type: storage.DeleteAction}, Condition: storage.LifecycleCondition{DaysSinceNoncurrentTime: 14}},
+	}})
+	assert.True(t, ok)
+	assert.EqualValues(t, 14, days)
+
+	_, ok = ShortestNoncurrentDeleteAge(storage.Lifecycle{Rules: []storage.LifecycleRule{
+		{Action: storage.LifecycleAction{Type: storage.DeleteAction}, Condition: storage.LifecycleCondition{AgeInDays: 7}},
+	}})
+	assert.False(t, ok)
+}
+
+func TestLifecycleProblems(t *testing.T) {
+	deleteRule := func(condition storage.LifecycleCondition) storage.LifecycleRule {
+		return storage.LifecycleRule{Action: storage.LifecycleAction{Type: storage.DeleteAction}, Condition: condition}
+	}
+	logsByAge := deleteRule(storage.LifecycleCondition{AgeInDays: 30, MatchesPrefix: []string{"cloudfunctions.googleapis.com/"}})
+	noncurrent := deleteRule(storage.LifecycleCondition{DaysSinceNoncurrentTime: 30, Liveness: storage.Archived})
+
+	assert.Empty(t, LifecycleProblems(storage.Lifecycle{Rules: []storage.LifecycleRule{logsByAge, noncurrent}}, true, 30))
+	assert.Empty(t, LifecycleProblems(storage.Lifecycle{Rules: []storage.LifecycleRule{
+		deleteRule(storage.LifecycleCondition{AgeInDays: 30}),
+	}}, false, 30), "Without versioning an age rule is all it takes")
+
+	assert.Equal(t, []string{
+		"is versioned without a days_since_noncurrent_time delete rule, noncurrent versions are kept forever",
+		"expires live objects after 30 days, including the deployed source archive",
+	}, LifecycleProblems(storage.Lifecycle{Rules: []storage.LifecycleRule{
+		deleteRule(storage.LifecycleCondition{AgeInDays: 30}),
+	}}, true, 30))
+
+	assert.Equal(t, []string{
+		"has no age-based delete rule, objects are kept forever",
+		"deletes noncurrent versions after 90 days, over the 30 day limit",
+	}, LifecycleProblems(storage.Lifecycle{Rules: []storage.LifecycleRule{
+		deleteRule(storage.LifecycleCondition{DaysSinceNoncurrentTime: 90}),
+	}}, true, 30))
+}
+
+func TestBucketLocationProblem(t *testing.T) {
+	assert.NoError(t, BucketLocationProblem("US-CENTRAL1", "region", "us-central1"))
+	assert.EqualError(t, BucketLocationProblem("US", "multi-region", "us-central1"),
+		"bucket is in the multi-region US, not the region us-central1")
+	assert.Error(t, BucketLocationProblem("NAM4", "dual-region", "us-central1"), "A dual-region includes us-central1 but isn't it")
+	assert.EqualError(t, BucketLocationProblem("EUROPE-WEST1", "region", "us-central1"), "bucket is in EUROPE-WEST1, not us-central1")
+}
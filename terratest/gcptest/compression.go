@@ -0,0 +1,68 @@
+package gcptest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// getWithEncoding GETs url with automatic decompression disabled, sending
+// Accept-Encoding only when acceptEncoding is non-empty, and returns the raw
+// response with its body fully read.
+func getWithEncoding(t testing.TB, url, acceptEncoding string) (*http.Response, []byte) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatalf("Could not build request for %s: %v", url, err)
+	}
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+
+	// Without DisableCompression the transport would negotiate and decode gzip on its own
+	client := &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{DisableCompression: true},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("GET %s failed: %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Could not read body from %s: %v", url, err)
+	}
+	return resp, body
+}
+
+// AssertGzipSupported checks that url gzip-encodes its response when the
+// client advertises gzip, that the decompressed body contains expectedText,
+// and that the body comes back uncompressed when gzip isn't advertised.
+func AssertGzipSupported(t testing.TB, url, expectedText string) {
+	t.Helper()
+
+	resp, body := getWithEncoding(t, url, "gzip")
+	if assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"), "%s should gzip-encode its response", url) {
+		reader, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("Response from %s is not valid gzip: %v", url, err)
+		}
+		decoded, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("Could not decompress response from %s: %v", url, err)
+		}
+		assert.Contains(t, string(decoded), expectedText, "Decompressed body from %s", url)
+	}
+
+	resp, body = getWithEncoding(t, url, "")
+	assert.Empty(t, resp.Header.Get("Content-Encoding"), "%s should not compress when gzip isn't accepted", url)
+	assert.Contains(t, string(body), expectedText, "Uncompressed body from %s", url)
+}
@@ -0,0 +1,25 @@
+package gcptest
+
+import (
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAssertGzipSupported(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			w.Header().Set("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			defer gz.Close()
+			gz.Write([]byte("Hello, World! Environment: dev"))
+			return
+		}
+		w.Write([]byte("Hello, World! Environment: dev"))
+	}))
+	defer server.Close()
+
+	AssertGzipSupported(t, server.URL, "Hello")
+}
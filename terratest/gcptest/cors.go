@@ -0,0 +1,64 @@
+package gcptest
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// preflight sends a CORS preflight OPTIONS request for a GET from origin and
+// returns the response headers.
+func preflight(t testing.TB, url, origin string) http.Header {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodOptions, url, nil)
+	if err != nil {
+		t.Fatalf("Could not build preflight request for %s: %v", url, err)
+	}
+	req.Header.Set("Origin", origin)
+	req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Preflight request to %s failed: %v", url, err)
+	}
+	resp.Body.Close()
+
+	assert.Less(t, resp.StatusCode, 300, "Preflight from %s should succeed", origin)
+	return resp.Header
+}
+
+// AssertCORS sends a preflight request from origin and checks that the
+// response allows that origin and advertises every method in expectedMethods.
+func AssertCORS(t testing.TB, url, origin string, expectedMethods []string) {
+	t.Helper()
+
+	headers := preflight(t, url, origin)
+
+	allowOrigin := headers.Get("Access-Control-Allow-Origin")
+	assert.True(t, allowOrigin == origin || allowOrigin == "*",
+		"Access-Control-Allow-Origin should allow %s, got %q", origin, allowOrigin)
+
+	allowMethods := map[string]bool{}
+	for _, method := range strings.Split(headers.Get("Access-Control-Allow-Methods"), ",") {
+		allowMethods[strings.ToUpper(strings.TrimSpace(method))] = true
+	}
+	for _, method := range expectedMethods {
+		assert.True(t, allowMethods[method], "Access-Control-Allow-Methods should include %s, got %q",
+			method, headers.Get("Access-Control-Allow-Methods"))
+	}
+}
+
+// AssertCORSRejected sends a preflight request from origin and checks that
+// the response carries no Access-Control-Allow-Origin header.
+func AssertCORSRejected(t testing.TB, url, origin string) {
+	t.Helper()
+
+	headers := preflight(t, url, origin)
+	assert.Empty(t, headers.Get("Access-Control-Allow-Origin"),
+		"Disallowed origin %s should not get an Access-Control-Allow-Origin header", origin)
+}
@@ -0,0 +1,21 @@
+package gcptest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAssertCORS(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if origin := r.Header.Get("Origin"); origin == "https://app.example.com" {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	AssertCORS(t, server.URL, "https://app.example.com", []string{"GET", "POST"})
+	AssertCORSRejected(t, server.URL, "https://evil.example.com")
+}
@@ -0,0 +1,45 @@
+package gcptest
+
+import (
+	"context"
+	"fmt"
+
+	resourcemanager "cloud.google.com/go/resourcemanager/apiv3"
+	"cloud.google.com/go/resourcemanager/apiv3/resourcemanagerpb"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// CheckCredentials confirms that the credentials the helpers in this package
+// use, GOOGLE_APPLICATION_CREDENTIALS or the application default credentials
+// (impersonated if configured), can call GCP, by reading projectID through
+// Resource Manager. It returns the identity they belong to, or "unknown" when
+// the token doesn't say. Being denied the project still proves the
+// credentials work, so only missing or rejected credentials are errors.
+func CheckCredentials(ctx context.Context, projectID string) (string, error) {
+	if _, err := google.FindDefaultCredentials(ctx, impersonationScopes...); err != nil {
+		return "", fmt.Errorf("no GCP credentials found, set GOOGLE_APPLICATION_CREDENTIALS or run `gcloud auth application-default login`: %w", err)
+	}
+
+	opts, err := clientOptions(ctx)
+	if err != nil {
+		return "", err
+	}
+	client, err := resourcemanager.NewProjectsClient(ctx, opts...)
+	if err != nil {
+		return "", fmt.Errorf("creating Resource Manager client: %w", err)
+	}
+	defer client.Close()
+
+	_, err = client.GetProject(ctx, &resourcemanagerpb.GetProjectRequest{Name: "projects/" + projectID})
+	if err != nil && status.Code(err) != codes.PermissionDenied && status.Code(err) != codes.NotFound {
+		return "", fmt.Errorf("credentials could not read project %s, they may be expired (re-run `gcloud auth application-default login`): %w", projectID, err)
+	}
+
+	identity, err := ActiveIdentity(ctx)
+	if err != nil {
+		return "unknown", nil
+	}
+	return identity, nil
+}
@@ -0,0 +1,49 @@
+package gcptest
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// WaitForDNS resolves host until it returns at least one IPv4 address or
+// timeout elapses, sleeping BackoffWithJitter between attempts. It
+// returns the resolved addresses so callers can log where the name points.
+func WaitForDNS(t testing.TB, host string, timeout time.Duration) ([]string, error) {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	var lastErr error
+
+	for attempt := 1; ; attempt++ {
+		addrs, err := net.LookupHost(host)
+		if ips := ipv4Addresses(addrs); err == nil && len(ips) > 0 {
+			t.Logf("%s resolved to %v after %d attempt(s)", host, ips, attempt)
+			return ips, nil
+		}
+		lastErr = err
+		if lastErr == nil {
+			lastErr = fmt.Errorf("no A records among %v", addrs)
+		}
+		backoff := BackoffWithJitter(attempt)
+		t.Logf("Attempt %d: %s does not resolve yet (%v), retrying in %s", attempt, host, lastErr, backoff)
+
+		if time.Now().Add(backoff).After(deadline) {
+			break
+		}
+		time.Sleep(backoff)
+	}
+
+	return nil, fmt.Errorf("%s did not resolve within %s: %v", host, timeout, lastErr)
+}
+
+func ipv4Addresses(addrs []string) []string {
+	var ips []string
+	for _, addr := range addrs {
+		if ip := net.ParseIP(addr); ip != nil && ip.To4() != nil {
+			ips = append(ips, addr)
+		}
+	}
+	return ips
+}
@@ -0,0 +1,23 @@
+package gcptest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWaitForDNS(t *testing.T) {
+	ips, err := WaitForDNS(t, "127.0.0.1", time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"127.0.0.1"}, ips)
+
+	// .invalid is reserved and never resolves; the short timeout gives up after one attempt
+	_, err = WaitForDNS(t, "hello-world.invalid", time.Millisecond)
+	assert.Error(t, err)
+}
+
+func TestIPv4Addresses(t *testing.T) {
+	assert.Equal(t, []string{"10.0.0.1"}, ipv4Addresses([]string{"::1", "10.0.0.1", "2001:db8::1"}))
+	assert.Empty(t, ipv4Addresses([]string{"::1"}))
+}
@@ -0,0 +1,7 @@
+// Package gcptest contains Terratest helpers for deploying the hello world
+// infrastructure to GCP and checking the deployed Cloud Function.
+//
+// The helpers take a testing.TB so they can be shared between tests and
+// benchmarks, and skip rather than fail when the environment isn't set up for
+// a deployment (for example when no project is configured).
+package gcptest
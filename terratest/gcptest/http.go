@@ -0,0 +1,167 @@
+package gcptest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	http_helper "github.com/gruntwork-io/terratest/modules/http-helper"
+	"github.com/stretchr/testify/assert"
+)
+
+// ResponseFormat selects how the function's response body is validated.
+type ResponseFormat string
+
+const (
+	// ResponseFormatText expects the plain text greeting the function returns today.
+	ResponseFormatText ResponseFormat = "text"
+	// ResponseFormatJSON expects a HelloResponse JSON document.
+	ResponseFormatJSON ResponseFormat = "json"
+)
+
+// ExpectedJSONMessage is the message a function in json mode must return.
+const ExpectedJSONMessage = "Hello World from GCP!"
+
+// HelloResponse is the JSON body returned by the function in json mode.
+type HelloResponse struct {
+	Message   string `json:"message"`
+	Timestamp string `json:"timestamp"`
+}
+
+// AssertFunctionResponse fetches url once with the given headers and checks
+// that it answers 200 with a body valid for format.
+func AssertFunctionResponse(t testing.TB, url string, format ResponseFormat, headers map[string]string) {
+	t.Helper()
+
+	statusCode, body := http_helper.HTTPDo(t, http.MethodGet, url, nil, headers, nil)
+	assert.Equal(t, 200, statusCode, "Function should return 200")
+	assert.NoError(t, ValidateResponseBody(format, body), "Function response from %s", url)
+}
+
+// ContentTypeFor returns the Content-Type a response in format must have,
+// or "" when format doesn't pin one: the text greeting goes out with
+// whatever the Functions Framework defaults to.
+func ContentTypeFor(format ResponseFormat) string {
+	if format == ResponseFormatJSON {
+		return "application/json"
+	}
+	return ""
+}
+
+// AssertContentType fetches url once with the given headers and fails the
+// test, naming the Content-Type it got, unless the response's matches
+// expected (see ContentTypeProblem).
+func AssertContentType(t testing.TB, url, expected string, headers map[string]string) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatalf("Could not build a request for %s: %v", url, err)
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Content-Type request to %s failed: %v", url, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if err := ContentTypeProblem(resp.Header.Get("Content-Type"), expected); err != nil {
+		t.Errorf("%s: %v", url, err)
+	}
+}
+
+// ContentTypeProblem returns why the Content-Type actual doesn't match
+// expected, or nil when it does. Media types are compared case-insensitively
+// and parameters such as charset are ignored unless expected has them.
+func ContentTypeProblem(actual, expected string) error {
+	expectedType, expectedParams, err := mime.ParseMediaType(expected)
+	if err != nil {
+		return fmt.Errorf("invalid expected Content-Type %q: %w", expected, err)
+	}
+	if actual == "" {
+		return fmt.Errorf("expected Content-Type %s, got none", expected)
+	}
+	actualType, actualParams, err := mime.ParseMediaType(actual)
+	if err != nil || actualType != expectedType {
+		return fmt.Errorf("expected Content-Type %s, got %q", expected, actual)
+	}
+	for name, value := range expectedParams {
+		if !strings.EqualFold(actualParams[name], value) {
+			return fmt.Errorf("expected Content-Type %s, got %q", expected, actual)
+		}
+	}
+	return nil
+}
+
+// ValidateResponseBody checks a function response body against format and
+// returns a descriptive error when it doesn't match.
+func ValidateResponseBody(format ResponseFormat, body string) error {
+	switch format {
+	case ResponseFormatText:
+		// The function currently answers with "Hello, World! Environment: <env>"
+		if !strings.HasPrefix(body, "Hello") {
+			return fmt.Errorf("expected a greeting, got %q", body)
+		}
+	case ResponseFormatJSON:
+		var response HelloResponse
+		if err := json.Unmarshal([]byte(body), &response); err != nil {
+			return fmt.Errorf("response is not valid JSON: %v (body: %q)", err, body)
+		}
+		if response.Message != ExpectedJSONMessage {
+			return fmt.Errorf("expected message %q, got %q", ExpectedJSONMessage, response.Message)
+		}
+		if _, err := time.Parse(time.RFC3339, response.Timestamp); err != nil {
+			return fmt.Errorf("timestamp %q is not RFC3339: %v", response.Timestamp, err)
+		}
+	default:
+		return fmt.Errorf("unknown response format %q", format)
+	}
+	return nil
+}
+
+// WaitForHealthy polls url with BackoffWithJitter between attempts until it
+// answers 200 or timeout elapses. On timeout the
+// returned error carries the last status code, error and a body snippet.
+func WaitForHealthy(t testing.TB, url string, timeout time.Duration) error {
+	t.Helper()
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	deadline := time.Now().Add(timeout)
+	lastStatus := 0
+	lastBody := ""
+	var lastErr error
+
+	for attempt := 1; ; attempt++ {
+		resp, err := client.Get(url)
+		if err == nil {
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+			resp.Body.Close()
+			lastStatus, lastBody, lastErr = resp.StatusCode, string(body), nil
+			if resp.StatusCode == http.StatusOK {
+				t.Logf("%s is healthy after %d attempt(s)", url, attempt)
+				return nil
+			}
+		} else {
+			lastErr = err
+		}
+		backoff := BackoffWithJitter(attempt)
+		t.Logf("Attempt %d: %s not healthy yet (status %d, error %v), retrying in %s", attempt, url, lastStatus, lastErr, backoff)
+
+		if time.Now().Add(backoff).After(deadline) {
+			break
+		}
+		time.Sleep(backoff)
+	}
+
+	return fmt.Errorf("%s did not become healthy within %s: last status %d, last error %v, body: %q",
+		url, timeout, lastStatus, lastErr, lastBody)
+}
@@ -0,0 +1,80 @@
+package gcptest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateResponseBody(t *testing.T) {
+	timestamp := time.Now().UTC().Format(time.RFC3339)
+
+	cases := []struct {
+		name    string
+		format  ResponseFormat
+		body    string
+		wantErr bool
+	}{
+		{"text greeting", ResponseFormatText, "Hello, World! Environment: dev", false},
+		{"text missing greeting", ResponseFormatText, "Internal Server Error", true},
+		{"json valid", ResponseFormatJSON, fmt.Sprintf(`{"message":%q,"timestamp":%q}`, ExpectedJSONMessage, timestamp), false},
+		{"json malformed", ResponseFormatJSON, `{"message":`, true},
+		{"json wrong message", ResponseFormatJSON, fmt.Sprintf(`{"message":"Hi","timestamp":%q}`, timestamp), true},
+		{"json bad timestamp", ResponseFormatJSON, fmt.Sprintf(`{"message":%q,"timestamp":"yesterday"}`, ExpectedJSONMessage), true},
+		{"unknown format", ResponseFormat("xml"), "<hello/>", true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateResponseBody(tc.format, tc.body)
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestContentTypeProblem(t *testing.T) {
+	assert.NoError(t, ContentTypeProblem("application/json", "application/json"))
+	assert.NoError(t, ContentTypeProblem("Application/JSON; charset=utf-8", "application/json"), "charset is ignored unless expected")
+	assert.NoError(t, ContentTypeProblem("text/plain; charset=UTF-8", "text/plain; charset=utf-8"))
+
+	assert.EqualError(t, ContentTypeProblem("text/plain; charset=utf-8", "application/json"),
+		`expected Content-Type application/json, got "text/plain; charset=utf-8"`)
+	assert.Error(t, ContentTypeProblem("text/plain", "text/plain; charset=utf-8"), "A charset that is expected must be there")
+	assert.EqualError(t, ContentTypeProblem("", "application/json"), "expected Content-Type application/json, got none")
+
+	assert.Equal(t, "application/json", ContentTypeFor(ResponseFormatJSON))
+	assert.Empty(t, ContentTypeFor(ResponseFormatText))
+}
+
+func TestWaitForHealthy(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer healthy.Close()
+
+	assert.NoError(t, WaitForHealthy(t, healthy.URL, time.Second))
+}
+
+func TestWaitForHealthyReportsLastResponse(t *testing.T) {
+	unhealthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+		fmt.Fprint(w, "no healthy upstream")
+	}))
+	defer unhealthy.Close()
+
+	// A timeout shorter than the first backoff gives up after one attempt
+	err := WaitForHealthy(t, unhealthy.URL, time.Millisecond)
+
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "502")
+		assert.Contains(t, err.Error(), "no healthy upstream")
+	}
+}
@@ -0,0 +1,107 @@
+package gcptest
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	iampb "cloud.google.com/go/iam/apiv1/iampb"
+	resourcemanager "cloud.google.com/go/resourcemanager/apiv3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// AssertServiceAccountRoles reads the IAM policy of projectID and fails the
+// test for each role in forbidden (e.g. roles/owner, roles/editor) that
+// saEmail holds at the project level. The check is skipped when the current
+// credentials can't read the policy.
+func AssertServiceAccountRoles(t testing.TB, projectID, saEmail string, forbidden []string) {
+	t.Helper()
+
+	ctx := context.Background()
+	opts, err := clientOptions(ctx)
+	if err != nil {
+		t.Skipf("Skipping IAM check: could not create Resource Manager client: %v", err)
+	}
+	client, err := resourcemanager.NewProjectsClient(ctx, opts...)
+	if err != nil {
+		t.Skipf("Skipping IAM check: could not create Resource Manager client: %v", err)
+	}
+	defer client.Close()
+
+	policy, err := client.GetIamPolicy(ctx, &iampb.GetIamPolicyRequest{Resource: "projects/" + projectID})
+	if err != nil {
+		switch status.Code(err) {
+		case codes.PermissionDenied, codes.Unauthenticated:
+			t.Skipf("Skipping IAM check: credentials can't read the IAM policy of %s: %v", projectID, err)
+		}
+		t.Fatalf("Could not read the IAM policy of %s: %v", projectID, err)
+	}
+
+	held := map[string]bool{}
+	for _, role := range RolesForMember(policy, "serviceAccount:"+saEmail) {
+		held[role] = true
+	}
+	for _, role := range forbidden {
+		if held[role] {
+			t.Errorf("Service account %s holds forbidden role %s on project %s", saEmail, role, projectID)
+		}
+	}
+}
+
+// RolesForMember returns the sorted roles bound to member in policy.
+func RolesForMember(policy *iampb.Policy, member string) []string {
+	var roles []string
+	for _, binding := range policy.GetBindings() {
+		for _, m := range binding.GetMembers() {
+			if m == member {
+				roles = append(roles, binding.GetRole())
+				break
+			}
+		}
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// MissingProjectPermissions returns the sorted permissions in permissions that
+// the current credentials don't hold on projectID, as reported by
+// testIamPermissions.
+func MissingProjectPermissions(projectID string, permissions []string) ([]string, error) {
+	ctx := context.Background()
+	opts, err := clientOptions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client, err := resourcemanager.NewProjectsClient(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	resp, err := client.TestIamPermissions(ctx, &iampb.TestIamPermissionsRequest{
+		Resource:    "projects/" + projectID,
+		Permissions: permissions,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return MissingPermissions(permissions, resp.GetPermissions()), nil
+}
+
+// MissingPermissions returns the sorted permissions in requested that aren't
+// in granted.
+func MissingPermissions(requested, granted []string) []string {
+	held := map[string]bool{}
+	for _, permission := range granted {
+		held[permission] = true
+	}
+	var missing []string
+	for _, permission := range requested {
+		if !held[permission] {
+			missing = append(missing, permission)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
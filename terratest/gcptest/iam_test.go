@@ -0,0 +1,32 @@
+package gcptest
+
+import (
+	"testing"
+
+	iampb "cloud.google.com/go/iam/apiv1/iampb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRolesForMember(t *testing.T) {
+	policy := &iampb.Policy{
+		Bindings: []*iampb.Binding{
+			{Role: "roles/logging.logWriter", Members: []string{"serviceAccount:fn@p.iam.gserviceaccount.com"}},
+			{Role: "roles/editor", Members: []string{"serviceAccount:p@appspot.gserviceaccount.com"}},
+			{Role: "roles/cloudfunctions.invoker", Members: []string{"allUsers", "serviceAccount:fn@p.iam.gserviceaccount.com"}},
+		},
+	}
+
+	assert.Equal(t,
+		[]string{"roles/cloudfunctions.invoker", "roles/logging.logWriter"},
+		RolesForMember(policy, "serviceAccount:fn@p.iam.gserviceaccount.com"))
+	assert.Empty(t, RolesForMember(policy, "serviceAccount:other@p.iam.gserviceaccount.com"))
+}
+
+func TestMissingPermissions(t *testing.T) {
+	requested := []string{"storage.buckets.create", "cloudfunctions.functions.create", "iam.serviceAccounts.create"}
+
+	assert.Equal(t,
+		[]string{"cloudfunctions.functions.create", "iam.serviceAccounts.create"},
+		MissingPermissions(requested, []string{"storage.buckets.create"}))
+	assert.Empty(t, MissingPermissions(requested, requested))
+}
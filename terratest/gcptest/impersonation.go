@@ -0,0 +1,125 @@
+package gcptest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/impersonate"
+	"google.golang.org/api/option"
+)
+
+// ImpersonateEnvVar is the variable the Google provider and gcloud read the
+// service account to impersonate from. It also sets the default for the
+// client helpers in this package.
+const ImpersonateEnvVar = "GOOGLE_IMPERSONATE_SERVICE_ACCOUNT"
+
+// impersonationScopes are requested for impersonated tokens; IAM still limits
+// them to what the service account may do. The email scope lets
+// ActiveIdentity see who the token belongs to.
+var impersonationScopes = []string{
+	"https://www.googleapis.com/auth/cloud-platform",
+	"https://www.googleapis.com/auth/userinfo.email",
+}
+
+// Impersonate makes the GCP clients created by this package act as saEmail
+// until the end of t, by setting ImpersonateEnvVar with t.Setenv. The setting
+// is process-wide, so like t.Setenv it panics in parallel tests instead of
+// leaking into them. The caller's credentials need
+// roles/iam.serviceAccountTokenCreator on saEmail.
+func Impersonate(t testing.TB, saEmail string) {
+	t.Helper()
+
+	t.Setenv(ImpersonateEnvVar, saEmail)
+}
+
+// ImpersonatedServiceAccount returns the service account the client helpers
+// act as: ImpersonateEnvVar, as set by the environment or Impersonate, or ""
+// for the caller's own credentials.
+func ImpersonatedServiceAccount() string {
+	return os.Getenv(ImpersonateEnvVar)
+}
+
+// clientOptions returns the options every client in this package is created
+// with: none for the caller's own credentials, or a token source
+// impersonating ImpersonatedServiceAccount when one is set.
+func clientOptions(ctx context.Context) ([]option.ClientOption, error) {
+	if ImpersonatedServiceAccount() == "" {
+		return nil, nil
+	}
+	tokenSource, err := tokenSource(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return []option.ClientOption{option.WithTokenSource(tokenSource)}, nil
+}
+
+// tokenSource returns access tokens for ImpersonatedServiceAccount, or for the
+// application default credentials when none is set.
+func tokenSource(ctx context.Context) (oauth2.TokenSource, error) {
+	saEmail := ImpersonatedServiceAccount()
+	if saEmail == "" {
+		return google.DefaultTokenSource(ctx, impersonationScopes...)
+	}
+
+	tokenSource, err := impersonate.CredentialsTokenSource(ctx, impersonate.CredentialsConfig{
+		TargetPrincipal: saEmail,
+		Scopes:          impersonationScopes,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("impersonating %s (needs roles/iam.serviceAccountTokenCreator on it): %w", saEmail, err)
+	}
+	return tokenSource, nil
+}
+
+// tokenInfoURL describes an access token, including the identity it was
+// issued to.
+const tokenInfoURL = "https://oauth2.googleapis.com/tokeninfo"
+
+// ActiveIdentity returns the email of the identity the client helpers
+// currently authenticate as, by asking the token info endpoint about a fresh
+// access token.
+func ActiveIdentity(ctx context.Context) (string, error) {
+	tokenSource, err := tokenSource(ctx)
+	if err != nil {
+		return "", err
+	}
+	token, err := tokenSource.Token()
+	if err != nil {
+		return "", fmt.Errorf("minting an access token: %w", err)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(tokenInfoURL + "?access_token=" + url.QueryEscape(token.AccessToken))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token info returned %d", resp.StatusCode)
+	}
+	return tokenEmail(resp.Body)
+}
+
+// tokenEmail decodes a token info response and returns its email.
+func tokenEmail(body io.Reader) (string, error) {
+	var info struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(body).Decode(&info); err != nil {
+		return "", fmt.Errorf("decoding token info: %w", err)
+	}
+	if info.Email == "" {
+		return "", fmt.Errorf("token info has no email; the credentials may lack the email scope")
+	}
+	return info.Email, nil
+}
@@ -0,0 +1,40 @@
+package gcptest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImpersonatedServiceAccount(t *testing.T) {
+	t.Setenv(ImpersonateEnvVar, "pipeline@my-project.iam.gserviceaccount.com")
+	assert.Equal(t, "pipeline@my-project.iam.gserviceaccount.com", ImpersonatedServiceAccount())
+
+	t.Run("override", func(t *testing.T) {
+		Impersonate(t, "tester@my-project.iam.gserviceaccount.com")
+		assert.Equal(t, "tester@my-project.iam.gserviceaccount.com", ImpersonatedServiceAccount())
+	})
+
+	// The override ends with the subtest that set it
+	assert.Equal(t, "pipeline@my-project.iam.gserviceaccount.com", ImpersonatedServiceAccount())
+
+	t.Run("parallel", func(t *testing.T) {
+		t.Parallel()
+		assert.Panics(t, func() { Impersonate(t, "tester@my-project.iam.gserviceaccount.com") },
+			"Parallel tests would share the impersonated identity")
+	})
+}
+
+func TestTokenEmail(t *testing.T) {
+	email, err := tokenEmail(strings.NewReader(`{"email":"tester@my-project.iam.gserviceaccount.com","expires_in":"3599"}`))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "tester@my-project.iam.gserviceaccount.com", email)
+	}
+
+	_, err = tokenEmail(strings.NewReader(`{"expires_in":"3599"}`))
+	assert.Error(t, err)
+
+	_, err = tokenEmail(strings.NewReader(`not json`))
+	assert.Error(t, err)
+}
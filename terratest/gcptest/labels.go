@@ -0,0 +1,73 @@
+package gcptest
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"regexp"
+	"sync"
+)
+
+// Labels BuildOptions puts on every resource a test creates, so leaked
+// resources can be audited and reaped (see ReapByLabel).
+const (
+	LabelCreatedBy = "created-by"
+	LabelTestRunID = "test-run-id"
+
+	createdByTerratest = "terratest"
+)
+
+// RunIDEnvVar sets the identifier RunID returns.
+const RunIDEnvVar = "TEST_RUN_ID"
+
+// runIDPattern is what a GCP label value may hold, restricted to ASCII.
+var runIDPattern = regexp.MustCompile(`^[a-z0-9_-]{0,63}$`)
+
+var (
+	runIDOnce sync.Once
+	runID     string
+)
+
+// RunID returns the identifier of this test run: TEST_RUN_ID when set, so a
+// CI pipeline can correlate runs with its own IDs, otherwise a random UUID
+// generated once per process. BuildOptions fails the test when TEST_RUN_ID
+// can't be a label value, see ValidateRunID.
+func RunID() string {
+	runIDOnce.Do(func() {
+		runID = os.Getenv(RunIDEnvVar)
+		if runID == "" {
+			runID = newUUID()
+		}
+	})
+	return runID
+}
+
+// ValidateRunID returns an error unless id can be the value of the
+// LabelTestRunID label: at most 63 lowercase letters, digits, dashes and
+// underscores. GCP rejects anything else when creating the resource.
+func ValidateRunID(id string) error {
+	if !runIDPattern.MatchString(id) {
+		return fmt.Errorf("%s %q is not a valid label value: use at most 63 lowercase letters, digits, '-' and '_'", RunIDEnvVar, id)
+	}
+	return nil
+}
+
+// TestLabels returns the labels identifying resources created by this run.
+func TestLabels() map[string]string {
+	return map[string]string{
+		LabelCreatedBy: createdByTerratest,
+		LabelTestRunID: RunID(),
+	}
+}
+
+// newUUID returns a random version 4 UUID. Its lowercase hex form is a valid
+// GCP label value.
+func newUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Sprintf("reading random bytes: %v", err))
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,47 @@
+package gcptest
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewUUID(t *testing.T) {
+	uuid := newUUID()
+	assert.Regexp(t, regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`), uuid)
+	assert.NotEqual(t, uuid, newUUID())
+}
+
+func TestRunIDIsStable(t *testing.T) {
+	assert.Equal(t, RunID(), RunID())
+	assert.Equal(t, map[string]string{"created-by": "terratest", "test-run-id": RunID()}, TestLabels())
+}
+
+func TestValidateRunID(t *testing.T) {
+	assert.NoError(t, ValidateRunID(newUUID()))
+	assert.NoError(t, ValidateRunID("ci_1234-attempt-2"))
+	assert.NoError(t, ValidateRunID(strings.Repeat("a", 63)))
+
+	assert.Error(t, ValidateRunID(strings.Repeat("a", 64)), "Label values are at most 63 characters")
+	assert.Error(t, ValidateRunID("Build-42"), "Label values are lowercase")
+	assert.Error(t, ValidateRunID("refs/heads/main"))
+	assert.Error(t, ValidateRunID("run 42"))
+}
+
+func TestIsStale(t *testing.T) {
+	cutoff := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	old := cutoff.Add(-time.Hour)
+	recent := cutoff.Add(time.Hour)
+	labels := func(runID string) map[string]string {
+		return map[string]string{LabelCreatedBy: "terratest", LabelTestRunID: runID}
+	}
+
+	assert.True(t, IsStale(labels("previous"), old, "current", cutoff))
+	assert.False(t, IsStale(labels("previous"), recent, "current", cutoff), "Recent resources may belong to a run in progress")
+	assert.False(t, IsStale(labels("current"), old, "current", cutoff), "The current run's resources are not stale")
+	assert.False(t, IsStale(map[string]string{LabelTestRunID: "previous"}, old, "current", cutoff), "Only terratest resources are reaped")
+	assert.False(t, IsStale(map[string]string{LabelCreatedBy: "terratest"}, old, "current", cutoff), "Resources without a run ID are left alone")
+}
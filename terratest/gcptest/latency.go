@@ -0,0 +1,59 @@
+package gcptest
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// AssertResponseTimeUnder primes url with one request so the function is
+// warm, then times a second GET and fails the test if it took longer than
+// max. The measured duration is logged either way.
+func AssertResponseTimeUnder(t testing.TB, url string, max time.Duration, headers map[string]string) {
+	t.Helper()
+
+	if _, err := timedGet(url, headers); err != nil {
+		t.Fatalf("Priming request to %s failed: %v", url, err)
+	}
+
+	elapsed, err := timedGet(url, headers)
+	if err != nil {
+		t.Fatalf("Timed request to %s failed: %v", url, err)
+	}
+	if elapsed > max {
+		t.Errorf("Warm request to %s took %s, over the %s SLA", url, elapsed, max)
+		return
+	}
+	t.Logf("Warm request to %s took %s (SLA %s)", url, elapsed, max)
+}
+
+// timedGet GETs url, reads the whole body and returns how long it took. Any
+// response other than 200 is an error.
+func timedGet(url string, headers map[string]string) (time.Duration, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return 0, err
+	}
+	elapsed := time.Since(start)
+
+	if resp.StatusCode != http.StatusOK {
+		return elapsed, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return elapsed, nil
+}
@@ -0,0 +1,41 @@
+package gcptest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAssertResponseTimeUnder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Hello")
+	}))
+	defer server.Close()
+
+	AssertResponseTimeUnder(t, server.URL, time.Second, nil)
+}
+
+func TestTimedGet(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		fmt.Fprint(w, "Hello")
+	}))
+	defer slow.Close()
+
+	elapsed, err := timedGet(slow.URL, nil)
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, elapsed, delay)
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	_, err = timedGet(failing.URL, nil)
+	assert.ErrorContains(t, err, "500")
+}
@@ -0,0 +1,158 @@
+package gcptest
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LoadResult collects the outcome of every request made by RunLoad.
+type LoadResult struct {
+	Total       int
+	Errors      int
+	StatusCodes map[int]int
+	Latencies   []time.Duration
+}
+
+// ErrorRate returns the fraction of requests that failed or didn't answer 2xx.
+func (r LoadResult) ErrorRate() float64 {
+	if r.Total == 0 {
+		return 0
+	}
+	return float64(r.Errors) / float64(r.Total)
+}
+
+// Percentile returns the latency below which p percent (0-100) of requests
+// completed, using the nearest-rank method.
+func (r LoadResult) Percentile(p float64) time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), r.Latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	rank := int(p/100*float64(len(sorted))+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+// Summary formats the result as a short multi-line report.
+func (r LoadResult) Summary() string {
+	var codes []string
+	for code, count := range r.StatusCodes {
+		codes = append(codes, fmt.Sprintf("%d=%d", code, count))
+	}
+	sort.Strings(codes)
+
+	return fmt.Sprintf("requests: %d, errors: %d (%.2f%%)\nstatus codes: %s\nlatency p50: %s, p95: %s, p99: %s",
+		r.Total, r.Errors, r.ErrorRate()*100, strings.Join(codes, " "),
+		r.Percentile(50), r.Percentile(95), r.Percentile(99))
+}
+
+// RunLoad sends GET requests to url from concurrency workers until duration
+// has elapsed, and returns per-request status codes and latencies. Transport
+// errors are counted under status code 0. It returns an error, without
+// sending anything, unless concurrency is at least 1.
+func RunLoad(url string, concurrency int, duration time.Duration) (LoadResult, error) {
+	if concurrency < 1 {
+		return LoadResult{}, fmt.Errorf("load concurrency must be at least 1, got %d", concurrency)
+	}
+	client := loadClient(concurrency)
+	deadline := time.Now().Add(duration)
+	recorder := newLoadRecorder()
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				recorder.get(client, url)
+			}
+		}()
+	}
+	wg.Wait()
+
+	return recorder.result, nil
+}
+
+// RunRequests sends total GET requests to url, never more than
+// maxConcurrency of them at once however large total is, and returns their
+// status codes and latencies like RunLoad. A maxConcurrency below 1 would
+// never let a request start, so it is an error.
+func RunRequests(url string, total, maxConcurrency int) (LoadResult, error) {
+	if maxConcurrency < 1 {
+		return LoadResult{}, fmt.Errorf("maximum request concurrency must be at least 1, got %d", maxConcurrency)
+	}
+	client := loadClient(maxConcurrency)
+	recorder := newLoadRecorder()
+
+	// A request holds a slot in the semaphore from before it starts until
+	// its response has been read
+	semaphore := make(chan struct{}, maxConcurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < total; i++ {
+		semaphore <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			recorder.get(client, url)
+		}()
+	}
+	wg.Wait()
+
+	return recorder.result, nil
+}
+
+// loadClient returns an HTTP client that keeps a connection open for each
+// of up to concurrency simultaneous requests.
+func loadClient(concurrency int) *http.Client {
+	return &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{MaxIdleConnsPerHost: concurrency},
+	}
+}
+
+// loadRecorder accumulates a LoadResult from concurrent requests.
+type loadRecorder struct {
+	mu     sync.Mutex
+	result LoadResult
+}
+
+func newLoadRecorder() *loadRecorder {
+	return &loadRecorder{result: LoadResult{StatusCodes: map[int]int{}}}
+}
+
+// get sends one GET request to url and records its outcome.
+func (r *loadRecorder) get(client *http.Client, url string) {
+	start := time.Now()
+	resp, err := client.Get(url)
+	if err != nil {
+		r.record(0, time.Since(start))
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	r.record(resp.StatusCode, time.Since(start))
+}
+
+func (r *loadRecorder) record(statusCode int, latency time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.result.Total++
+	r.result.StatusCodes[statusCode]++
+	r.result.Latencies = append(r.result.Latencies, latency)
+	if statusCode < 200 || statusCode > 299 {
+		r.result.Errors++
+	}
+}
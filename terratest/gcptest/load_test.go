@@ -0,0 +1,85 @@
+package gcptest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunLoad(t *testing.T) {
+	var requests int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Fail every tenth request so the error accounting is exercised
+		if atomic.AddInt64(&requests, 1)%10 == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("Hello"))
+	}))
+	defer server.Close()
+
+	result, err := RunLoad(server.URL, 4, 200*time.Millisecond)
+	assert.NoError(t, err)
+
+	assert.Greater(t, result.Total, 0)
+	assert.Equal(t, result.Total, len(result.Latencies))
+	assert.Equal(t, result.Errors, result.StatusCodes[http.StatusServiceUnavailable])
+	assert.Equal(t, result.Total, result.StatusCodes[http.StatusOK]+result.Errors)
+	assert.InDelta(t, 0.1, result.ErrorRate(), 0.05)
+}
+
+func TestRunRequestsCapsConcurrency(t *testing.T) {
+	var inFlight, peak int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := atomic.AddInt64(&inFlight, 1)
+		defer atomic.AddInt64(&inFlight, -1)
+		for {
+			seen := atomic.LoadInt64(&peak)
+			if current <= seen || atomic.CompareAndSwapInt64(&peak, seen, current) {
+				break
+			}
+		}
+		// Hold the request long enough for the others to pile up behind it
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte("Hello"))
+	}))
+	defer server.Close()
+
+	result, err := RunRequests(server.URL, 40, 3)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 40, result.Total)
+	assert.Equal(t, 40, result.StatusCodes[http.StatusOK])
+	assert.LessOrEqual(t, atomic.LoadInt64(&peak), int64(3), "No more than maxConcurrency requests may be in flight")
+	assert.Equal(t, int64(3), atomic.LoadInt64(&peak), "The cap should be reached, not only respected")
+}
+
+func TestLoadRejectsZeroConcurrency(t *testing.T) {
+	var requests int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+	}))
+	defer server.Close()
+
+	_, err := RunRequests(server.URL, 5, 0)
+	assert.Error(t, err)
+	_, err = RunLoad(server.URL, 0, time.Second)
+	assert.Error(t, err)
+	assert.Zero(t, atomic.LoadInt64(&requests), "Nothing should be sent with an invalid concurrency")
+}
+
+func TestLoadResultPercentile(t *testing.T) {
+	result := LoadResult{}
+	for i := 1; i <= 100; i++ {
+		result.Latencies = append(result.Latencies, time.Duration(i)*time.Millisecond)
+	}
+
+	assert.Equal(t, 50*time.Millisecond, result.Percentile(50))
+	assert.Equal(t, 95*time.Millisecond, result.Percentile(95))
+	assert.Equal(t, 100*time.Millisecond, result.Percentile(100))
+	assert.Equal(t, time.Duration(0), LoadResult{}.Percentile(95))
+}
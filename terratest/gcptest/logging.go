@@ -0,0 +1,128 @@
+package gcptest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"cloud.google.com/go/logging/logadmin"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// logIngestionTimeout bounds how long AssertFunctionLogged waits for
+// entries to show up, since Cloud Logging ingestion lags the request.
+const logIngestionTimeout = 3 * time.Minute
+
+// AssertFunctionLogged queries Cloud Logging for entries written by the
+// Cloud Function functionName in projectID at or after since, and fails the
+// test unless at least one of them contains expected. A non-empty traceID
+// narrows the query to the requests sent with that trace, so concurrent
+// traffic to the function can't satisfy it. The check is skipped when the
+// Logging API is disabled or the credentials can't read logs.
+func AssertFunctionLogged(t testing.TB, projectID, functionName, traceID string, since time.Time, expected string) {
+	t.Helper()
+
+	ctx := context.Background()
+	opts, err := clientOptions(ctx)
+	if err != nil {
+		t.Skipf("Skipping log check: could not create Logging client: %v", err)
+	}
+	client, err := logadmin.NewClient(ctx, projectID, opts...)
+	if err != nil {
+		t.Skipf("Skipping log check: could not create Logging client: %v", err)
+	}
+	defer client.Close()
+
+	filter := FunctionLogFilter(functionName, since)
+	if traceID != "" {
+		filter += " AND " + TraceLogFilter(projectID, traceID)
+	}
+	deadline := time.Now().Add(logIngestionTimeout)
+	for attempt := 1; ; attempt++ {
+		found, err := hasLogEntry(ctx, client, filter, expected)
+		if err != nil {
+			if isAPIUnavailable(err) {
+				t.Skipf("Skipping log check: Cloud Logging is not readable in %s: %v", projectID, err)
+			}
+			t.Fatalf("Could not list log entries for %s: %v", functionName, err)
+		}
+		if found {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Errorf("No log entry for %s since %s contains %q (filter: %s)",
+				functionName, since.Format(time.RFC3339), expected, filter)
+			return
+		}
+		backoff := BackoffWithJitter(attempt)
+		t.Logf("No matching log entry for %s yet, retrying in %s", functionName, backoff)
+		time.Sleep(backoff)
+	}
+}
+
+// FunctionLogFilter returns the Cloud Logging filter that selects entries
+// for the Cloud Function functionName written at or after since.
+func FunctionLogFilter(functionName string, since time.Time) string {
+	return fmt.Sprintf(`resource.type="cloud_function" AND resource.labels.function_name=%q AND timestamp>=%q`,
+		functionName, since.UTC().Format(time.RFC3339))
+}
+
+// TraceLogFilter returns the Cloud Logging filter that selects entries
+// written while serving requests sent with trace ID traceID in projectID.
+func TraceLogFilter(projectID, traceID string) string {
+	return fmt.Sprintf(`trace="projects/%s/traces/%s"`, projectID, traceID)
+}
+
+func hasLogEntry(ctx context.Context, client *logadmin.Client, filter, expected string) (bool, error) {
+	it := client.Entries(ctx, logadmin.Filter(filter), logadmin.NewestFirst())
+	for {
+		entry, err := it.Next()
+		if err == iterator.Done {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if entryContains(entry, expected) {
+			return true, nil
+		}
+	}
+}
+
+// entryContains reports whether the payload of entry mentions expected.
+// Text payloads are matched directly; structured payloads are matched
+// against their printed form.
+func entryContains(entry *logging.Entry, expected string) bool {
+	switch p := entry.Payload.(type) {
+	case string:
+		return strings.Contains(p, expected)
+	case nil:
+		return false
+	default:
+		return strings.Contains(fmt.Sprint(p), expected)
+	}
+}
+
+// isAPIUnavailable reports whether err means the API is disabled in the
+// project or the credentials aren't allowed to call it.
+func isAPIUnavailable(err error) bool {
+	switch status.Code(err) {
+	case codes.PermissionDenied, codes.Unauthenticated:
+		return true
+	}
+	// JSON APIs such as Cloud Storage report the same through HTTP status codes
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && (apiErr.Code == http.StatusForbidden || apiErr.Code == http.StatusUnauthorized) {
+		return true
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "SERVICE_DISABLED") || strings.Contains(msg, "has not been used in project")
+}
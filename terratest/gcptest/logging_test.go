@@ -0,0 +1,43 @@
+package gcptest
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestFunctionLogFilter(t *testing.T) {
+	since := time.Date(2024, 5, 1, 12, 30, 0, 0, time.FixedZone("CEST", 2*60*60))
+
+	assert.Equal(t,
+		`resource.type="cloud_function" AND resource.labels.function_name="hello-world-dev" AND timestamp>="2024-05-01T10:30:00Z"`,
+		FunctionLogFilter("hello-world-dev", since))
+}
+
+func TestTraceLogFilter(t *testing.T) {
+	assert.Equal(t,
+		`trace="projects/my-project/traces/4bf92f3577b34da6a3ce929d0e0e4736"`,
+		TraceLogFilter("my-project", "4bf92f3577b34da6a3ce929d0e0e4736"))
+}
+
+func TestEntryContains(t *testing.T) {
+	assert.True(t, entryContains(&logging.Entry{Payload: "Function execution started"}, "execution started"))
+	assert.False(t, entryContains(&logging.Entry{Payload: "Function execution took 5 ms"}, "execution started"))
+	assert.False(t, entryContains(&logging.Entry{}, "execution started"))
+	assert.True(t, entryContains(&logging.Entry{Payload: map[string]string{"message": "Function execution started"}}, "execution started"))
+}
+
+func TestIsAPIUnavailable(t *testing.T) {
+	assert.True(t, isAPIUnavailable(status.Error(codes.PermissionDenied, "denied")))
+	assert.True(t, isAPIUnavailable(fmt.Errorf("reading bucket: %w", &googleapi.Error{Code: 403})))
+	assert.True(t, isAPIUnavailable(errors.New("Cloud Logging API has not been used in project 123 before")))
+	assert.False(t, isAPIUnavailable(status.Error(codes.NotFound, "no such function")))
+	assert.False(t, isAPIUnavailable(&googleapi.Error{Code: 404}))
+}
@@ -0,0 +1,191 @@
+package gcptest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	monitoring "cloud.google.com/go/monitoring/apiv3/v2"
+	"cloud.google.com/go/monitoring/apiv3/v2/monitoringpb"
+	"google.golang.org/api/iterator"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// metricIngestionTimeout bounds how long AssertInvocationCount waits, since
+// Cloud Functions metrics show up in Cloud Monitoring a few minutes late.
+const metricIngestionTimeout = 5 * time.Minute
+
+// AssertInvocationCount queries Cloud Monitoring for the execution count of
+// the Cloud Function functionName over the last window and fails the test
+// unless it reaches minCount. The check is skipped when the Monitoring API is
+// disabled or the credentials can't read metrics.
+func AssertInvocationCount(t testing.TB, projectID, functionName string, minCount int64, window time.Duration) {
+	t.Helper()
+
+	ctx := context.Background()
+	opts, err := clientOptions(ctx)
+	if err != nil {
+		t.Skipf("Skipping invocation count check: could not create Monitoring client: %v", err)
+	}
+	client, err := monitoring.NewMetricClient(ctx, opts...)
+	if err != nil {
+		t.Skipf("Skipping invocation count check: could not create Monitoring client: %v", err)
+	}
+	defer client.Close()
+
+	start := time.Now().Add(-window)
+	deadline := time.Now().Add(metricIngestionTimeout)
+	var count int64
+	for attempt := 1; ; attempt++ {
+		count, err = invocationCount(ctx, client, projectID, functionName, start)
+		if err != nil {
+			if isAPIUnavailable(err) {
+				t.Skipf("Skipping invocation count check: Cloud Monitoring is not readable in %s: %v", projectID, err)
+			}
+			t.Fatalf("Could not read the execution count of %s: %v", functionName, err)
+		}
+		if count >= minCount {
+			t.Logf("%s was invoked %d time(s) since %s", functionName, count, start.Format(time.RFC3339))
+			return
+		}
+		if time.Now().After(deadline) {
+			break
+		}
+		backoff := BackoffWithJitter(attempt)
+		t.Logf("%s has %d of %d expected invocation(s) so far, retrying in %s", functionName, count, minCount, backoff)
+		time.Sleep(backoff)
+	}
+
+	t.Errorf("Expected at least %d invocation(s) of %s since %s, Cloud Monitoring reports %d",
+		minCount, functionName, start.Format(time.RFC3339), count)
+}
+
+// InvocationCountFilter returns the Cloud Monitoring filter that selects
+// the execution count of the Cloud Function functionName.
+func InvocationCountFilter(functionName string) string {
+	return fmt.Sprintf(`metric.type="cloudfunctions.googleapis.com/function/execution_count" AND resource.labels.function_name=%q`,
+		functionName)
+}
+
+func invocationCount(ctx context.Context, client *monitoring.MetricClient, projectID, functionName string, start time.Time) (int64, error) {
+	series, err := timeSeries(ctx, client, projectID, InvocationCountFilter(functionName), start, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return sumPoints(series), nil
+}
+
+// sumPoints adds up the integer values of every point in series. The
+// execution count is a delta metric split by status, so the total is the sum
+// over all series and points.
+func sumPoints(series []*monitoringpb.TimeSeries) int64 {
+	var total int64
+	for _, s := range series {
+		for _, point := range s.GetPoints() {
+			total += point.GetValue().GetInt64Value()
+		}
+	}
+	return total
+}
+
+// AssertScaledToZero queries Cloud Monitoring for the active instances of the
+// Cloud Function functionName between start and end and fails the test unless
+// the count dropped to zero at some point. No samples at all fail it too: the
+// function served traffic just before start, so a missing series means the
+// metric hasn't arrived or the filter is wrong, not that it scaled to zero.
+// The check is skipped when the Monitoring API is disabled or the credentials
+// can't read metrics.
+func AssertScaledToZero(t testing.TB, projectID, functionName string, start, end time.Time) {
+	t.Helper()
+
+	ctx := context.Background()
+	opts, err := clientOptions(ctx)
+	if err != nil {
+		t.Skipf("Skipping scale-to-zero check: could not create Monitoring client: %v", err)
+	}
+	client, err := monitoring.NewMetricClient(ctx, opts...)
+	if err != nil {
+		t.Skipf("Skipping scale-to-zero check: could not create Monitoring client: %v", err)
+	}
+	defer client.Close()
+
+	deadline := time.Now().Add(metricIngestionTimeout)
+	var lowest int64
+	var seen bool
+	for attempt := 1; ; attempt++ {
+		series, err := timeSeries(ctx, client, projectID, ActiveInstancesFilter(functionName), start, end)
+		if err != nil {
+			if isAPIUnavailable(err) {
+				t.Skipf("Skipping scale-to-zero check: Cloud Monitoring is not readable in %s: %v", projectID, err)
+			}
+			t.Fatalf("Could not read the active instances of %s: %v", functionName, err)
+		}
+		lowest, seen = minPoint(series)
+		if seen && lowest == 0 {
+			t.Logf("%s scaled to zero instances between %s and %s", functionName, start.Format(time.RFC3339), end.Format(time.RFC3339))
+			return
+		}
+		if time.Now().After(deadline) {
+			break
+		}
+		backoff := BackoffWithJitter(attempt)
+		t.Logf("No zero-instance sample for %s yet, retrying in %s", functionName, backoff)
+		time.Sleep(backoff)
+	}
+
+	if !seen {
+		t.Errorf("Cloud Monitoring has no active instance samples for %s between %s and %s after %s, so scaling to zero can't be confirmed",
+			functionName, start.Format(time.RFC3339), end.Format(time.RFC3339), metricIngestionTimeout)
+		return
+	}
+	t.Errorf("%s never scaled to zero between %s and %s, it kept at least %d active instance(s)",
+		functionName, start.Format(time.RFC3339), end.Format(time.RFC3339), lowest)
+}
+
+// ActiveInstancesFilter returns the Cloud Monitoring filter that selects the
+// active instance count of the Cloud Function functionName.
+func ActiveInstancesFilter(functionName string) string {
+	return fmt.Sprintf(`metric.type="cloudfunctions.googleapis.com/function/active_instances" AND resource.labels.function_name=%q`,
+		functionName)
+}
+
+func timeSeries(ctx context.Context, client *monitoring.MetricClient, projectID, filter string, start, end time.Time) ([]*monitoringpb.TimeSeries, error) {
+	it := client.ListTimeSeries(ctx, &monitoringpb.ListTimeSeriesRequest{
+		Name:   "projects/" + projectID,
+		Filter: filter,
+		Interval: &monitoringpb.TimeInterval{
+			StartTime: timestamppb.New(start),
+			EndTime:   timestamppb.New(end),
+		},
+		View: monitoringpb.ListTimeSeriesRequest_FULL,
+	})
+
+	var series []*monitoringpb.TimeSeries
+	for {
+		s, err := it.Next()
+		if err == iterator.Done {
+			return series, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		series = append(series, s)
+	}
+}
+
+// minPoint returns the smallest integer value over every point in series,
+// and false when there are no points.
+func minPoint(series []*monitoringpb.TimeSeries) (int64, bool) {
+	var lowest int64
+	seen := false
+	for _, s := range series {
+		for _, point := range s.GetPoints() {
+			if v := point.GetValue().GetInt64Value(); !seen || v < lowest {
+				lowest = v
+				seen = true
+			}
+		}
+	}
+	return lowest, seen
+}
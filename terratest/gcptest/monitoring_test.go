@@ -0,0 +1,53 @@
+package gcptest
+
+import (
+	"testing"
+
+	"cloud.google.com/go/monitoring/apiv3/v2/monitoringpb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInvocationCountFilter(t *testing.T) {
+	assert.Equal(t,
+		`metric.type="cloudfunctions.googleapis.com/function/execution_count" AND resource.labels.function_name="hello-world-dev"`,
+		InvocationCountFilter("hello-world-dev"))
+}
+
+func TestSumPoints(t *testing.T) {
+	point := func(v int64) *monitoringpb.Point {
+		return &monitoringpb.Point{Value: &monitoringpb.TypedValue{Value: &monitoringpb.TypedValue_Int64Value{Int64Value: v}}}
+	}
+	series := []*monitoringpb.TimeSeries{
+		{Points: []*monitoringpb.Point{point(2), point(3)}},
+		{Points: []*monitoringpb.Point{point(1)}},
+	}
+
+	assert.EqualValues(t, 6, sumPoints(series))
+	assert.Zero(t, sumPoints(nil))
+}
+
+func TestActiveInstancesFilter(t *testing.T) {
+	assert.Equal(t,
+		`metric.type="cloudfunctions.googleapis.com/function/active_instances" AND resource.labels.function_name="hello-world-dev"`,
+		ActiveInstancesFilter("hello-world-dev"))
+}
+
+func TestMinPoint(t *testing.T) {
+	point := func(v int64) *monitoringpb.Point {
+		return &monitoringpb.Point{Value: &monitoringpb.TypedValue{Value: &monitoringpb.TypedValue_Int64Value{Int64Value: v}}}
+	}
+
+	lowest, ok := minPoint([]*monitoringpb.TimeSeries{
+		{Points: []*monitoringpb.Point{point(2), point(1)}},
+		{Points: []*monitoringpb.Point{point(3)}},
+	})
+	assert.True(t, ok)
+	assert.EqualValues(t, 1, lowest)
+
+	lowest, ok = minPoint([]*monitoringpb.TimeSeries{{Points: []*monitoringpb.Point{point(1), point(0)}}})
+	assert.True(t, ok)
+	assert.Zero(t, lowest)
+
+	_, ok = minPoint([]*monitoringpb.TimeSeries{{}})
+	assert.False(t, ok)
+}
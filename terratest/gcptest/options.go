@@ -0,0 +1,289 @@
+package gcptest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gruntwork-io/terratest/modules/logger"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	terratesting "github.com/gruntwork-io/terratest/modules/testing"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+)
+
+// BuildOptions returns the terraform.Options shared by every test, pointed at
+// dir and carrying a copy of vars plus any varFiles.
+//
+// Commands run without color and retry the known transient Terraform errors.
+// When vars has no region, GCP_REGION (if set) is used for it, and when it
+// has no labels, TestLabels is used so every resource is traceable to the run.
+// TF_PLUGIN_CACHE_DIR is passed through so repeated runs reuse downloaded
+// providers, TF_BINARY selects the CLI (see TerraformBinary), parallelism
+// comes from TF_PARALLELISM and TF_APPLY_PARALLELISM (see withParallelism),
+// and all command output is also written to a per-test log file (see
+// TEST_LOG_DIR). A TF_WORKSPACE from the environment is masked so init and
+// workspace selection work before that workspace exists; tests select it
+// through EnvWorkspace instead. Variables in TF_VARS_JSON that dir declares
+// (see varsFromEnv) override vars and varFiles, for ad-hoc runs.
+func BuildOptions(t testing.TB, dir string, vars map[string]interface{}, varFiles ...string) *terraform.Options {
+	t.Helper()
+
+	if err := ValidateRunID(RunID()); err != nil {
+		t.Fatal(err)
+	}
+	mergedVars := map[string]interface{}{
+		"labels": TestLabels(),
+	}
+	if region := os.Getenv("GCP_REGION"); region != "" {
+		mergedVars["region"] = region
+	}
+	for key, value := range vars {
+		mergedVars[key] = value
+	}
+	// -var flags take precedence over -var-file ones, so these win over varFiles too
+	for key, value := range varsFromEnv(t, dir) {
+		if _, ok := vars[key]; ok {
+			t.Logf("%s overrides the %s the test sets", TfVarsJSONEnvVar, key)
+		}
+		mergedVars[key] = value
+	}
+
+	envVars := map[string]string{}
+	// Reuse downloaded providers between runs when a plugin cache is configured
+	if pluginCacheDir := os.Getenv("TF_PLUGIN_CACHE_DIR"); pluginCacheDir != "" {
+		envVars["TF_PLUGIN_CACHE_DIR"] = pluginCacheDir
+	}
+	// Terraform ignores an empty TF_WORKSPACE
+	if EnvWorkspace() != "" {
+		envVars["TF_WORKSPACE"] = ""
+	}
+
+	retryableErrors := map[string]string{}
+	for pattern, message := range terraform.DefaultRetryableTerraformErrors {
+		retryableErrors[pattern] = message
+	}
+
+	terraformOptions := &terraform.Options{
+		TerraformDir:             dir,
+		TerraformBinary:          TerraformBinary(),
+		Vars:                     mergedVars,
+		EnvVars:                  envVars,
+		NoColor:                  true,
+		Upgrade:                  false,
+		RetryableTerraformErrors: retryableErrors,
+		MaxRetries:               3,
+		TimeBetweenRetries:       10 * time.Second,
+	}
+	for _, path := range varFiles {
+		WithTfVars(t, terraformOptions, path)
+	}
+	withParallelism(t, terraformOptions)
+	withFileLogger(t, terraformOptions)
+	return terraformOptions
+}
+
+// TerraformBinaryEnvVar names the CLI the tests run, as a name on PATH or a
+// path, e.g. tofu. It has to support every command the suite uses: init,
+// validate, workspace, plan, show -json, apply, output, state list and
+// destroy.
+const TerraformBinaryEnvVar = "TF_BINARY"
+
+// TerraformBinary returns the CLI named by TF_BINARY, or terraform when it
+// isn't set (terratest's default, which falls back to tofu when terraform
+// isn't on PATH).
+func TerraformBinary() string {
+	if binary := os.Getenv(TerraformBinaryEnvVar); binary != "" {
+		return binary
+	}
+	return terraform.DefaultExecutable
+}
+
+// DefaultApplyParallelism is how many resource operations terraform apply
+// runs at once unless TF_APPLY_PARALLELISM or TF_PARALLELISM says otherwise.
+// It is twice Terraform's own default of 10: the stack's resources spend most
+// of their time waiting on GCP, not on the runner.
+const DefaultApplyParallelism = 20
+
+// withParallelism passes -parallelism to plan and destroy when TF_PARALLELISM
+// is set, and always to apply: TF_APPLY_PARALLELISM, else TF_PARALLELISM,
+// else DefaultApplyParallelism.
+func withParallelism(t testing.TB, terraformOptions *terraform.Options) {
+	t.Helper()
+
+	parallelism := parallelismFromEnv(t, "TF_PARALLELISM", 0)
+	applyParallelism := parallelismFromEnv(t, "TF_APPLY_PARALLELISM", parallelism)
+	if applyParallelism == 0 {
+		applyParallelism = DefaultApplyParallelism
+	}
+
+	// Passed as extra args rather than Options.Parallelism, which would give
+	// apply the same value as plan and destroy
+	terraformOptions.ExtraArgs.Apply = append(terraformOptions.ExtraArgs.Apply, fmt.Sprintf("-parallelism=%d", applyParallelism))
+	if parallelism > 0 {
+		flag := fmt.Sprintf("-parallelism=%d", parallelism)
+		terraformOptions.ExtraArgs.Plan = append(terraformOptions.ExtraArgs.Plan, flag)
+		terraformOptions.ExtraArgs.Destroy = append(terraformOptions.ExtraArgs.Destroy, flag)
+	}
+}
+
+// parallelismFromEnv reads a positive parallelism from the environment
+// variable name, returning def when it is unset.
+func parallelismFromEnv(t testing.TB, name string, def int) int {
+	t.Helper()
+
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < 1 {
+		t.Fatalf("Invalid %s %q: expected a positive number", name, raw)
+	}
+	return value
+}
+
+// EnvWorkspace returns the Terraform workspace requested through the
+// TF_WORKSPACE environment variable, or "" when none is.
+func EnvWorkspace() string {
+	return os.Getenv("TF_WORKSPACE")
+}
+
+// TfVarsJSONEnvVar holds a JSON object of Terraform variables that
+// BuildOptions applies over every other source. It overrides what tests set
+// on purpose, so it is meant for ad-hoc runs narrowed down with -run.
+const TfVarsJSONEnvVar = "TF_VARS_JSON"
+
+// varsFromEnv decodes TF_VARS_JSON, failing the test when it isn't a JSON
+// object, and returns the variables in it that the Terraform root in dir
+// declares; the others are logged and left out, since Terraform rejects
+// values for undeclared variables. It returns nil when TF_VARS_JSON isn't set.
+func varsFromEnv(t testing.TB, dir string) map[string]interface{} {
+	t.Helper()
+
+	raw := os.Getenv(TfVarsJSONEnvVar)
+	if strings.TrimSpace(raw) == "" {
+		return nil
+	}
+	vars, err := parseVarsJSON(raw)
+	if err != nil {
+		t.Fatalf("Invalid %s, expected a JSON object of variable values: %v", TfVarsJSONEnvVar, err)
+	}
+	declared, err := declaredVariables(dir)
+	if err != nil {
+		t.Fatalf("Could not read the variables %s declares: %v", dir, err)
+	}
+	for key := range vars {
+		if !declared[key] {
+			t.Logf("Ignoring %s variable %s: %s does not declare it", TfVarsJSONEnvVar, key, dir)
+			delete(vars, key)
+		}
+	}
+	return vars
+}
+
+// declaredVariables returns the names of the variables declared in dir's .tf
+// files.
+func declaredVariables(dir string) (map[string]bool, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.tf"))
+	if err != nil {
+		return nil, err
+	}
+	parser := hclparse.NewParser()
+	declared := map[string]bool{}
+	for _, path := range files {
+		file, diags := parser.ParseHCLFile(path)
+		if diags.HasErrors() {
+			return nil, diags
+		}
+		content, _, _ := file.Body.PartialContent(&hcl.BodySchema{
+			Blocks: []hcl.BlockHeaderSchema{{Type: "variable", LabelNames: []string{"name"}}},
+		})
+		for _, block := range content.Blocks {
+			declared[block.Labels[0]] = true
+		}
+	}
+	return declared, nil
+}
+
+// parseVarsJSON decodes raw, which must be a JSON object, into variable
+// values.
+func parseVarsJSON(raw string) (map[string]interface{}, error) {
+	var vars map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &vars); err != nil {
+		return nil, err
+	}
+	if vars == nil {
+		return nil, fmt.Errorf("got null")
+	}
+	return vars, nil
+}
+
+// WithTfVars adds a .tfvars file to terraformOptions. Relative paths are
+// resolved against the test's working directory, since terraform itself runs
+// from TerraformDir.
+func WithTfVars(t testing.TB, terraformOptions *terraform.Options, path string) {
+	t.Helper()
+
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		t.Fatalf("Could not resolve tfvars path %s: %v", path, err)
+	}
+	if _, err := os.Stat(absPath); err != nil {
+		t.Fatalf("tfvars file %s is not readable: %v", absPath, err)
+	}
+	terraformOptions.VarFiles = append(terraformOptions.VarFiles, absPath)
+}
+
+// defaultTestLogDir is where per-test terraform logs go unless TEST_LOG_DIR is set.
+const defaultTestLogDir = "test-logs"
+
+// fileLogger tees terratest log lines to stdout and a per-test log file.
+type fileLogger struct {
+	mu     sync.Mutex
+	writer io.Writer
+}
+
+func (l *fileLogger) Logf(t terratesting.TestingT, format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	logger.DoLog(t, 3, l.writer, fmt.Sprintf(format, args...))
+}
+
+// withFileLogger makes every terraform command run through terraformOptions
+// log to <TEST_LOG_DIR>/<test name>.log, and points at that file when the
+// test fails so failed deployments can be inspected without a re-run.
+func withFileLogger(t testing.TB, terraformOptions *terraform.Options) {
+	t.Helper()
+
+	logDir := os.Getenv("TEST_LOG_DIR")
+	if logDir == "" {
+		logDir = defaultTestLogDir
+	}
+	if err := os.MkdirAll(logDir, 0o755); err != nil {
+		t.Logf("Could not create log directory %s, logging to stdout only: %v", logDir, err)
+		return
+	}
+
+	logPath := filepath.Join(logDir, strings.ReplaceAll(t.Name(), "/", "_")+".log")
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Logf("Could not open log file %s, logging to stdout only: %v", logPath, err)
+		return
+	}
+
+	terraformOptions.Logger = logger.New(&fileLogger{writer: io.MultiWriter(os.Stdout, logFile)})
+	t.Cleanup(func() {
+		logFile.Close()
+		if t.Failed() {
+			t.Logf("Terraform output for this test was saved to %s", logPath)
+		}
+	})
+}
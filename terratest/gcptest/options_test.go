@@ -0,0 +1,161 @@
+package gcptest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildOptionsCopiesVars(t *testing.T) {
+	t.Setenv("TEST_LOG_DIR", t.TempDir())
+
+	vars := map[string]interface{}{"project_id": "my-project"}
+	options := BuildOptions(t, "../../environments/dev", vars)
+	vars["project_id"] = "changed"
+
+	assert.Equal(t, "../../environments/dev", options.TerraformDir)
+	assert.Equal(t, "my-project", options.Vars["project_id"], "Options should hold a copy of the caller's vars")
+	assert.True(t, options.NoColor)
+	assert.False(t, options.Upgrade)
+	assert.Equal(t, 3, options.MaxRetries)
+	assert.NotEmpty(t, options.RetryableTerraformErrors)
+	assert.NotNil(t, options.Logger)
+}
+
+func TestBuildOptionsRegion(t *testing.T) {
+	t.Setenv("TEST_LOG_DIR", t.TempDir())
+
+	t.Setenv("GCP_REGION", "")
+	assert.NotContains(t, BuildOptions(t, ".", nil).Vars, "region")
+
+	t.Setenv("GCP_REGION", "europe-west1")
+	assert.Equal(t, "europe-west1", BuildOptions(t, ".", nil).Vars["region"])
+
+	explicit := BuildOptions(t, ".", map[string]interface{}{"region": "asia-east1"})
+	assert.Equal(t, "asia-east1", explicit.Vars["region"], "An explicit region var should win over GCP_REGION")
+}
+
+func TestBuildOptionsTfVarsJSON(t *testing.T) {
+	t.Setenv("TEST_LOG_DIR", t.TempDir())
+
+	dir := t.TempDir()
+	declarations := `variable "project_id" {}
+variable "environment" {}
+variable "max_instances" {}
+variable "allowed_origins" {}
+`
+	if err := os.WriteFile(filepath.Join(dir, "variables.tf"), []byte(declarations), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(TfVarsJSONEnvVar, "")
+	assert.Equal(t, "my-project", BuildOptions(t, dir, map[string]interface{}{"project_id": "my-project"}).Vars["project_id"])
+
+	t.Setenv(TfVarsJSONEnvVar, `{"project_id": "other-project", "max_instances": 3, "allowed_origins": ["https://example.com"], "vpc_connector": "c"}`)
+	options := BuildOptions(t, dir, map[string]interface{}{"project_id": "my-project", "environment": "dev"})
+	assert.Equal(t, "other-project", options.Vars["project_id"], "TF_VARS_JSON should win over the inline vars")
+	assert.Equal(t, 3.0, options.Vars["max_instances"])
+	assert.Equal(t, []interface{}{"https://example.com"}, options.Vars["allowed_origins"])
+	assert.Equal(t, "dev", options.Vars["environment"], "Vars missing from TF_VARS_JSON are kept")
+	assert.NotContains(t, options.Vars, "vpc_connector", "Variables the root doesn't declare would fail terraform")
+
+	for _, invalid := range []string{`{"project_id": `, `["project_id"]`, `"my-project"`, `null`} {
+		_, err := parseVarsJSON(invalid)
+		assert.Error(t, err, "%s is not a JSON object", invalid)
+	}
+}
+
+func TestBuildOptionsPluginCache(t *testing.T) {
+	t.Setenv("TEST_LOG_DIR", t.TempDir())
+
+	t.Setenv("TF_PLUGIN_CACHE_DIR", "")
+	assert.NotContains(t, BuildOptions(t, ".", nil).EnvVars, "TF_PLUGIN_CACHE_DIR")
+
+	t.Setenv("TF_PLUGIN_CACHE_DIR", "/tmp/plugin-cache")
+	assert.Equal(t, "/tmp/plugin-cache", BuildOptions(t, ".", nil).EnvVars["TF_PLUGIN_CACHE_DIR"])
+}
+
+func TestBuildOptionsTerraformBinary(t *testing.T) {
+	t.Setenv("TEST_LOG_DIR", t.TempDir())
+
+	t.Setenv(TerraformBinaryEnvVar, "")
+	assert.Equal(t, terraform.DefaultExecutable, BuildOptions(t, ".", nil).TerraformBinary)
+
+	t.Setenv(TerraformBinaryEnvVar, "tofu")
+	assert.Equal(t, "tofu", BuildOptions(t, ".", nil).TerraformBinary)
+}
+
+func TestBuildOptionsParallelism(t *testing.T) {
+	t.Setenv("TEST_LOG_DIR", t.TempDir())
+
+	t.Setenv("TF_PARALLELISM", "")
+	t.Setenv("TF_APPLY_PARALLELISM", "")
+	options := BuildOptions(t, ".", nil)
+	assert.Equal(t, []string{"-parallelism=20"}, options.ExtraArgs.Apply, "Apply should default above Terraform's 10")
+	assert.Empty(t, options.ExtraArgs.Plan)
+	assert.Empty(t, options.ExtraArgs.Destroy)
+	assert.Zero(t, options.Parallelism)
+
+	t.Setenv("TF_PARALLELISM", "4")
+	options = BuildOptions(t, ".", nil)
+	assert.Equal(t, []string{"-parallelism=4"}, options.ExtraArgs.Apply)
+	assert.Equal(t, []string{"-parallelism=4"}, options.ExtraArgs.Plan)
+	assert.Equal(t, []string{"-parallelism=4"}, options.ExtraArgs.Destroy)
+
+	t.Setenv("TF_APPLY_PARALLELISM", "30")
+	options = BuildOptions(t, ".", nil)
+	assert.Equal(t, []string{"-parallelism=30"}, options.ExtraArgs.Apply)
+	assert.Equal(t, []string{"-parallelism=4"}, options.ExtraArgs.Plan, "TF_APPLY_PARALLELISM only applies to apply")
+}
+
+func TestBuildOptionsVarFiles(t *testing.T) {
+	t.Setenv("TEST_LOG_DIR", t.TempDir())
+
+	dir := t.TempDir()
+	varFile := filepath.Join(dir, "prod.tfvars")
+	require.NoError(t, os.WriteFile(varFile, []byte("region = \"europe-west1\"\n"), 0o644))
+
+	options := BuildOptions(t, ".", nil, varFile)
+
+	assert.Equal(t, []string{varFile}, options.VarFiles)
+}
+
+func TestBuildOptionsWritesLogFile(t *testing.T) {
+	logDir := t.TempDir()
+	t.Setenv("TEST_LOG_DIR", logDir)
+
+	options := BuildOptions(t, ".", nil)
+	options.Logger.Logf(t, "hello from %s", "the logger")
+
+	content, err := os.ReadFile(filepath.Join(logDir, "TestBuildOptionsWritesLogFile.log"))
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "hello from the logger")
+}
+
+func TestBuildOptionsLabels(t *testing.T) {
+	t.Setenv("TEST_LOG_DIR", t.TempDir())
+
+	assert.Equal(t, TestLabels(), BuildOptions(t, ".", nil).Vars["labels"])
+
+	custom := map[string]string{"team": "platform"}
+	explicit := BuildOptions(t, ".", map[string]interface{}{"labels": custom})
+	assert.Equal(t, custom, explicit.Vars["labels"], "Explicit labels should win over the test labels")
+}
+
+func TestBuildOptionsMasksEnvWorkspace(t *testing.T) {
+	t.Setenv("TEST_LOG_DIR", t.TempDir())
+
+	t.Setenv("TF_WORKSPACE", "")
+	assert.NotContains(t, BuildOptions(t, ".", nil).EnvVars, "TF_WORKSPACE")
+
+	t.Setenv("TF_WORKSPACE", "feature-x")
+	assert.Equal(t, "feature-x", EnvWorkspace())
+	options := BuildOptions(t, ".", nil)
+	if assert.Contains(t, options.EnvVars, "TF_WORKSPACE") {
+		assert.Empty(t, options.EnvVars["TF_WORKSPACE"], "The workspace is selected by the tests, not inherited")
+	}
+}
@@ -0,0 +1,67 @@
+package gcptest
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// DefaultProjectID is the original development project. It is only used when
+// ALLOW_DEFAULT_PROJECT=1 is set, so nobody applies into it by accident.
+const DefaultProjectID = "smt-the-dev-kevinloygtz-r4ch"
+
+// projectIDEnvVars are checked in order to find the GCP project to test against.
+// GOOGLE_PROJECT is kept for compatibility with the existing workflows and docs.
+var projectIDEnvVars = []string{"GCP_PROJECT_ID", "GOOGLE_CLOUD_PROJECT", "GOOGLE_PROJECT"}
+
+// OfflineEnvVar set to 1 marks a run without GCP access, such as pre-merge CI.
+const OfflineEnvVar = "OFFLINE"
+
+// IsOffline reports whether OfflineEnvVar is set to 1.
+func IsOffline() bool {
+	return os.Getenv(OfflineEnvVar) == "1"
+}
+
+// GetProjectID returns the GCP project ID from GCP_PROJECT_ID,
+// GOOGLE_CLOUD_PROJECT or GOOGLE_PROJECT, in that order, and skips the test
+// when none is set or the run IsOffline. DefaultProjectID is used instead of
+// skipping only when ALLOW_DEFAULT_PROJECT=1.
+func GetProjectID(t testing.TB) string {
+	t.Helper()
+
+	if IsOffline() {
+		t.Skipf("Skipping: %s=1 is set and this test needs GCP", OfflineEnvVar)
+	}
+	projectID, isDefault := lookupProjectID()
+	if isDefault {
+		t.Logf("No project configured, falling back to default project %s", DefaultProjectID)
+	}
+	if projectID != "" {
+		return projectID
+	}
+
+	t.Skipf("Skipping test: set one of %s to the GCP project to test against "+
+		"(or ALLOW_DEFAULT_PROJECT=1 to use %s)", strings.Join(projectIDEnvVars, ", "), DefaultProjectID)
+	return ""
+}
+
+// LookupProjectID is GetProjectID for code that runs outside a test, such as
+// TestMain: it reports false instead of skipping when no project is set.
+func LookupProjectID() (string, bool) {
+	projectID, _ := lookupProjectID()
+	return projectID, projectID != ""
+}
+
+// lookupProjectID returns the configured project, or "" when there is none,
+// and whether it is the DefaultProjectID fallback.
+func lookupProjectID() (projectID string, isDefault bool) {
+	for _, name := range projectIDEnvVars {
+		if projectID := os.Getenv(name); projectID != "" {
+			return projectID, false
+		}
+	}
+	if os.Getenv("ALLOW_DEFAULT_PROJECT") == "1" {
+		return DefaultProjectID, true
+	}
+	return "", false
+}
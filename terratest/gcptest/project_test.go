@@ -0,0 +1,71 @@
+package gcptest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func clearProjectEnv(t *testing.T) {
+	for _, name := range projectIDEnvVars {
+		t.Setenv(name, "")
+	}
+	t.Setenv("ALLOW_DEFAULT_PROJECT", "")
+	t.Setenv(OfflineEnvVar, "")
+}
+
+func TestGetProjectIDPrecedence(t *testing.T) {
+	clearProjectEnv(t)
+	t.Setenv("GOOGLE_PROJECT", "from-google-project")
+	assert.Equal(t, "from-google-project", GetProjectID(t))
+
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "from-google-cloud-project")
+	assert.Equal(t, "from-google-cloud-project", GetProjectID(t))
+
+	t.Setenv("GCP_PROJECT_ID", "from-gcp-project-id")
+	assert.Equal(t, "from-gcp-project-id", GetProjectID(t))
+}
+
+func TestGetProjectIDDefaultFallback(t *testing.T) {
+	clearProjectEnv(t)
+	t.Setenv("ALLOW_DEFAULT_PROJECT", "1")
+
+	assert.Equal(t, DefaultProjectID, GetProjectID(t))
+}
+
+func TestGetProjectIDSkipsWhenUnset(t *testing.T) {
+	clearProjectEnv(t)
+
+	skipped := false
+	t.Run("unset", func(t *testing.T) {
+		defer func() { skipped = t.Skipped() }()
+		GetProjectID(t)
+	})
+
+	assert.True(t, skipped, "GetProjectID should skip when no project is configured")
+}
+
+func TestGetProjectIDSkipsWhenOffline(t *testing.T) {
+	clearProjectEnv(t)
+	t.Setenv("GCP_PROJECT_ID", "from-gcp-project-id")
+	t.Setenv(OfflineEnvVar, "1")
+
+	skipped := false
+	t.Run("offline", func(t *testing.T) {
+		defer func() { skipped = t.Skipped() }()
+		GetProjectID(t)
+	})
+
+	assert.True(t, skipped, "GetProjectID should skip offline even when a project is configured")
+}
+
+func TestLookupProjectID(t *testing.T) {
+	clearProjectEnv(t)
+	_, ok := LookupProjectID()
+	assert.False(t, ok, "No project should be found when none is configured")
+
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "from-google-cloud-project")
+	projectID, ok := LookupProjectID()
+	assert.True(t, ok)
+	assert.Equal(t, "from-google-cloud-project", projectID)
+}
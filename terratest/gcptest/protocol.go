@@ -0,0 +1,56 @@
+package gcptest
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// AssertHTTP2 fails the test unless a GET to url negotiates HTTP/2 (ALPN
+// "h2"), reporting the protocol that was negotiated instead.
+func AssertHTTP2(t testing.TB, url string) {
+	t.Helper()
+
+	protocol, err := NegotiatedProtocol(url)
+	if err != nil {
+		t.Fatalf("Could not GET %s: %v", url, err)
+	}
+	if protocol != "h2" {
+		t.Errorf("Expected %s to negotiate h2, got %s", url, protocol)
+	}
+}
+
+// NegotiatedProtocol GETs url offering both h2 and http/1.1 via ALPN and
+// returns the protocol the server picked, "h2" or "http/1.1".
+func NegotiatedProtocol(url string) (string, error) {
+	transport := &http.Transport{
+		ForceAttemptHTTP2: true,
+		TLSClientConfig: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			NextProtos: []string{"h2", "http/1.1"},
+		},
+	}
+	defer transport.CloseIdleConnections()
+
+	return negotiatedProtocol(&http.Client{Transport: transport, Timeout: 30 * time.Second}, url)
+}
+
+func negotiatedProtocol(client *http.Client, url string) (string, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	switch resp.ProtoMajor {
+	case 2:
+		return "h2", nil
+	case 1:
+		return "http/1.1", nil
+	}
+	return "", fmt.Errorf("unexpected protocol %s", resp.Proto)
+}
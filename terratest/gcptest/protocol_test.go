@@ -0,0 +1,32 @@
+package gcptest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiatedProtocol(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Hello")
+	})
+
+	h2 := httptest.NewUnstartedServer(handler)
+	h2.EnableHTTP2 = true
+	h2.StartTLS()
+	defer h2.Close()
+
+	protocol, err := negotiatedProtocol(h2.Client(), h2.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, "h2", protocol)
+
+	h1 := httptest.NewTLSServer(handler)
+	defer h1.Close()
+
+	protocol, err = negotiatedProtocol(h1.Client(), h1.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, "http/1.1", protocol)
+}
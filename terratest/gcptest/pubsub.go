@@ -0,0 +1,52 @@
+package gcptest
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/pubsub/v1"
+)
+
+// PublishMessage publishes data to the Pub/Sub topic in projectID, given by
+// name or as a full projects/.../topics/... path, and returns the ID Pub/Sub
+// assigned the message. The test is skipped when the Pub/Sub API is disabled
+// or the credentials can't publish.
+func PublishMessage(t testing.TB, projectID, topic string, data []byte) string {
+	t.Helper()
+
+	ctx := context.Background()
+	opts, err := clientOptions(ctx)
+	if err != nil {
+		t.Skipf("Skipping Pub/Sub check: could not create Pub/Sub client: %v", err)
+	}
+	service, err := pubsub.NewService(ctx, opts...)
+	if err != nil {
+		t.Skipf("Skipping Pub/Sub check: could not create Pub/Sub client: %v", err)
+	}
+
+	path := TopicPath(projectID, topic)
+	resp, err := service.Projects.Topics.Publish(path, &pubsub.PublishRequest{
+		Messages: []*pubsub.PubsubMessage{{Data: base64.StdEncoding.EncodeToString(data)}},
+	}).Context(ctx).Do()
+	if err != nil {
+		if isAPIUnavailable(err) {
+			t.Skipf("Skipping Pub/Sub check: can't publish to %s: %v", path, err)
+		}
+		t.Fatalf("Could not publish to %s: %v", path, err)
+	}
+	if len(resp.MessageIds) != 1 {
+		t.Fatalf("Publishing one message to %s returned %d message IDs", path, len(resp.MessageIds))
+	}
+	return resp.MessageIds[0]
+}
+
+// TopicPath returns the full resource name of the Pub/Sub topic in
+// projectID; a topic that already is a full name is returned as is.
+func TopicPath(projectID, topic string) string {
+	if strings.HasPrefix(topic, "projects/") {
+		return topic
+	}
+	return "projects/" + projectID + "/topics/" + topic
+}
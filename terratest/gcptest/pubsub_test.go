@@ -0,0 +1,13 @@
+package gcptest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopicPath(t *testing.T) {
+	assert.Equal(t, "projects/my-project/topics/hello", TopicPath("my-project", "hello"))
+	assert.Equal(t, "projects/other/topics/hello", TopicPath("my-project", "projects/other/topics/hello"),
+		"A full topic name may point at another project")
+}
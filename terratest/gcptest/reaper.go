@@ -0,0 +1,144 @@
+package gcptest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	functions "cloud.google.com/go/functions/apiv1"
+	"cloud.google.com/go/functions/apiv1/functionspb"
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// ReapByLabel deletes the Cloud Functions and storage buckets in projectID
+// that were created by terratest (see TestLabels) under a run other than
+// runID and are older than olderThan. Failures to delete one resource are
+// reported and the sweep carries on with the rest.
+//
+// Only functions and buckets carry both the labels and a creation time.
+// The rest of a deployment is left behind: the Pub/Sub topic, which has no
+// creation time, and the service account, logging sink, Cloud Armor policy,
+// health check and load balancer resources, which have no labels. Destroy
+// those through their Terraform workspace, as TestCleanupOrphans does.
+func ReapByLabel(t testing.TB, projectID, runID string, olderThan time.Duration) {
+	t.Helper()
+
+	ctx := context.Background()
+	cutoff := time.Now().Add(-olderThan)
+
+	reapFunctions(ctx, t, projectID, runID, cutoff)
+	reapBuckets(ctx, t, projectID, runID, cutoff)
+}
+
+// IsStale reports whether a resource with the given labels and creation time
+// was left behind by a terratest run other than runID before cutoff.
+func IsStale(labels map[string]string, created time.Time, runID string, cutoff time.Time) bool {
+	if labels[LabelCreatedBy] != createdByTerratest {
+		return false
+	}
+	id, ok := labels[LabelTestRunID]
+	if !ok || id == runID {
+		return false
+	}
+	return created.Before(cutoff)
+}
+
+func reapFunctions(ctx context.Context, t testing.TB, projectID, runID string, cutoff time.Time) {
+	t.Helper()
+
+	opts, err := clientOptions(ctx)
+	if err != nil {
+		t.Errorf("Could not create Cloud Functions client: %v", err)
+		return
+	}
+	client, err := functions.NewCloudFunctionsClient(ctx, opts...)
+	if err != nil {
+		t.Errorf("Could not create Cloud Functions client: %v", err)
+		return
+	}
+	defer client.Close()
+
+	it := client.ListFunctions(ctx, &functionspb.ListFunctionsRequest{
+		Parent: fmt.Sprintf("projects/%s/locations/-", projectID),
+	})
+	for {
+		function, err := it.Next()
+		if err == iterator.Done {
+			return
+		}
+		if err != nil {
+			t.Errorf("Could not list Cloud Functions in %s: %v", projectID, err)
+			return
+		}
+		// Functions carry no creation time; the last update is the closest proxy
+		if !IsStale(function.GetLabels(), function.GetUpdateTime().AsTime(), runID, cutoff) {
+			continue
+		}
+
+		t.Logf("Deleting stale function %s (run %s)", function.GetName(), function.GetLabels()[LabelTestRunID])
+		op, err := client.DeleteFunction(ctx, &functionspb.DeleteFunctionRequest{Name: function.GetName()})
+		if err == nil {
+			err = op.Wait(ctx)
+		}
+		if err != nil {
+			t.Errorf("Could not delete function %s: %v", function.GetName(), err)
+		}
+	}
+}
+
+func reapBuckets(ctx context.Context, t testing.TB, projectID, runID string, cutoff time.Time) {
+	t.Helper()
+
+	opts, err := clientOptions(ctx)
+	if err != nil {
+		t.Errorf("Could not create Cloud Storage client: %v", err)
+		return
+	}
+	client, err := storage.NewClient(ctx, opts...)
+	if err != nil {
+		t.Errorf("Could not create Cloud Storage client: %v", err)
+		return
+	}
+	defer client.Close()
+
+	it := client.Buckets(ctx, projectID)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return
+		}
+		if err != nil {
+			t.Errorf("Could not list buckets in %s: %v", projectID, err)
+			return
+		}
+		if !IsStale(attrs.Labels, attrs.Created, runID, cutoff) {
+			continue
+		}
+
+		t.Logf("Deleting stale bucket %s (run %s)", attrs.Name, attrs.Labels[LabelTestRunID])
+		if err := deleteBucket(ctx, client.Bucket(attrs.Name)); err != nil {
+			t.Errorf("Could not delete bucket %s: %v", attrs.Name, err)
+		}
+	}
+}
+
+// deleteBucket removes every object version in bucket and then the bucket,
+// since buckets must be empty to be deleted.
+func deleteBucket(ctx context.Context, bucket *storage.BucketHandle) error {
+	it := bucket.Objects(ctx, &storage.Query{Versions: true})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := bucket.Object(attrs.Name).Generation(attrs.Generation).Delete(ctx); err != nil {
+			return err
+		}
+	}
+	return bucket.Delete(ctx)
+}
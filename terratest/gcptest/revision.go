@@ -0,0 +1,92 @@
+package gcptest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	functions "cloud.google.com/go/functions/apiv1"
+	"cloud.google.com/go/functions/apiv1/functionspb"
+	"google.golang.org/api/iterator"
+)
+
+// FunctionRevision identifies the code a Cloud Function is serving. 1st gen
+// functions, the only kind this repo deploys, have no revisions, so it is the
+// source archive the function was last deployed from; a 2nd gen function would
+// be identified by its Cloud Run revision instead.
+func FunctionRevision(function *functionspb.CloudFunction) string {
+	return function.GetSourceArchiveUrl()
+}
+
+// WaitForRevision polls the Cloud Functions API until the function
+// functionName in projectID is ACTIVE and serving expectedRevision (see
+// FunctionRevision), so tests don't run against the previous deployment.
+// On timeout the error names the revision that is serving instead.
+func WaitForRevision(t testing.TB, projectID, functionName, expectedRevision string, timeout time.Duration) error {
+	t.Helper()
+
+	ctx := context.Background()
+	opts, err := clientOptions(ctx)
+	if err != nil {
+		return fmt.Errorf("could not create Cloud Functions client: %w", err)
+	}
+	client, err := functions.NewCloudFunctionsClient(ctx, opts...)
+	if err != nil {
+		return fmt.Errorf("could not create Cloud Functions client: %w", err)
+	}
+	defer client.Close()
+
+	deadline := time.Now().Add(timeout)
+	var current string
+	for attempt := 1; ; attempt++ {
+		function, err := findFunction(ctx, client, projectID, functionName)
+		switch {
+		case err != nil:
+			current = err.Error()
+		case function == nil:
+			current = "function not found"
+		default:
+			if function.GetStatus() == functionspb.CloudFunctionStatus_ACTIVE && FunctionRevision(function) == expectedRevision {
+				t.Logf("%s is serving %s (version %d)", functionName, expectedRevision, function.GetVersionId())
+				return nil
+			}
+			current = fmt.Sprintf("%s (version %d, %s)", FunctionRevision(function), function.GetVersionId(), function.GetStatus())
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s was not serving %s after %s, currently serving %s",
+				functionName, expectedRevision, timeout, current)
+		}
+		backoff := BackoffWithJitter(attempt)
+		t.Logf("%s is serving %s, waiting %s for %s", functionName, current, backoff, expectedRevision)
+		time.Sleep(backoff)
+	}
+}
+
+// findFunction returns the function named functionName in any region of
+// projectID, or nil when there is none.
+func findFunction(ctx context.Context, client *functions.CloudFunctionsClient, projectID, functionName string) (*functionspb.CloudFunction, error) {
+	it := client.ListFunctions(ctx, &functionspb.ListFunctionsRequest{
+		Parent: fmt.Sprintf("projects/%s/locations/-", projectID),
+	})
+	for {
+		function, err := it.Next()
+		if err == iterator.Done {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if IsFunctionNamed(function, functionName) {
+			return function, nil
+		}
+	}
+}
+
+// IsFunctionNamed reports whether function, whose full name is
+// projects/*/locations/*/functions/<name>, has the short name functionName.
+func IsFunctionNamed(function *functionspb.CloudFunction, functionName string) bool {
+	return strings.HasSuffix(function.GetName(), "/functions/"+functionName)
+}
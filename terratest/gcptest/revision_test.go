@@ -0,0 +1,22 @@
+package gcptest
+
+import (
+	"testing"
+
+	"cloud.google.com/go/functions/apiv1/functionspb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFunctionRevision(t *testing.T) {
+	function := &functionspb.CloudFunction{
+		Name:       "projects/my-project/locations/us-central1/functions/hello-world-dev",
+		SourceCode: &functionspb.CloudFunction_SourceArchiveUrl{SourceArchiveUrl: "gs://my-project-function-source-dev/function-source-0123.zip"},
+		VersionId:  3,
+		Status:     functionspb.CloudFunctionStatus_ACTIVE,
+	}
+
+	assert.Equal(t, "gs://my-project-function-source-dev/function-source-0123.zip", FunctionRevision(function))
+	assert.True(t, IsFunctionNamed(function, "hello-world-dev"))
+	assert.False(t, IsFunctionNamed(function, "world-dev"))
+	assert.False(t, IsFunctionNamed(function, "hello-world"))
+}
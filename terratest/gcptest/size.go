@@ -0,0 +1,85 @@
+package gcptest
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// AssertResponseSizeUnder fetches url with the given headers and fails the
+// test if the body is larger than maxBytes. The body is streamed and counted
+// rather than buffered, so a runaway response can't exhaust memory.
+func AssertResponseSizeUnder(t testing.TB, url string, maxBytes int, headers map[string]string) {
+	t.Helper()
+
+	size, err := responseSize(url, headers)
+	if err != nil {
+		t.Fatalf("Could not read the response from %s: %v", url, err)
+	}
+	if size > int64(maxBytes) {
+		t.Errorf("Response from %s is %d bytes, over the %d byte limit", url, size, maxBytes)
+	}
+}
+
+// responseSize GETs url and returns the number of body bytes it sent.
+func responseSize(url string, headers map[string]string) (int64, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	size, err := io.Copy(io.Discard, resp.Body)
+	if err != nil {
+		return size, fmt.Errorf("reading body after %d bytes: %w", size, err)
+	}
+	return size, nil
+}
+
+// PostStream POSTs a body of size bytes to url and returns the status code
+// and the start of the response body. The body is generated as it is sent
+// rather than held in memory, and the connection is closed before returning
+// so repeated calls don't leak file descriptors.
+func PostStream(url string, size int64) (int, string, error) {
+	req, err := http.NewRequest(http.MethodPost, url, io.LimitReader(filler('a'), size))
+	if err != nil {
+		return 0, "", err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Close = true
+
+	transport := &http.Transport{}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport, Timeout: 2 * time.Minute}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return resp.StatusCode, string(body), err
+}
+
+// filler is an endless reader of a single repeated byte.
+type filler byte
+
+func (f filler) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(f)
+	}
+	return len(p), nil
+}
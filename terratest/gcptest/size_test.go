@@ -0,0 +1,54 @@
+package gcptest
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAssertResponseSizeUnder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(strings.Repeat("a", 4096)))
+	}))
+	defer server.Close()
+
+	headers := map[string]string{"Authorization": "Bearer token"}
+	AssertResponseSizeUnder(t, server.URL, 4096, headers)
+
+	size, err := responseSize(server.URL, headers)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 4096, size)
+}
+
+func TestPostStream(t *testing.T) {
+	const limit = 1024
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > limit {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			fmt.Fprint(w, `{"error": "too large"}`)
+			return
+		}
+		n, _ := io.Copy(io.Discard, r.Body)
+		fmt.Fprintf(w, "read %d", n)
+	}))
+	defer server.Close()
+
+	status, body, err := PostStream(server.URL, 512)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "read 512", body)
+
+	status, body, err = PostStream(server.URL, 1<<20)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, status)
+	assert.Contains(t, body, "too large")
+}
@@ -0,0 +1,105 @@
+package gcptest
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"cloud.google.com/go/storage"
+)
+
+// SourceContentHash hashes the named files under dir the same way
+// ArchiveContentHash hashes a zip, so a local source tree can be compared
+// with a deployed archive without depending on zip timestamps or ordering.
+func SourceContentHash(dir string, files []string) (string, error) {
+	contents := map[string][]byte{}
+	for _, name := range files {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return "", err
+		}
+		contents[name] = data
+	}
+	return contentHash(contents), nil
+}
+
+// ArchiveContentHash hashes the names and contents of every file in the zip
+// archive data.
+func ArchiveContentHash(data []byte) (string, error) {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("reading zip archive: %w", err)
+	}
+
+	contents := map[string][]byte{}
+	for _, file := range reader.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := file.Open()
+		if err != nil {
+			return "", fmt.Errorf("opening %s in archive: %w", file.Name, err)
+		}
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return "", fmt.Errorf("reading %s in archive: %w", file.Name, err)
+		}
+		contents[file.Name] = content
+	}
+	return contentHash(contents), nil
+}
+
+// contentHash is the SHA-256 of each file's name and content SHA-256, in
+// name order.
+func contentHash(contents map[string][]byte) string {
+	names := make([]string, 0, len(contents))
+	for name := range contents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		sum := sha256.Sum256(contents[name])
+		fmt.Fprintf(h, "%s\x00%s\n", name, hex.EncodeToString(sum[:]))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// DownloadObject returns the content of object in bucket, failing the test
+// if it can't be read.
+func DownloadObject(t testing.TB, bucket, object string) []byte {
+	t.Helper()
+
+	ctx := context.Background()
+	opts, err := clientOptions(ctx)
+	if err != nil {
+		t.Fatalf("Could not create Cloud Storage client: %v", err)
+	}
+	client, err := storage.NewClient(ctx, opts...)
+	if err != nil {
+		t.Fatalf("Could not create Cloud Storage client: %v", err)
+	}
+	defer client.Close()
+
+	reader, err := client.Bucket(bucket).Object(object).NewReader(ctx)
+	if err != nil {
+		t.Fatalf("Could not open gs://%s/%s: %v", bucket, object, err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Could not read gs://%s/%s: %v", bucket, object, err)
+	}
+	return data
+}
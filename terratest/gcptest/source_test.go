@@ -0,0 +1,51 @@
+package gcptest
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func zipArchive(t *testing.T, files map[string]string, order []string) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, name := range order {
+		f, err := w.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(files[name]))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestSourceAndArchiveContentHash(t *testing.T) {
+	files := map[string]string{"main.py": "print('hi')\n", "requirements.txt": "flask\n"}
+
+	dir := t.TempDir()
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+	local, err := SourceContentHash(dir, []string{"main.py", "requirements.txt"})
+	require.NoError(t, err)
+
+	// Entry order in the archive doesn't matter
+	deployed, err := ArchiveContentHash(zipArchive(t, files, []string{"requirements.txt", "main.py"}))
+	require.NoError(t, err)
+	assert.Equal(t, local, deployed)
+
+	stale, err := ArchiveContentHash(zipArchive(t, map[string]string{"main.py": "print('old')\n", "requirements.txt": "flask\n"},
+		[]string{"main.py", "requirements.txt"}))
+	require.NoError(t, err)
+	assert.NotEqual(t, local, stale, "A changed file should change the hash")
+
+	_, err = SourceContentHash(dir, []string{"missing.py"})
+	assert.Error(t, err)
+	_, err = ArchiveContentHash([]byte("not a zip"))
+	assert.Error(t, err)
+}
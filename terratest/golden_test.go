@@ -0,0 +1,216 @@
+package test
+
+import (
+	"encoding/json"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	tfjson "github.com/hashicorp/terraform-json"
+	"github.com/stretchr/testify/assert"
+
+	"hello-world-test/gcptest"
+)
+
+// goldenPlanFile holds the normalized dev plan TestPlanMatchesGolden compares against.
+const goldenPlanFile = "testdata/dev.plan.golden.json"
+
+// goldenPlanAttributes are the planned attributes kept in the golden plan;
+// everything else is left out to keep it readable and stable.
+var goldenPlanAttributes = []string{
+	"name", "location", "region", "runtime", "entry_point", "available_memory_mb", "timeout",
+	"trigger_http", "protocol", "port_name", "port_range", "service", "role", "labels",
+}
+
+// goldenPlanResource is one resource change in the golden plan.
+type goldenPlanResource struct {
+	Address    string                 `json:"address"`
+	Type       string                 `json:"type"`
+	Actions    []string               `json:"actions"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// md5Pattern matches content hashes terraform bakes into generated names.
+var md5Pattern = regexp.MustCompile(`[0-9a-f]{32}`)
+
+// TestPlanMatchesGolden plans the dev environment into an empty workspace and
+// compares a normalized subset of the plan with testdata/dev.plan.golden.json,
+// so infrastructure changes show up as a golden-file diff in review. Run with
+// UPDATE_GOLDEN=1 to regenerate the file after an intended change.
+func TestPlanMatchesGolden(t *testing.T) {
+	projectID := gcptest.GetProjectID(t)
+
+	// Pin every input that ends up in the plan so it doesn't depend on who runs it
+	terraformOptions := buildOptions(t, devEnvironmentDir, map[string]interface{}{
+		"project_id":  projectID,
+		"region":      "us-central1",
+		"labels":      map[string]string{gcptest.LabelCreatedBy: "terratest", gcptest.LabelTestRunID: "golden"},
+		"name_suffix": "",
+	})
+
+	updating := os.Getenv("UPDATE_GOLDEN") == "1"
+	expected, err := os.ReadFile(goldenPlanFile)
+	if os.IsNotExist(err) && !updating {
+		t.Skipf("Skipping: %s does not exist, generate it with UPDATE_GOLDEN=1 and commit it", goldenPlanFile)
+	}
+	if err != nil && !updating {
+		t.Fatalf("Could not read %s: %v", goldenPlanFile, err)
+	}
+
+	if !initTerraform(t, terraformOptions) {
+		return
+	}
+	withWorkspace(t, terraformOptions, "terratest-golden")
+	defer deleteWorkspace(t, terraformOptions)
+
+	actual, err := json.MarshalIndent(normalizePlan(planAndShow(t, terraformOptions), projectID), "", "  ")
+	if err != nil {
+		t.Fatalf("Could not encode the normalized plan: %v", err)
+	}
+	actual = append(actual, '\n')
+
+	if updating {
+		if err := os.WriteFile(goldenPlanFile, actual, 0o644); err != nil {
+			t.Fatalf("Could not write %s: %v", goldenPlanFile, err)
+		}
+		t.Logf("Updated %s", goldenPlanFile)
+		return
+	}
+	assert.JSONEq(t, string(expected), string(actual),
+		"Plan differs from %s; if the change is intended, rerun with UPDATE_GOLDEN=1 and commit the result", goldenPlanFile)
+}
+
+// TestGoldenPlanAddresses keeps the golden plan and expectedManagedResources in
+// step, without needing Terraform.
+func TestGoldenPlanAddresses(t *testing.T) {
+	data, err := os.ReadFile(goldenPlanFile)
+	if err != nil {
+		t.Fatalf("Could not read %s: %v", goldenPlanFile, err)
+	}
+	var golden []goldenPlanResource
+	if err := json.Unmarshal(data, &golden); err != nil {
+		t.Fatalf("Could not parse %s: %v", goldenPlanFile, err)
+	}
+	var addresses []string
+	for _, resource := range golden {
+		addresses = append(addresses, resource.Address)
+	}
+	assert.Empty(t, addressProblems(addresses, expectedManagedResources),
+		"%s and expectedManagedResources should list the same resources", goldenPlanFile)
+}
+
+// normalizePlan reduces plan to its managed resource changes, sorted by
+// address, keeping only goldenPlanAttributes whose values are known at plan
+// time. The project ID and content hashes are replaced by placeholders.
+func normalizePlan(plan *terraform.PlanStruct, projectID string) []goldenPlanResource {
+	var resources []goldenPlanResource
+	for _, change := range plan.ResourceChangesMap {
+		if change.Mode != tfjson.ManagedResourceMode || change.Change == nil {
+			continue
+		}
+
+		resource := goldenPlanResource{
+			Address: change.Address,
+			Type:    change.Type,
+		}
+		for _, action := range change.Change.Actions {
+			resource.Actions = append(resource.Actions, string(action))
+		}
+
+		after, _ := change.Change.After.(map[string]interface{})
+		for _, key := range goldenPlanAttributes {
+			if value, ok := after[key]; ok && value != nil {
+				if resource.Attributes == nil {
+					resource.Attributes = map[string]interface{}{}
+				}
+				resource.Attributes[key] = normalizeValue(value, projectID)
+			}
+		}
+		resources = append(resources, resource)
+	}
+
+	sort.Slice(resources, func(i, j int) bool { return resources[i].Address < resources[j].Address })
+	return resources
+}
+
+// normalizeValue replaces volatile parts of string values, recursing into
+// maps and lists.
+func normalizeValue(value interface{}, projectID string) interface{} {
+	switch v := value.(type) {
+	case string:
+		if projectID != "" {
+			v = strings.ReplaceAll(v, projectID, "PROJECT_ID")
+		}
+		return md5Pattern.ReplaceAllString(v, "MD5")
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			out[key] = normalizeValue(item, projectID)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = normalizeValue(item, projectID)
+		}
+		return out
+	}
+	return value
+}
+
+func TestNormalizePlan(t *testing.T) {
+	plan := &terraform.PlanStruct{ResourceChangesMap: map[string]*tfjson.ResourceChange{
+		"module.cf.google_storage_bucket_object.function_source": {
+			Address: "module.cf.google_storage_bucket_object.function_source",
+			Mode:    tfjson.ManagedResourceMode,
+			Type:    "google_storage_bucket_object",
+			Change: &tfjson.Change{
+				Actions: tfjson.Actions{tfjson.ActionCreate},
+				After: map[string]interface{}{
+					"name":       "function-source-0123456789abcdef0123456789abcdef.zip",
+					"md5hash":    "volatile",
+					"bucket":     nil,
+					"location":   nil,
+					"labels":     map[string]interface{}{"owner": "my-project"},
+					"created_at": "2024-05-01T00:00:00Z",
+				},
+			},
+		},
+		"module.cf.google_storage_bucket.function_source": {
+			Address: "module.cf.google_storage_bucket.function_source",
+			Mode:    tfjson.ManagedResourceMode,
+			Type:    "google_storage_bucket",
+			Change: &tfjson.Change{
+				Actions: tfjson.Actions{tfjson.ActionCreate},
+				After:   map[string]interface{}{"name": "my-project-function-source-dev", "location": "US-CENTRAL1"},
+			},
+		},
+		"data.archive_file.function_source": {
+			Address: "data.archive_file.function_source",
+			Mode:    tfjson.DataResourceMode,
+			Type:    "archive_file",
+			Change:  &tfjson.Change{Actions: tfjson.Actions{tfjson.ActionRead}},
+		},
+	}}
+
+	assert.Equal(t, []goldenPlanResource{
+		{
+			Address:    "module.cf.google_storage_bucket.function_source",
+			Type:       "google_storage_bucket",
+			Actions:    []string{"create"},
+			Attributes: map[string]interface{}{"name": "PROJECT_ID-function-source-dev", "location": "US-CENTRAL1"},
+		},
+		{
+			Address: "module.cf.google_storage_bucket_object.function_source",
+			Type:    "google_storage_bucket_object",
+			Actions: []string{"create"},
+			Attributes: map[string]interface{}{
+				"name":   "function-source-MD5.zip",
+				"labels": map[string]interface{}{"owner": "PROJECT_ID"},
+			},
+		},
+	}, normalizePlan(plan, "my-project"))
+}
@@ -1,99 +1,396 @@
 package test
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
 	"strings"
+	"syscall"
 	"testing"
 	"time"
 
-	http_helper "github.com/gruntwork-io/terratest/modules/http-helper"
 	"github.com/gruntwork-io/terratest/modules/terraform"
 	"github.com/stretchr/testify/assert"
+
+	"hello-world-test/gcptest"
 )
 
-func TestHelloWorld(t *testing.T) {
-	// Get project ID - in real scenario this would be set via environment
-	projectID := "smt-the-dev-kevinloygtz-r4ch"
+// devEnvironmentDir is the Terraform root exercised by the default tests.
+const devEnvironmentDir = "../environments/dev"
 
-	terraformOptions := &terraform.Options{
-		TerraformDir: "../environments/dev",
-		Vars: map[string]interface{}{
-			"project_id": projectID,
-		},
-		// Disable locking to avoid issues in testing
-		NoColor: true,
-		Upgrade: false,
-	}
+func TestHelloWorld(t *testing.T) {
+	skipIfOffline(t)
+	projectID := gcptest.GetProjectID(t)
 
-	// Clean up resources on test completion
-	defer terraform.Destroy(t, terraformOptions)
+	terraformOptions := buildOptions(t, devEnvironmentDir, map[string]interface{}{
+		"project_id": projectID,
+	})
 
-	// Run terraform init and apply
-	// Note: This will fail if APIs are not enabled or billing is not configured
-	// The test serves to validate the terraform configuration syntax and dependencies
-	_, err := terraform.InitE(t, terraformOptions)
-	if err != nil {
-		t.Logf("Terraform init failed (expected if APIs not enabled): %v", err)
+	if os.Getenv("DRY_RUN") == "1" {
+		dryRun(t, terraformOptions)
 		return
 	}
 
-	_, err = terraform.ApplyE(t, terraformOptions)
-	if err != nil {
-		if strings.Contains(err.Error(), "billing") {
-			t.Skipf("Skipping test due to billing account issue: %v", err)
-			return
-		}
-		if strings.Contains(err.Error(), "API") && strings.Contains(err.Error(), "not been used") {
-			t.Skipf("Skipping test due to API not enabled: %v", err)
+	deployedAt := time.Now()
+	if os.Getenv("SKIP_DEPLOY") == "1" {
+		// Another pipeline owns the stack's lifecycle, so only validate it
+		requireExistingDeployment(t, terraformOptions)
+	} else {
+		// Clean up resources on test completion
+		defer destroyAndVerify(t, terraformOptions)
+
+		if !initAndApply(t, terraformOptions) {
 			return
 		}
-		t.Fatalf("Terraform apply failed: %v", err)
-	}
-
-	// Get the function URL from terraform output
-	functionURL := terraform.Output(t, terraformOptions, "function_url")
-	assert.NotEmpty(t, functionURL, "Function URL should not be empty")
-
-	// Test the function endpoint
-	expectedText := "Hello"
-	maxRetries := 5
-	sleepBetweenRetries := 10 * time.Second
-
-	http_helper.HttpGetWithRetryWithCustomValidation(
-		t,
-		functionURL,
-		nil, // no custom headers
-		maxRetries,
-		sleepBetweenRetries,
-		func(statusCode int, body string) bool {
-			return statusCode == 200 && strings.Contains(body, expectedText)
-		},
-	)
+	}
+
+	// Make sure the checks below hit the code that was just applied
+	functionName := getRequiredOutput(t, terraformOptions, "function_name")
+	state := readState(t, terraformOptions)
+	waitForRevision(t, projectID, functionName, deployedRevision(t, state), revisionTimeout)
+	assertResourceConventionsFromEnv(t, state)
+
+	serviceAccountEmail := getRequiredOutput(t, terraformOptions, "service_account_email")
+	gcptest.AssertServiceAccountRoles(t, projectID, serviceAccountEmail, forbiddenServiceAccountRoles)
+
+	// An event-driven function has no URL; check it processes a message instead
+	switch trigger := getTriggerType(t, state); trigger {
+	case httpTrigger:
+	case pubSubTrigger:
+		_, topic, _ := functionTrigger(state)
+		publishAndAssertProcessed(t, projectID, functionName, topic)
+		return
+	default:
+		t.Skipf("Skipping HTTP checks: %s is triggered by %s events, which have no test yet", functionName, trigger)
+	}
+
+	// Tag the requests so the log check below finds this run's entries only
+	traceID := newTraceID()
+	t.Logf("Sending requests with trace ID %s", traceID)
+	functionURL := checkFunctionRequest(t, terraformOptions, devEnvironment, functionRequest{headers: traceHeaders(traceID)})
+	assertHealthEndpoint(t, functionURL, traceHeaders(traceID))
+	gcptest.AssertResponseTimeUnder(t, functionURL, responseSLA(t, devEnvironment.name), traceHeaders(traceID))
+	assertLatencyPercentiles(t, functionURL)
+	assertValidTLS(t, functionURL)
+	// A subtest so a host without IPv6 skips only this check
+	t.Run("IPv6", func(t *testing.T) { assertIPv6Reachable(t, functionURL) })
+	gcptest.AssertGzipSupported(t, functionURL, "Hello")
+
+	// The function URL may only speak HTTP/1.1; record what it negotiates
+	protocol, err := gcptest.NegotiatedProtocol(functionURL)
+	if assert.NoError(t, err, "Function URL should answer") {
+		t.Logf("Function URL negotiated %s", protocol)
+	}
+
+	gcptest.AssertFunctionLogged(t, projectID, functionName, traceID, deployedAt, functionExecutionLogLine)
+	gcptest.AssertInvocationCount(t, projectID, functionName, 1, time.Since(deployedAt))
 
 	// Get the load balancer URL if available
-	loadBalancerURL := terraform.Output(t, terraformOptions, "load_balancer_url")
+	loadBalancerURL := getOutputs(t, terraformOptions, "load_balancer_url")["load_balancer_url"]
 	if loadBalancerURL != "" {
 		t.Logf("Load balancer URL: %s", loadBalancerURL)
+		parsedLoadBalancerURL := assertValidURL(t, loadBalancerURL)
+		// Requests 502 until the backends are healthy, so wait on the
+		// backend service before looking at the URL itself
+		backendServiceName := getRequiredOutput(t, terraformOptions, "backend_service_name")
+		err := gcptest.WaitForBackendHealthy(t, projectID, backendServiceName, loadBalancerReadyTimeout)
+		assert.NoError(t, err, "Load balancer backends should become healthy")
+
+		// A custom domain in front of the load balancer won't answer until DNS propagates
+		if host := parsedLoadBalancerURL.Hostname(); net.ParseIP(host) == nil {
+			_, err := gcptest.WaitForDNS(t, host, dnsPropagationTimeout)
+			assert.NoError(t, err, "Load balancer hostname should resolve")
+		}
+
 		// Note: Load balancer might take time to provision and become healthy
+		err = gcptest.WaitForHealthy(t, healthEndpoint(loadBalancerURL), loadBalancerReadyTimeout)
+		assert.NoError(t, err, "Load balancer should become healthy")
+
+		// The HTTPS frontend needs a real domain for its managed certificate, so
+		// the default output is plain HTTP and there is no TLS or ALPN to inspect
+		if strings.HasPrefix(loadBalancerURL, "https://") {
+			assertValidTLS(t, loadBalancerURL)
+			gcptest.AssertHTTP2(t, loadBalancerURL)
+		} else {
+			t.Logf("Load balancer is served over plain HTTP, skipping TLS and HTTP/2 checks for %s", loadBalancerURL)
+		}
+	}
+}
+
+// ipv6Unavailable are the dial errors of a machine without working IPv6,
+// as opposed to a server that doesn't answer over it.
+var ipv6Unavailable = []error{syscall.ENETUNREACH, syscall.EHOSTUNREACH, syscall.EADDRNOTAVAIL, syscall.EAFNOSUPPORT}
+
+// assertIPv6Reachable GETs rawURL over a tcp6 connection and expects a 200.
+// It skips the test when the host has no AAAA record or the local machine
+// can't reach IPv6 at all, and logs which of the two happened, so a passing
+// run always means IPv6 was really exercised.
+func assertIPv6Reachable(t *testing.T, rawURL string) {
+	t.Helper()
+
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatalf("Could not parse URL %q: %v", rawURL, err)
+	}
+	host := parsed.Hostname()
+
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.To4() != nil {
+			t.Skipf("IPv6 not tested: %s is an IPv4 address", host)
+		}
+	} else if addrs, err := net.DefaultResolver.LookupIP(context.Background(), "ip6", host); err != nil || len(addrs) == 0 {
+		t.Skipf("IPv6 not tested: %s has no AAAA record (%v)", host, err)
+	}
+
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, addr string) (net.Conn, error) {
+				return dialer.DialContext(ctx, "tcp6", addr)
+			},
+		},
+	}
+	resp, err := client.Get(rawURL)
+	if err != nil {
+		for _, unavailable := range ipv6Unavailable {
+			if errors.Is(err, unavailable) {
+				t.Skipf("IPv6 not tested: this machine can't reach %s over IPv6: %v", host, err)
+			}
+		}
+		t.Fatalf("GET %s over IPv6 failed: %v", rawURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 from %s over IPv6, got %d", rawURL, resp.StatusCode)
+	}
+	t.Logf("IPv6 tested: %s answered 200 over IPv6", rawURL)
+}
+
+func TestAssertIPv6Reachable(t *testing.T) {
+	listener, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("Skipping: no IPv6 loopback here: %v", err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	assertIPv6Reachable(t, server.URL)
+
+	var skipped bool
+	t.Run("IPv4Only", func(t *testing.T) {
+		defer func() { skipped = t.Skipped() }()
+		assertIPv6Reachable(t, "http://127.0.0.1/")
+	})
+	assert.True(t, skipped, "An IPv4 address should skip the check")
+}
+
+// assertValidTLS connects to the host behind rawURL and checks that it
+// negotiates at least TLS 1.2 with a certificate chain trusted by the system
+// roots whose SAN covers the host.
+func assertValidTLS(t *testing.T, rawURL string) {
+	t.Helper()
+
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatalf("Could not parse URL %q: %v", rawURL, err)
+	}
+	if parsed.Scheme != "https" {
+		t.Fatalf("%s is not served over HTTPS", rawURL)
+	}
+
+	host := parsed.Hostname()
+	port := parsed.Port()
+	if port == "" {
+		port = "443"
+	}
+
+	// Verification is done by hand below so an expired certificate can be reported with its dates
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	conn, err := tls.DialWithDialer(dialer, "tcp", net.JoinHostPort(host, port), &tls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		t.Fatalf("TLS handshake with %s failed: %v", host, err)
+	}
+	defer conn.Close()
+
+	state := conn.ConnectionState()
+	assert.GreaterOrEqual(t, state.Version, uint16(tls.VersionTLS12),
+		"%s negotiated %s, expected at least TLS 1.2", host, tls.VersionName(state.Version))
+
+	if len(state.PeerCertificates) == 0 {
+		t.Fatalf("%s presented no certificates", host)
+	}
+	leaf := state.PeerCertificates[0]
+	testReportMeasurements.recordCertificate(host, leaf.NotAfter)
+
+	if now := time.Now(); now.After(leaf.NotAfter) {
+		t.Errorf("Certificate for %s expired on %s", host, leaf.NotAfter.Format(time.RFC3339))
+	}
+
+	assert.NoError(t, leaf.VerifyHostname(host), "Certificate SAN should match %s", host)
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err = leaf.Verify(x509.VerifyOptions{
+		DNSName:       host,
+		Intermediates: intermediates,
+	})
+	assert.NoError(t, err, "Certificate chain for %s should verify against system roots", host)
+}
+
+// dryRun runs init, validate and plan without touching any infrastructure,
+// for fast local feedback when DRY_RUN=1.
+func dryRun(t *testing.T, terraformOptions *terraform.Options) {
+	t.Helper()
+
+	t.Log("DRY_RUN=1: running init, validate and plan only")
+	if err := initWithRetry(t, terraformOptions, defaultInitAttempts); err != nil {
+		t.Fatalf("Dry run init failed: %v", err)
+	}
+	validateTerraform(t, terraformOptions)
+
+	_, err := terraform.PlanE(t, terraformOptions)
+	assert.NoError(t, err, "Dry run plan should succeed")
+
+	t.Log("Dry run complete: apply and destroy were not run")
+}
+
+// validateTerraform runs terraform validate on terraformOptions' directory.
+// terraform validate doesn't accept -var flags, so it runs with a copy that
+// has no vars.
+func validateTerraform(t *testing.T, terraformOptions *terraform.Options) {
+	t.Helper()
+
+	validateOptions, err := terraformOptions.Clone()
+	if err != nil {
+		t.Fatalf("Could not copy terraform options: %v", err)
+	}
+	validateOptions.Vars = nil
+	validateOptions.VarFiles = nil
+	terraform.Validate(t, validateOptions)
+}
+
+// requireExistingDeployment fails the test unless terraformOptions points at
+// an initialized state that already holds resources, for SKIP_DEPLOY=1 runs
+// that test a live stack without applying or destroying it.
+func requireExistingDeployment(t *testing.T, terraformOptions *terraform.Options) {
+	t.Helper()
+
+	t.Log("SKIP_DEPLOY=1: testing the existing deployment, apply and destroy will not run")
+	useEnvWorkspace(t, terraformOptions)
+	out, err := terraform.RunTerraformCommandAndGetStdoutE(t, terraformOptions, "state", "list")
+	if err != nil {
+		t.Fatalf("SKIP_DEPLOY=1 but the state in %s could not be read (is it initialized?): %v",
+			terraformOptions.TerraformDir, err)
+	}
+	if len(parseStateList(out)) == 0 {
+		t.Fatalf("SKIP_DEPLOY=1 but the state in %s is empty, deploy the stack first", terraformOptions.TerraformDir)
+	}
+}
+
+// defaultLatencySamples is how many requests measureLatencyPercentiles times
+// unless LATENCY_SAMPLES says otherwise.
+const defaultLatencySamples = 50
+
+// functionExecutionLogLine is written by the Cloud Functions runtime for
+// every invocation, so it shows up once checkFunctionURL has hit the function.
+const functionExecutionLogLine = "Function execution started"
+
+// forbiddenServiceAccountRoles must never be granted to the function's service account.
+var forbiddenServiceAccountRoles = []string{"roles/owner", "roles/editor"}
+
+// loadBalancerReadyTimeout bounds how long we wait for a new load balancer;
+// provisioning usually takes 10-15 minutes.
+const loadBalancerReadyTimeout = 20 * time.Minute
+
+// dnsPropagationTimeout bounds how long we wait for a custom load balancer
+// hostname to resolve.
+const dnsPropagationTimeout = 10 * time.Minute
+
+// testEnvironment describes a Terraform root and what its function returns.
+// What else is expected of it comes from thresholdsFor its name.
+type testEnvironment struct {
+	name           string
+	dir            string
+	responseFormat gcptest.ResponseFormat
+}
+
+// devEnvironment is the environment used by the single-environment tests.
+var devEnvironment = testEnvironment{name: "dev", dir: devEnvironmentDir, responseFormat: gcptest.ResponseFormatText}
+
+// testEnvironments lists the Terraform roots exercised by TestAllEnvironments.
+var testEnvironments = []testEnvironment{
+	devEnvironment,
+	{name: "test", dir: "../environments/test", responseFormat: gcptest.ResponseFormatText},
+	{name: "prd", dir: "../environments/prd", responseFormat: gcptest.ResponseFormatText},
+}
+
+func TestAllEnvironments(t *testing.T) {
+	// Skip once up front instead of in every subtest
+	gcptest.GetProjectID(t)
+
+	for _, env := range testEnvironments {
+		t.Run(env.name, func(t *testing.T) {
+			// Safe in one project as every resource name includes the
+			// environment, see TestResourceNamesIncludeEnvironment
+			t.Parallel()
+
+			if _, err := os.Stat(env.dir); os.IsNotExist(err) {
+				t.Skipf("Skipping environment %s: directory %s does not exist", env.name, env.dir)
+			}
+
+			terraformOptions := buildOptions(t, env.dir, loadEnvVars(t, env.name))
+
+			if !initTerraform(t, terraformOptions) {
+				return
+			}
+
+			// Apply into a dedicated workspace so concurrent runs never share a state file.
+			// Destroy is deferred only once the workspace is pinned, so it can't hit default.
+			withWorkspace(t, terraformOptions, "terratest-"+env.name)
+
+			// Each environment is torn down on its own so one failure doesn't leak into the others
+			defer destroyAndVerify(t, terraformOptions)
+
+			applyTerraform(t, terraformOptions)
+			checkFunctionURL(t, terraformOptions, env)
+			th := thresholdsFor(env.name)
+			assertInstanceScaling(t, readState(t, terraformOptions), th.minInstances, th.maxInstances)
+		})
 	}
 }
 
 func TestTerraformValidation(t *testing.T) {
 	// This test validates the Terraform configuration without applying it
-	terraformOptions := &terraform.Options{
-		TerraformDir: "../environments/dev",
-		// Note: terraform validate doesn't accept -var flags
-		// It only validates syntax and configuration structure
-	}
+	// It only validates syntax and configuration structure
+	terraformOptions := buildOptions(t, devEnvironmentDir, nil)
+
+	// Skip the GCS backend so this also runs without GCP access (OFFLINE=1)
+	terraform.RunTerraformCommand(t, terraformOptions, "init", "-backend=false", "-input=false")
 
 	// Test that terraform validate passes
-	terraform.Validate(t, terraformOptions)
+	validateTerraform(t, terraformOptions)
 }
 
 func TestHelloWorldFunctionUnit(t *testing.T) {
 	// This is a unit test that doesn't require GCP resources
 	expectedMessage := "Hello World from GCP!"
 	actualMessage := fmt.Sprintf("Hello World from %s!", "GCP")
-	
+
 	assert.Equal(t, expectedMessage, actualMessage, "Function should return correct message")
-} 
\ No newline at end of file
+}
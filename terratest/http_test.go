@@ -0,0 +1,563 @@
+package test
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	http_helper "github.com/gruntwork-io/terratest/modules/http-helper"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+
+	"hello-world-test/gcptest"
+)
+
+// maxResponseBytes caps the greeting's body size; anything bigger means the
+// function is echoing or generating far more than it should.
+const maxResponseBytes = 1 << 20
+
+// functionRequest customizes the request checkFunctionRequest sends. Zero
+// values fall back to a plain GET expecting the standard greeting and
+// defaultSecurityHeaders.
+type functionRequest struct {
+	headers         map[string]string
+	query           url.Values
+	expectedText    string
+	securityHeaders map[string]string
+}
+
+// defaultSecurityHeaders are the response headers every function response
+// must carry, see assertSecurityHeaders.
+var defaultSecurityHeaders = map[string]string{
+	"X-Content-Type-Options":    "nosniff",
+	"Strict-Transport-Security": "max-age=31536000; includeSubDomains",
+}
+
+// traceContextHeader carries a request's trace ID into Google's front end,
+// which attaches it to the log entries written while serving the request.
+const traceContextHeader = "X-Cloud-Trace-Context"
+
+// newTraceID returns a random trace ID in the format X-Cloud-Trace-Context
+// expects: 32 lowercase hex characters, never all zeros.
+func newTraceID() string {
+	b := make([]byte, 16)
+	for {
+		if _, err := rand.Read(b); err != nil {
+			panic(fmt.Sprintf("reading random bytes: %v", err))
+		}
+		for _, c := range b {
+			if c != 0 {
+				return hex.EncodeToString(b)
+			}
+		}
+	}
+}
+
+// traceHeaders returns the request headers that tag a request with traceID.
+// The span ID is arbitrary, and o=1 asks for the trace to be recorded.
+func traceHeaders(traceID string) map[string]string {
+	return map[string]string{traceContextHeader: traceID + "/1;o=1"}
+}
+
+func TestNewTraceID(t *testing.T) {
+	traceID := newTraceID()
+	assert.Regexp(t, `^[0-9a-f]{32}$`, traceID)
+	assert.NotEqual(t, strings.Repeat("0", 32), traceID)
+	assert.NotEqual(t, traceID, newTraceID(), "Every request should get its own trace")
+	assert.Equal(t, map[string]string{"X-Cloud-Trace-Context": traceID + "/1;o=1"}, traceHeaders(traceID))
+}
+
+// checkFunctionURL reads the function_url output, waits until the function
+// answers with the expected greeting and validates the response body in the
+// environment's format. It returns the URL for further checks.
+func checkFunctionURL(t *testing.T, terraformOptions *terraform.Options, env testEnvironment) string {
+	t.Helper()
+
+	return checkFunctionRequest(t, terraformOptions, env, functionRequest{})
+}
+
+// checkFunctionRequest is checkFunctionURL with extra headers, query
+// parameters, expected body text and required security headers taken from
+// req. It returns the function URL without the query string.
+func checkFunctionRequest(t *testing.T, terraformOptions *terraform.Options, env testEnvironment, req functionRequest) string {
+	t.Helper()
+
+	// Get the function URL from terraform output
+	functionURL := getRequiredOutput(t, terraformOptions, "function_url")
+	assertValidURL(t, functionURL)
+	requestURL := withQuery(t, functionURL, req.query)
+
+	headers := map[string]string{}
+	if thresholdsFor(env.name).requireAuth {
+		headers = authHeaders(t, functionURL)
+	}
+	for name, value := range req.headers {
+		headers[name] = value
+	}
+
+	// Test the function endpoint
+	expectedText := req.expectedText
+	if expectedText == "" {
+		expectedText = "Hello"
+	}
+
+	// Poll the health endpoint until the function is ready so the retries
+	// don't show up in the main path's request metrics
+	healthURL := healthEndpoint(functionURL)
+	err := httpGetUntil(t, healthURL, headers,
+		func(statusCode int, body string) bool {
+			return statusCode == 200 && strings.TrimSpace(body) == healthyBody
+		},
+		misconfiguredStatus,
+	)
+	if err != nil {
+		t.Fatalf("Function never became healthy: %v", err)
+	}
+
+	err = http_helper.HTTPDoWithCustomValidationE(
+		t,
+		http.MethodGet,
+		requestURL,
+		nil,
+		headers,
+		func(statusCode int, body string) bool {
+			return statusCode == 200 && strings.Contains(body, expectedText)
+		},
+		nil, // default TLS config
+	)
+	if err != nil {
+		t.Fatalf("Function did not return the expected response: %v", err)
+	}
+
+	gcptest.AssertResponseSizeUnder(t, requestURL, maxResponseBytes, headers)
+	gcptest.AssertFunctionResponse(t, requestURL, env.responseFormat, headers)
+	if contentType := gcptest.ContentTypeFor(env.responseFormat); contentType != "" {
+		gcptest.AssertContentType(t, requestURL, contentType, headers)
+	}
+
+	securityHeaders := req.securityHeaders
+	if securityHeaders == nil {
+		securityHeaders = defaultSecurityHeaders
+	}
+	assertSecurityHeaders(t, requestURL, securityHeaders, headers)
+
+	return functionURL
+}
+
+const (
+	// healthPath is the function's readiness endpoint, which skips the
+	// greeting logic
+	healthPath = "/healthz"
+	// healthyBody is all the health endpoint answers with
+	healthyBody = "ok"
+	// healthLatencySamples is how many requests assertHealthEndpoint times
+	// on each path before comparing their medians
+	healthLatencySamples = 5
+	// healthLatencyRatio and healthLatencySlack absorb network jitter when
+	// comparing the two paths, whose medians are close for a function this
+	// small: the health endpoint is only too slow when its median is over
+	// healthLatencyRatio times the main endpoint's plus healthLatencySlack
+	healthLatencyRatio = 1.5
+	healthLatencySlack = 100 * time.Millisecond
+)
+
+// healthEndpoint returns the health endpoint under baseURL.
+func healthEndpoint(baseURL string) string {
+	return strings.TrimSuffix(baseURL, "/") + healthPath
+}
+
+// assertHealthEndpoint GETs the health endpoint under baseURL with headers
+// and expects a 200 with a body of "ok". Since it does no real work it must
+// also answer about as fast as baseURL itself or faster, see
+// healthLatencyTooSlow, compared by the median of healthLatencySamples warm
+// requests to each.
+func assertHealthEndpoint(t testing.TB, baseURL string, headers map[string]string) {
+	t.Helper()
+
+	healthURL := healthEndpoint(baseURL)
+	statusCode, body := http_helper.HTTPDo(t, http.MethodGet, healthURL, nil, headers, nil)
+	if statusCode != http.StatusOK || strings.TrimSpace(body) != healthyBody {
+		t.Fatalf("Expected %s to answer 200 %q, got %d %q", healthURL, healthyBody, statusCode, body)
+	}
+
+	healthLatency, err := medianLatency(healthURL, headers, healthLatencySamples)
+	if err != nil {
+		t.Fatalf("Could not time %s: %v", healthURL, err)
+	}
+	mainLatency, err := medianLatency(baseURL, headers, healthLatencySamples)
+	if err != nil {
+		t.Fatalf("Could not time %s: %v", baseURL, err)
+	}
+	if healthLatencyTooSlow(healthLatency, mainLatency) {
+		t.Errorf("Health endpoint took %s, expected it to be about as fast as the main endpoint's %s or faster", healthLatency, mainLatency)
+		return
+	}
+	t.Logf("Health endpoint took %s, the main endpoint %s", healthLatency, mainLatency)
+}
+
+// healthLatencyTooSlow reports whether the health endpoint's median latency
+// is beyond what jitter explains given the main endpoint's.
+func healthLatencyTooSlow(health, main time.Duration) bool {
+	return health > time.Duration(float64(main)*healthLatencyRatio)+healthLatencySlack
+}
+
+func TestHealthLatencyTooSlow(t *testing.T) {
+	assert.False(t, healthLatencyTooSlow(40*time.Millisecond, 60*time.Millisecond))
+	assert.False(t, healthLatencyTooSlow(80*time.Millisecond, 60*time.Millisecond), "A few tens of ms of jitter is expected")
+	assert.False(t, healthLatencyTooSlow(1200*time.Millisecond, 800*time.Millisecond), "Tolerance grows with the latency")
+	assert.True(t, healthLatencyTooSlow(400*time.Millisecond, 60*time.Millisecond))
+	assert.True(t, healthLatencyTooSlow(2*time.Second, 800*time.Millisecond))
+}
+
+// medianLatency times samples GETs of url with headers, after one priming
+// request, and returns the median.
+func medianLatency(url string, headers map[string]string, samples int) (time.Duration, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	latencies := make([]time.Duration, 0, samples)
+	for i := 0; i <= samples; i++ {
+		start := time.Now()
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0, err
+		}
+		_, err = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return 0, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return 0, fmt.Errorf("unexpected status %d", resp.StatusCode)
+		}
+		// The first request only warms the instance and connection
+		if i > 0 {
+			latencies = append(latencies, time.Since(start))
+		}
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	return latencies[len(latencies)/2], nil
+}
+
+// assertSecurityHeaders GETs url with requestHeaders and fails the test,
+// listing every discrepancy at once, if the response lacks any header in
+// required or carries a different value. An empty required value only checks
+// that the header is present.
+func assertSecurityHeaders(t testing.TB, url string, required, requestHeaders map[string]string) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatalf("Could not build request for %s: %v", url, err)
+	}
+	for name, value := range requestHeaders {
+		req.Header.Set(name, value)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("GET %s failed: %v", url, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if problems := securityHeaderProblems(resp.Header, required); len(problems) > 0 {
+		t.Errorf("Response from %s has bad security headers:\n  %s", url, strings.Join(problems, "\n  "))
+	}
+}
+
+// securityHeaderProblems describes each header in required that header lacks
+// or holds a different value for, sorted by header name.
+func securityHeaderProblems(header http.Header, required map[string]string) []string {
+	names := make([]string, 0, len(required))
+	for name := range required {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var problems []string
+	for _, name := range names {
+		expected := required[name]
+		values, ok := header[http.CanonicalHeaderKey(name)]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("%s is missing", name))
+		case expected != "" && strings.Join(values, ", ") != expected:
+			problems = append(problems, fmt.Sprintf("%s is %q, want %q", name, strings.Join(values, ", "), expected))
+		}
+	}
+	return problems
+}
+
+func TestSecurityHeaderProblems(t *testing.T) {
+	header := http.Header{}
+	header.Set("X-Content-Type-Options", "sniff")
+	header.Set("X-Frame-Options", "DENY")
+
+	assert.Empty(t, securityHeaderProblems(header, map[string]string{"x-frame-options": "DENY"}))
+	assert.Empty(t, securityHeaderProblems(header, map[string]string{"X-Frame-Options": ""}))
+	assert.Equal(t, []string{
+		"Strict-Transport-Security is missing",
+		`X-Content-Type-Options is "sniff", want "nosniff"`,
+	}, securityHeaderProblems(header, defaultSecurityHeaders))
+}
+
+// withQuery returns rawURL with query merged into its existing parameters.
+func withQuery(t testing.TB, rawURL string, query url.Values) string {
+	t.Helper()
+
+	if len(query) == 0 {
+		return rawURL
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatalf("Could not parse URL %q: %v", rawURL, err)
+	}
+	values := parsed.Query()
+	for key, vals := range query {
+		values[key] = append(values[key], vals...)
+	}
+	parsed.RawQuery = values.Encode()
+	return parsed.String()
+}
+
+func TestWithQuery(t *testing.T) {
+	assert.Equal(t, "https://example.com/fn", withQuery(t, "https://example.com/fn", nil))
+	assert.Equal(t, "https://example.com/fn?a=1&b=2&b=3",
+		withQuery(t, "https://example.com/fn?b=2", url.Values{"a": {"1"}, "b": {"3"}}))
+}
+
+// httpRetryConfig bounds how long HTTP checks keep retrying. Polling stops
+// at whichever limit is reached first: MaxRetries retries after the first
+// attempt, or MaxTotalWait of wall-clock time including the requests.
+// SleepBetween is the base delay of the retries, not a cap: retry n sleeps
+// a random duration between SleepBetween and SleepBetween * 2^(n-1), the
+// latter capped at gcptest.BackoffCap (see retrySleep).
+type httpRetryConfig struct {
+	MaxRetries   int
+	SleepBetween time.Duration
+	MaxTotalWait time.Duration
+}
+
+// Defaults for loadHTTPRetryConfig. Load balancers can take 10-15 minutes to
+// serve traffic, so the total budget is deliberately generous.
+const (
+	defaultHTTPMaxRetries    = 60
+	defaultHTTPRetryInterval = 10 * time.Second
+	defaultHTTPMaxTotalWait  = 15 * time.Minute
+)
+
+// loadHTTPRetryConfig reads HTTP_MAX_RETRIES (a count), HTTP_RETRY_INTERVAL
+// and HTTP_MAX_TOTAL_WAIT (Go durations such as "10s" or "5m").
+// HTTP_RETRY_INTERVAL is the base, and shortest, sleep between retries; see
+// retrySleep.
+func loadHTTPRetryConfig(t *testing.T) httpRetryConfig {
+	t.Helper()
+
+	return httpRetryConfig{
+		MaxRetries:   envInt(t, "HTTP_MAX_RETRIES", defaultHTTPMaxRetries),
+		SleepBetween: envDuration(t, "HTTP_RETRY_INTERVAL", defaultHTTPRetryInterval),
+		MaxTotalWait: envDuration(t, "HTTP_MAX_TOTAL_WAIT", defaultHTTPMaxTotalWait),
+	}
+}
+
+// permanentError wraps an error that retrying won't fix, such as a 404 from
+// a misconfigured URL, so httpRetryConfig.do gives up on it immediately.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+
+func (e *permanentError) Unwrap() error { return e.err }
+
+// do calls fn until it succeeds or a limit is hit, and returns an error
+// naming the exhausted limit along with fn's last error. An error wrapped in
+// a permanentError is returned right away, without retrying.
+func (c httpRetryConfig) do(t *testing.T, description string, fn func() error) error {
+	t.Helper()
+
+	start := time.Now()
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			return fmt.Errorf("%s: not retrying: %w", description, permanent.err)
+		}
+
+		if attempt >= c.MaxRetries {
+			return fmt.Errorf("%s: gave up after %d retries (HTTP_MAX_RETRIES): %w", description, c.MaxRetries, err)
+		}
+		sleep := c.retrySleep(attempt + 1)
+		if elapsed := time.Since(start); elapsed+sleep > c.MaxTotalWait {
+			return fmt.Errorf("%s: gave up after %s, total wait budget of %s exhausted (HTTP_MAX_TOTAL_WAIT): %w",
+				description, elapsed.Round(time.Second), c.MaxTotalWait, err)
+		}
+
+		t.Logf("%s returned error: %v. Sleeping for %s and will try again.", description, err, sleep)
+		time.Sleep(sleep)
+	}
+}
+
+// retrySleep is how long do sleeps before retry attempt (counting from 1):
+// gcptest.BackoffFrom with SleepBetween as the base interval, so no retry
+// comes sooner than HTTP_RETRY_INTERVAL and later retries of parallel tests
+// spread out.
+func (c httpRetryConfig) retrySleep(attempt int) time.Duration {
+	return gcptest.BackoffFrom(c.SleepBetween, attempt)
+}
+
+func TestHTTPRetrySleep(t *testing.T) {
+	c := httpRetryConfig{SleepBetween: 10 * time.Second}
+	assert.Equal(t, c.SleepBetween, c.retrySleep(1), "HTTP_RETRY_INTERVAL is the base interval, not a cap")
+	for attempt := 2; attempt <= 10; attempt++ {
+		sleep := c.retrySleep(attempt)
+		assert.GreaterOrEqual(t, sleep, c.SleepBetween)
+		assert.LessOrEqual(t, sleep, gcptest.BackoffCap)
+	}
+}
+
+func TestHTTPRetryConfigLimits(t *testing.T) {
+	failing := func() error { return errors.New("503 Service Unavailable") }
+
+	err := httpRetryConfig{MaxRetries: 2, SleepBetween: time.Millisecond, MaxTotalWait: time.Minute}.do(t, "retries", failing)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "HTTP_MAX_RETRIES")
+		assert.Contains(t, err.Error(), "503")
+	}
+
+	err = httpRetryConfig{MaxRetries: 100, SleepBetween: 10 * time.Millisecond, MaxTotalWait: 25 * time.Millisecond}.do(t, "budget", failing)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "HTTP_MAX_TOTAL_WAIT")
+	}
+
+	calls := 0
+	err = httpRetryConfig{MaxRetries: 5, SleepBetween: time.Millisecond, MaxTotalWait: time.Minute}.do(t, "eventually", func() error {
+		calls++
+		if calls < 3 {
+			return errors.New("not yet")
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+}
+
+// misconfiguredStatus reports whether statusCode means the request itself is
+// wrong, e.g. a bad URL or missing credentials, which no amount of waiting on
+// provisioning fixes. Other failures, like the 502 and 503 a function or load
+// balancer answers with while it comes up, are worth retrying.
+func misconfiguredStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+		return true
+	}
+	return false
+}
+
+// httpGetUntil GETs url with headers, retrying within loadHTTPRetryConfig's
+// limits, until want accepts the response. A status stopOn reports true for
+// fails right away instead of using up the retry budget; a nil stopOn
+// retries every failure.
+func httpGetUntil(t *testing.T, url string, headers map[string]string, want func(int, string) bool, stopOn func(int) bool) error {
+	t.Helper()
+
+	return loadHTTPRetryConfig(t).do(t, fmt.Sprintf("GET %s", url), func() error {
+		statusCode, body, err := http_helper.HTTPDoE(t, http.MethodGet, url, nil, headers, nil)
+		if err != nil {
+			return err
+		}
+		if want(statusCode, body) {
+			return nil
+		}
+		err = fmt.Errorf("unexpected response %d: %.200s", statusCode, strings.TrimSpace(body))
+		if stopOn != nil && stopOn(statusCode) {
+			return &permanentError{err: err}
+		}
+		return err
+	})
+}
+
+func TestHTTPGetUntil(t *testing.T) {
+	t.Setenv("HTTP_MAX_RETRIES", "5")
+	t.Setenv("HTTP_RETRY_INTERVAL", "1ms")
+
+	var calls atomic.Int32
+	provisioning := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, healthyBody)
+	}))
+	defer provisioning.Close()
+	healthy := func(statusCode int, body string) bool { return statusCode == 200 && body == healthyBody }
+
+	assert.NoError(t, httpGetUntil(t, provisioning.URL, nil, healthy, misconfiguredStatus), "503s should be retried")
+	assert.EqualValues(t, 3, calls.Load())
+
+	calls.Store(0)
+	missing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.NotFound(w, r)
+	}))
+	defer missing.Close()
+	err := httpGetUntil(t, missing.URL, nil, healthy, misconfiguredStatus)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "not retrying")
+		assert.Contains(t, err.Error(), "404")
+	}
+	assert.EqualValues(t, 1, calls.Load(), "A 404 should fail on the first attempt")
+
+	calls.Store(0)
+	assert.Error(t, httpGetUntil(t, missing.URL, nil, healthy, nil))
+	assert.EqualValues(t, 6, calls.Load(), "Without stopOn every failure is retried")
+
+	assert.True(t, misconfiguredStatus(http.StatusForbidden))
+	assert.False(t, misconfiguredStatus(http.StatusBadGateway))
+}
+
+// httpRequest issues an arbitrary HTTP request and returns the status code and
+// body, failing the test only on transport errors.
+func httpRequest(t *testing.T, method, url string, body io.Reader) (int, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatalf("Could not build %s request for %s: %v", method, url, err)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Could not read response body from %s %s: %v", method, url, err)
+	}
+	return resp.StatusCode, strings.TrimSpace(string(respBody))
+}
@@ -0,0 +1,183 @@
+package test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// idempotentURLEnvVar points TestIdempotentEndpoint at an endpoint with a
+// side effect that honours Idempotency-Key.
+const idempotentURLEnvVar = "IDEMPOTENT_URL"
+
+// idempotencyKeyHeader carries the key under which an endpoint applies a
+// request's side effect at most once.
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// idempotentResponse is one answer assertIdempotent got.
+type idempotentResponse struct {
+	StatusCode int
+	Body       string
+}
+
+// idempotencyVerifier returns why second, the answer to a repeated request,
+// shows the side effect of first was applied again, or nil when it wasn't.
+// Endpoints that report duplicates their own way pass their own.
+type idempotencyVerifier func(first, second idempotentResponse) error
+
+// TestIdempotentEndpoint checks that the endpoint at IDEMPOTENT_URL doesn't
+// apply a repeated POST twice. IDEMPOTENT_BODY is the JSON request body, {}
+// by default. It is skipped until Cloud Functions with side effects exist.
+func TestIdempotentEndpoint(t *testing.T) {
+	idempotentURL := os.Getenv(idempotentURLEnvVar)
+	if idempotentURL == "" {
+		t.Skipf("Skipping idempotency test: set %s to an endpoint with a side effect", idempotentURLEnvVar)
+	}
+
+	assertValidURL(t, idempotentURL)
+	body := os.Getenv("IDEMPOTENT_BODY")
+	if body == "" {
+		body = "{}"
+	}
+	assertIdempotent(t, idempotentURL, "terratest-"+newTraceID(), body, nil)
+}
+
+// assertIdempotent POSTs body to url twice with the same Idempotency-Key and
+// fails the test when verify says the second request was applied again. A
+// nil verify uses verifyNotCreated.
+func assertIdempotent(t testing.TB, url, idempotencyKey, body string, verify idempotencyVerifier) {
+	t.Helper()
+
+	if verify == nil {
+		verify = verifyNotCreated
+	}
+	first, err := postWithIdempotencyKey(url, idempotencyKey, body)
+	if err != nil {
+		t.Fatalf("First POST to %s failed: %v", url, err)
+	}
+	second, err := postWithIdempotencyKey(url, idempotencyKey, body)
+	if err != nil {
+		t.Fatalf("Repeated POST to %s failed: %v", url, err)
+	}
+	if err := verify(first, second); err != nil {
+		t.Errorf("Repeating a POST to %s with %s %s applied it again: %v", url, idempotencyKeyHeader, idempotencyKey, err)
+		return
+	}
+	t.Logf("Repeated POST to %s with %s %s was not applied twice", url, idempotencyKeyHeader, idempotencyKey)
+}
+
+// postWithIdempotencyKey POSTs the JSON body to url with idempotencyKey and
+// returns the answer, which must be a 2xx.
+func postWithIdempotencyKey(url, idempotencyKey, body string) (idempotentResponse, error) {
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	if err != nil {
+		return idempotentResponse{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(idempotencyKeyHeader, idempotencyKey)
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return idempotentResponse{}, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return idempotentResponse{}, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return idempotentResponse{}, fmt.Errorf("unexpected status %d: %.200s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return idempotentResponse{StatusCode: resp.StatusCode, Body: string(data)}, nil
+}
+
+// verifyNotCreated is the idempotencyVerifier for endpoints answering with a
+// JSON object that has a created field: the repeat must report created as
+// false and otherwise return the same result as the first request.
+func verifyNotCreated(first, second idempotentResponse) error {
+	var firstResult, secondResult map[string]interface{}
+	if err := json.Unmarshal([]byte(first.Body), &firstResult); err != nil {
+		return fmt.Errorf("first response is not a JSON object: %w", err)
+	}
+	if err := json.Unmarshal([]byte(second.Body), &secondResult); err != nil {
+		return fmt.Errorf("repeated response is not a JSON object: %w", err)
+	}
+
+	created, ok := secondResult["created"].(bool)
+	if !ok {
+		return errors.New(`repeated response has no boolean "created" field`)
+	}
+	if created {
+		return errors.New(`repeated response has "created": true`)
+	}
+	delete(firstResult, "created")
+	delete(secondResult, "created")
+	if !reflect.DeepEqual(firstResult, secondResult) {
+		return fmt.Errorf("repeated response %s differs from the first, %s", second.Body, first.Body)
+	}
+	return nil
+}
+
+func TestAssertIdempotent(t *testing.T) {
+	// orders creates an order per new key and answers repeats with the same
+	// order; double creates a new one every time
+	var mu sync.Mutex
+	orders := map[string]int{}
+	idempotent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		key := r.Header.Get(idempotencyKeyHeader)
+		id, seen := orders[key]
+		if !seen {
+			id = len(orders) + 1
+			orders[key] = id
+		}
+		fmt.Fprintf(w, `{"order": %d, "created": %t}`, id, !seen)
+	}))
+	defer idempotent.Close()
+
+	assertIdempotent(t, idempotent.URL, "key-1", "{}", nil)
+
+	calls := 0
+	double := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		fmt.Fprintf(w, `{"order": %d, "created": true}`, calls)
+	}))
+	defer double.Close()
+
+	first, err := postWithIdempotencyKey(double.URL, "key-1", "{}")
+	assert.NoError(t, err)
+	second, err := postWithIdempotencyKey(double.URL, "key-1", "{}")
+	assert.NoError(t, err)
+	assert.EqualError(t, verifyNotCreated(first, second), `repeated response has "created": true`)
+
+	assert.ErrorContains(t, verifyNotCreated(
+		idempotentResponse{Body: `{"order": 1, "created": true}`},
+		idempotentResponse{Body: `{"order": 2, "created": false}`},
+	), "differs from the first")
+
+	// An endpoint without a created field plugs in its own check
+	var sameBody idempotencyVerifier = func(first, second idempotentResponse) error {
+		if first.Body != second.Body {
+			return errors.New("bodies differ")
+		}
+		return nil
+	}
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "accepted")
+	}))
+	defer plain.Close()
+	assertIdempotent(t, plain.URL, "key-1", "{}", sameBody)
+}
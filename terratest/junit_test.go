@@ -0,0 +1,267 @@
+package test
+
+import (
+	"bufio"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// junitChildEnvVar marks the re-executed test binary whose verbose output
+// runWithReports turns into reports.
+const junitChildEnvVar = "JUNIT_CHILD"
+
+// runWithReports re-runs the test binary with -test.v, passing its output
+// through while recording every test, and writes the results to junitPath
+// as JUnit XML and to reportPath as a TestReport, skipping either when it is
+// "". It returns the child's exit code.
+func runWithReports(junitPath, reportPath string) int {
+	started := time.Now()
+	cmd := exec.Command(os.Args[0], append(os.Args[1:], "-test.v=true")...)
+	cmd.Env = append(os.Environ(), junitChildEnvVar+"=1")
+	cmd.Stderr = os.Stderr
+
+	// The child hands what it measured back through a file
+	var measurementsPath string
+	if reportPath != "" {
+		measurements, err := os.CreateTemp("", "test-report-*.json")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not create a file for the %s measurements: %v\n", reportPath, err)
+			return 1
+		}
+		measurements.Close()
+		measurementsPath = measurements.Name()
+		defer os.Remove(measurementsPath)
+		cmd.Env = append(cmd.Env, testReportMeasurementsEnvVar+"="+measurementsPath)
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not capture test output: %v\n", err)
+		return 1
+	}
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not re-run the tests: %v\n", err)
+		return 1
+	}
+
+	cases := parseTestOutput(io.TeeReader(stdout, os.Stdout))
+
+	exitCode := 0
+	if err := cmd.Wait(); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			fmt.Fprintf(os.Stderr, "Test run failed: %v\n", err)
+			return 1
+		}
+		exitCode = exitErr.ExitCode()
+	}
+
+	if junitPath != "" {
+		if err := writeJUnit(junitPath, "hello-world-test", cases); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not write JUnit report %s: %v\n", junitPath, err)
+			if exitCode == 0 {
+				exitCode = 1
+			}
+		}
+	}
+	if reportPath != "" {
+		if err := writeTestReport(reportPath, measurementsPath, started, cases); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not write test report %s: %v\n", reportPath, err)
+			if exitCode == 0 {
+				exitCode = 1
+			}
+		}
+	}
+	return exitCode
+}
+
+// junitCase is one test's outcome as read from `go test -v` output.
+type junitCase struct {
+	Name     string
+	Status   string // "PASS", "FAIL" or "SKIP"
+	Seconds  float64
+	Output   []string
+	finished bool
+}
+
+// skipMessage is the last line the test logged before skipping, which is
+// the reason passed to t.Skip, without its file:line prefix.
+func (c *junitCase) skipMessage() string {
+	for i := len(c.Output) - 1; i >= 0; i-- {
+		if line := strings.TrimSpace(c.Output[i]); line != "" {
+			return logPrefix.ReplaceAllString(line, "")
+		}
+	}
+	return ""
+}
+
+var (
+	// testEvent matches the lines `go test -v` uses to switch between tests
+	testEvent = regexp.MustCompile(`^=== (RUN|CONT|NAME|PAUSE)\s+(\S+)`)
+	// testResult matches a test's final line, indented for subtests
+	testResult = regexp.MustCompile(`^\s*--- (PASS|FAIL|SKIP): (\S+) \(([0-9.]+)s\)`)
+	// logPrefix is the file:line t.Log puts in front of each message
+	logPrefix = regexp.MustCompile(`^\S+\.go:\d+: `)
+)
+
+// parseTestOutput reads `go test -v` output and returns the tests it reports,
+// in the order they started. Output lines are attributed to the test that
+// was running when they were printed.
+func parseTestOutput(r io.Reader) []*junitCase {
+	var cases []*junitCase
+	byName := map[string]*junitCase{}
+	var current *junitCase
+
+	lookup := func(name string) *junitCase {
+		c, ok := byName[name]
+		if !ok {
+			c = &junitCase{Name: name}
+			byName[name] = c
+			cases = append(cases, c)
+		}
+		return c
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := testEvent.FindStringSubmatch(line); m != nil {
+			current = lookup(m[2])
+			continue
+		}
+		if m := testResult.FindStringSubmatch(line); m != nil {
+			c := lookup(m[2])
+			c.Status = m[1]
+			c.Seconds, _ = strconv.ParseFloat(m[3], 64)
+			c.finished = true
+			continue
+		}
+		if current != nil && !current.finished {
+			current.Output = append(current.Output, line)
+		}
+	}
+
+	// A test that never reported a result was cut short, e.g. by a panic or -timeout
+	for _, c := range cases {
+		if !c.finished {
+			c.Status = "FAIL"
+		}
+	}
+	return cases
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name        `xml:"testsuites"`
+	Suites  []junitSuiteXML `xml:"testsuite"`
+}
+
+type junitSuiteXML struct {
+	Name     string         `xml:"name,attr"`
+	Tests    int            `xml:"tests,attr"`
+	Failures int            `xml:"failures,attr"`
+	Skipped  int            `xml:"skipped,attr"`
+	Time     string         `xml:"time,attr"`
+	Cases    []junitCaseXML `xml:"testcase"`
+}
+
+type junitCaseXML struct {
+	ClassName string           `xml:"classname,attr"`
+	Name      string           `xml:"name,attr"`
+	Time      string           `xml:"time,attr"`
+	Failure   *junitMessageXML `xml:"failure,omitempty"`
+	Skipped   *junitMessageXML `xml:"skipped,omitempty"`
+	SystemOut string           `xml:"system-out,omitempty"`
+}
+
+type junitMessageXML struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",chardata"`
+}
+
+// buildJUnit converts cases into a single JUnit test suite named suite.
+func buildJUnit(suite string, cases []*junitCase) junitTestSuites {
+	s := junitSuiteXML{Name: suite, Tests: len(cases)}
+	var total float64
+	for _, c := range cases {
+		tc := junitCaseXML{ClassName: suite, Name: c.Name, Time: formatSeconds(c.Seconds)}
+		output := strings.Join(c.Output, "\n")
+		switch c.Status {
+		case "FAIL":
+			s.Failures++
+			tc.Failure = &junitMessageXML{Message: "Failed", Body: output}
+		case "SKIP":
+			s.Skipped++
+			tc.Skipped = &junitMessageXML{Message: c.skipMessage()}
+		default:
+			tc.SystemOut = output
+		}
+		// Subtest durations are already included in their parent's
+		if !strings.Contains(c.Name, "/") {
+			total += c.Seconds
+		}
+		s.Cases = append(s.Cases, tc)
+	}
+	s.Time = formatSeconds(total)
+	return junitTestSuites{Suites: []junitSuiteXML{s}}
+}
+
+func formatSeconds(seconds float64) string {
+	return strconv.FormatFloat(seconds, 'f', 3, 64)
+}
+
+// writeJUnit writes cases to path as a JUnit XML document.
+func writeJUnit(path, suite string, cases []*junitCase) error {
+	data, err := xml.MarshalIndent(buildJUnit(suite, cases), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append([]byte(xml.Header), append(data, '\n')...), 0o644)
+}
+
+func TestParseTestOutput(t *testing.T) {
+	output := `=== RUN   TestHelloWorld
+    hello_world_test.go:37: Skipping test due to billing account issue: Error 403
+--- SKIP: TestHelloWorld (0.41s)
+=== RUN   TestClassifyApplyError
+=== RUN   TestClassifyApplyError/timeout
+--- PASS: TestClassifyApplyError (0.00s)
+    --- PASS: TestClassifyApplyError/timeout (0.00s)
+=== RUN   TestFunctionTimeout
+    hello_world_test.go:1250: Request was aborted before the function timeout
+--- FAIL: TestFunctionTimeout (61.20s)
+=== RUN   TestPlanStability
+FAIL
+`
+	cases := parseTestOutput(strings.NewReader(output))
+
+	if assert.Len(t, cases, 5) {
+		assert.Equal(t, "SKIP", cases[0].Status)
+		assert.Equal(t, "Skipping test due to billing account issue: Error 403", cases[0].skipMessage())
+		assert.Equal(t, "PASS", cases[1].Status)
+		assert.Equal(t, "TestClassifyApplyError/timeout", cases[2].Name)
+		assert.Equal(t, "FAIL", cases[3].Status)
+		assert.Equal(t, 61.2, cases[3].Seconds)
+		assert.Equal(t, "FAIL", cases[4].Status, "A test without a result line was cut short")
+	}
+
+	report := buildJUnit("hello-world-test", cases)
+	suite := report.Suites[0]
+	assert.Equal(t, 5, suite.Tests)
+	assert.Equal(t, 2, suite.Failures)
+	assert.Equal(t, 1, suite.Skipped)
+	assert.Equal(t, "61.610", suite.Time)
+	assert.Equal(t, "Skipping test due to billing account issue: Error 403", suite.Cases[0].Skipped.Message)
+	assert.Contains(t, suite.Cases[3].Failure.Body, "aborted before the function timeout")
+}
@@ -0,0 +1,311 @@
+package test
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"hello-world-test/gcptest"
+)
+
+// defaultColdStartIdleWindow is how long the function must sit idle before
+// Cloud Functions reclaims its instances and the next request cold-starts.
+const defaultColdStartIdleWindow = 15 * time.Minute
+
+// BenchmarkColdStart deploys the dev environment once and, for each
+// iteration, waits out the idle window before timing the first byte of a
+// request to function_url. Run it with a small -benchtime, e.g. -benchtime=3x.
+// COLD_START_IDLE_WINDOW (a Go duration) overrides the idle wait.
+func BenchmarkColdStart(b *testing.B) {
+	projectID := gcptest.GetProjectID(b)
+
+	idleWindow := envDuration(b, "COLD_START_IDLE_WINDOW", defaultColdStartIdleWindow)
+
+	terraformOptions := buildOptions(b, devEnvironmentDir, map[string]interface{}{
+		"project_id": projectID,
+	})
+
+	defer destroyAndVerify(b, terraformOptions)
+
+	if !initAndApply(b, terraformOptions) {
+		b.Skip("Skipping benchmark: terraform init failed")
+	}
+
+	functionURL := getRequiredOutput(b, terraformOptions, "function_url")
+	assertValidURL(b, functionURL)
+
+	var total time.Duration
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		b.Logf("Waiting %s for the function to scale to zero", idleWindow)
+		time.Sleep(idleWindow)
+		b.StartTimer()
+
+		latency, err := timeToFirstByte(functionURL)
+		if err != nil {
+			b.Fatalf("Cold start request %d failed: %v", i+1, err)
+		}
+		total += latency
+	}
+	b.StopTimer()
+
+	b.ReportMetric(float64(total.Milliseconds())/float64(b.N), "ms/coldstart")
+}
+
+// timeToFirstByte issues a GET to url and returns how long it took for the
+// first response byte to arrive.
+func timeToFirstByte(url string) (time.Duration, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	var firstByte time.Time
+	trace := &httptrace.ClientTrace{
+		GotFirstResponseByte: func() { firstByte = time.Now() },
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	// A fresh client avoids reusing a connection from a previous iteration
+	client := &http.Client{Timeout: 2 * time.Minute}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected status %d from %s", resp.StatusCode, url)
+	}
+	return firstByte.Sub(start), nil
+}
+
+// defaultScaleToZeroIdleWindow is how long TestScaleToZero leaves the function
+// idle before checking that it recovers from a cold start. Cloud Functions
+// keeps idle instances around for up to about 15 minutes, so a shorter wait
+// often ends before the function has scaled to zero.
+const defaultScaleToZeroIdleWindow = 20 * time.Minute
+
+// TestScaleToZero checks that the dev function recovers from scale-to-zero: it
+// warms the function up, leaves it idle, then expects the next request to
+// still answer 200 and Cloud Monitoring to show zero active instances during
+// the idle window. The wait makes it opt-in with RUN_SCALE_TO_ZERO=1, and it
+// is skipped under -short. SCALE_TO_ZERO_IDLE_WINDOW (a Go duration)
+// overrides the idle wait.
+func TestScaleToZero(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping scale-to-zero test in -short mode")
+	}
+	if os.Getenv("RUN_SCALE_TO_ZERO") != "1" {
+		t.Skip("Skipping scale-to-zero test: set RUN_SCALE_TO_ZERO=1 to wait out the idle window")
+	}
+
+	projectID := gcptest.GetProjectID(t)
+	idleWindow := envDuration(t, "SCALE_TO_ZERO_IDLE_WINDOW", defaultScaleToZeroIdleWindow)
+
+	terraformOptions := buildOptions(t, devEnvironmentDir, map[string]interface{}{
+		"project_id": projectID,
+	})
+
+	if !initTerraform(t, terraformOptions) {
+		return
+	}
+	withWorkspace(t, terraformOptions, "terratest-scale-to-zero")
+	defer destroyAndVerify(t, terraformOptions)
+
+	applyTerraform(t, terraformOptions)
+	functionURL := checkFunctionURL(t, terraformOptions, devEnvironment)
+	functionName := getRequiredOutput(t, terraformOptions, "function_name")
+
+	idleStart := time.Now()
+	t.Logf("Leaving %s idle for %s", functionName, idleWindow)
+	time.Sleep(idleWindow)
+	idleEnd := time.Now()
+
+	latency, err := timeToFirstByte(functionURL)
+	if err != nil {
+		t.Fatalf("Function did not recover after %s idle: %v", idleWindow, err)
+	}
+	t.Logf("First request after %s idle took %s", idleWindow, latency)
+
+	gcptest.AssertScaledToZero(t, projectID, functionName, idleStart, idleEnd)
+}
+
+// BenchmarkKeepAlive deploys the dev environment once and times requests to
+// function_url over a shared keep-alive client against a fresh client, with
+// keep-alives disabled, per request. The gap between the two ms/req metrics
+// is the cost of connection and TLS setup.
+func BenchmarkKeepAlive(b *testing.B) {
+	projectID := gcptest.GetProjectID(b)
+
+	terraformOptions := buildOptions(b, devEnvironmentDir, map[string]interface{}{
+		"project_id": projectID,
+	})
+
+	defer destroyAndVerify(b, terraformOptions)
+
+	if !initAndApply(b, terraformOptions) {
+		b.Skip("Skipping benchmark: terraform init failed")
+	}
+
+	functionURL := getRequiredOutput(b, terraformOptions, "function_url")
+	assertValidURL(b, functionURL)
+
+	b.Run("KeepAlive", func(b *testing.B) {
+		client := &http.Client{Timeout: 30 * time.Second}
+		defer client.CloseIdleConnections()
+
+		// Open the pooled connection outside the timed loop
+		if _, err := timedRequest(client, functionURL); err != nil {
+			b.Fatalf("Warm-up request failed: %v", err)
+		}
+		benchmarkRequests(b, functionURL, func() *http.Client { return client })
+	})
+
+	b.Run("NewConnection", func(b *testing.B) {
+		benchmarkRequests(b, functionURL, func() *http.Client {
+			return &http.Client{
+				Timeout:   30 * time.Second,
+				Transport: &http.Transport{DisableKeepAlives: true},
+			}
+		})
+	})
+}
+
+// benchmarkRequests issues b.N requests to url, each with the client newClient
+// returns, and reports the mean latency as ms/req.
+func benchmarkRequests(b *testing.B, url string, newClient func() *http.Client) {
+	var total time.Duration
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		latency, err := timedRequest(newClient(), url)
+		if err != nil {
+			b.Fatalf("Request %d failed: %v", i+1, err)
+		}
+		total += latency
+	}
+	b.StopTimer()
+
+	b.ReportMetric(float64(total.Microseconds())/1000/float64(b.N), "ms/req")
+}
+
+// timedRequest issues a GET to url with client and returns how long it took
+// to read the whole response.
+func timedRequest(client *http.Client, url string) (time.Duration, error) {
+	start := time.Now()
+	resp, err := client.Get(url)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// Draining the body lets the keep-alive client reuse the connection
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected status %d from %s", resp.StatusCode, url)
+	}
+	return time.Since(start), nil
+}
+
+// latencyPercentiles are the percentiles measureLatencyPercentiles reports,
+// in table order.
+var latencyPercentiles = []struct {
+	name    string
+	percent float64
+}{{"p50", 50}, {"p90", 90}, {"p95", 95}, {"p99", 99}}
+
+// assertLatencyPercentiles measures LATENCY_SAMPLES requests to url and,
+// when LATENCY_P99_MS is set, fails if their p99 is over it.
+func assertLatencyPercentiles(t *testing.T, url string) {
+	t.Helper()
+
+	percentiles := measureLatencyPercentiles(t, url, envInt(t, "LATENCY_SAMPLES", defaultLatencySamples))
+	if maxP99 := time.Duration(envInt(t, "LATENCY_P99_MS", 0)) * time.Millisecond; maxP99 > 0 && percentiles["p99"] > maxP99 {
+		t.Errorf("p99 latency of %s is %s, over the %s limit", url, percentiles["p99"], maxP99)
+	}
+}
+
+// measureLatencyPercentiles primes url with one request so a cold start
+// doesn't skew the results, then times samples sequential GETs over a
+// keep-alive connection and returns their p50, p90, p95 and p99, which it
+// also logs as a table.
+func measureLatencyPercentiles(t testing.TB, url string, samples int) map[string]time.Duration {
+	t.Helper()
+
+	if samples < 1 {
+		t.Fatalf("Need at least one latency sample, got %d", samples)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	if _, err := timedRequest(client, url); err != nil {
+		t.Fatalf("Priming request to %s failed: %v", url, err)
+	}
+
+	latencies := make([]time.Duration, samples)
+	for i := range latencies {
+		latency, err := timedRequest(client, url)
+		if err != nil {
+			t.Fatalf("Request %d of %d to %s failed: %v", i+1, samples, url, err)
+		}
+		latencies[i] = latency
+	}
+
+	percentiles := percentileLatencies(latencies)
+	var table strings.Builder
+	fmt.Fprintf(&table, "Latency of %s over %d warm requests:\n", url, samples)
+	for _, p := range latencyPercentiles {
+		fmt.Fprintf(&table, "  %-4s %10s\n", p.name, percentiles[p.name].Round(time.Microsecond))
+		testReportMeasurements.recordLatency(url, p.name, percentiles[p.name])
+	}
+	t.Log(table.String())
+	return percentiles
+}
+
+// percentileLatencies returns the latencyPercentiles of latencies by the
+// nearest-rank method. latencies is sorted in place.
+func percentileLatencies(latencies []time.Duration) map[string]time.Duration {
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	percentiles := make(map[string]time.Duration, len(latencyPercentiles))
+	for _, p := range latencyPercentiles {
+		rank := int(math.Ceil(p.percent / 100 * float64(len(latencies))))
+		if rank < 1 {
+			rank = 1
+		}
+		percentiles[p.name] = latencies[rank-1]
+	}
+	return percentiles
+}
+
+func TestPercentileLatencies(t *testing.T) {
+	latencies := make([]time.Duration, 100)
+	for i := range latencies {
+		// Reversed, to check the input doesn't need to be sorted
+		latencies[i] = time.Duration(100-i) * time.Millisecond
+	}
+
+	assert.Equal(t, map[string]time.Duration{
+		"p50": 50 * time.Millisecond,
+		"p90": 90 * time.Millisecond,
+		"p95": 95 * time.Millisecond,
+		"p99": 99 * time.Millisecond,
+	}, percentileLatencies(latencies))
+
+	single := percentileLatencies([]time.Duration{time.Second})
+	assert.Equal(t, time.Second, single["p50"])
+	assert.Equal(t, time.Second, single["p99"])
+}
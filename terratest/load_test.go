@@ -0,0 +1,216 @@
+package test
+
+import (
+	"net/url"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	tfjson "github.com/hashicorp/terraform-json"
+	"github.com/stretchr/testify/assert"
+
+	"hello-world-test/gcptest"
+)
+
+// Load test defaults, overridable through LOAD_TEST_CONCURRENCY,
+// LOAD_TEST_DURATION, LOAD_TEST_MAX_ERROR_RATE and LOAD_TEST_P95.
+const (
+	defaultLoadTestConcurrency  = 20
+	defaultLoadTestDuration     = 30 * time.Second
+	defaultLoadTestMaxErrorRate = 0.01
+	defaultLoadTestP95          = 2 * time.Second
+)
+
+// loadTestRequestsPerCPU is how many requests the load test keeps in flight
+// per CPU unless LOAD_TEST_MAX_CONCURRENCY says otherwise; beyond that a
+// small runner spends its time on connections rather than measuring.
+const loadTestRequestsPerCPU = 4
+
+// maxConcurrency returns the most requests the load test may have in flight
+// at once: LOAD_TEST_MAX_CONCURRENCY, or loadTestRequestsPerCPU per CPU.
+func maxConcurrency(t *testing.T) int {
+	t.Helper()
+
+	limit := envInt(t, "LOAD_TEST_MAX_CONCURRENCY", loadTestRequestsPerCPU*runtime.NumCPU())
+	if limit < 1 {
+		t.Fatalf("LOAD_TEST_MAX_CONCURRENCY must be at least 1, got %d", limit)
+	}
+	return limit
+}
+
+func TestMaxConcurrency(t *testing.T) {
+	t.Setenv("LOAD_TEST_MAX_CONCURRENCY", "")
+	assert.Equal(t, loadTestRequestsPerCPU*runtime.NumCPU(), maxConcurrency(t))
+
+	t.Setenv("LOAD_TEST_MAX_CONCURRENCY", "2")
+	assert.Equal(t, 2, maxConcurrency(t))
+}
+
+// TestFunctionUnderLoad sends load to the dev function, from
+// LOAD_TEST_CONCURRENCY workers for LOAD_TEST_DURATION, or as
+// LOAD_TEST_REQUESTS requests when that is set, and checks the error rate
+// and p95 latency. Either way no more than maxConcurrency requests are in
+// flight at once.
+func TestFunctionUnderLoad(t *testing.T) {
+	projectID := gcptest.GetProjectID(t)
+
+	concurrency := envInt(t, "LOAD_TEST_CONCURRENCY", defaultLoadTestConcurrency)
+	if concurrency < 1 {
+		t.Fatalf("LOAD_TEST_CONCURRENCY must be at least 1, got %d", concurrency)
+	}
+	if limit := maxConcurrency(t); concurrency > limit {
+		t.Logf("Capping the load test at %d concurrent requests (LOAD_TEST_MAX_CONCURRENCY), not %d", limit, concurrency)
+		concurrency = limit
+	}
+	requests := envInt(t, "LOAD_TEST_REQUESTS", 0)
+	duration := envDuration(t, "LOAD_TEST_DURATION", defaultLoadTestDuration)
+	maxErrorRate := envFloat(t, "LOAD_TEST_MAX_ERROR_RATE", defaultLoadTestMaxErrorRate)
+	maxP95 := envDuration(t, "LOAD_TEST_P95", defaultLoadTestP95)
+
+	terraformOptions := buildOptions(t, devEnvironmentDir, map[string]interface{}{
+		"project_id": projectID,
+	})
+
+	if !initTerraform(t, terraformOptions) {
+		return
+	}
+	withWorkspace(t, terraformOptions, "terratest-load")
+	defer destroyAndVerify(t, terraformOptions)
+
+	applyTerraform(t, terraformOptions)
+
+	functionURL := getOutputs(t, terraformOptions, "function_url")["function_url"]
+	if functionURL == "" {
+		t.Skip("Skipping load test: no function URL available")
+	}
+	assertValidURL(t, functionURL)
+
+	// Make sure the function is up before measuring it
+	checkFunctionURL(t, terraformOptions, devEnvironment)
+
+	var result gcptest.LoadResult
+	var err error
+	if requests > 0 {
+		t.Logf("Sending %d requests to %s, at most %d at a time", requests, functionURL, concurrency)
+		result, err = gcptest.RunRequests(functionURL, requests, concurrency)
+	} else {
+		t.Logf("Sending load to %s with %d workers for %s", functionURL, concurrency, duration)
+		result, err = gcptest.RunLoad(functionURL, concurrency, duration)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("Load test summary:\n%s", result.Summary())
+
+	assert.LessOrEqual(t, result.ErrorRate(), maxErrorRate, "Error rate under load is too high")
+	assert.LessOrEqual(t, result.Percentile(95), maxP95, "p95 latency under load is too high")
+}
+
+// devRequestConcurrency is how many requests one instance of the dev
+// function may serve at once. A 1st gen function always serves one; a 2nd gen
+// one takes max_instance_request_concurrency from its service_config.
+const devRequestConcurrency = 1
+
+// concurrencyProbeDelay is how long each request in
+// TestFunctionConcurrencyScaling asks the function to sleep for.
+const concurrencyProbeDelay = 10 * time.Second
+
+// assertConcurrency checks that each instance of the Cloud Function in state
+// serves at most expected requests at once.
+func assertConcurrency(t testing.TB, state *tfjson.State, expected int) {
+	t.Helper()
+
+	address, concurrency, err := functionConcurrency(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if concurrency != expected {
+		t.Errorf("Expected %s to serve %d request(s) per instance, it is configured for %d", address, expected, concurrency)
+	}
+}
+
+// functionConcurrency returns the address of the Cloud Function in state and
+// how many requests one of its instances serves at once: always 1 for a 1st
+// gen function, the Cloud Run max_instance_request_concurrency of a 2nd gen
+// one.
+func functionConcurrency(state *tfjson.State) (string, int, error) {
+	function, attributes, err := functionRuntimeAttributes(state)
+	if err != nil {
+		return "", 0, err
+	}
+	if function.Type != "google_cloudfunctions2_function" {
+		return function.Address, 1, nil
+	}
+	// Unset, Cloud Run defaults to one request per instance for functions
+	concurrency, _ := attributes["max_instance_request_concurrency"].(float64)
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return function.Address, int(concurrency), nil
+}
+
+func TestFunctionConcurrency(t *testing.T) {
+	gen1 := stateWithResources(&tfjson.StateResource{
+		Address:         "google_cloudfunctions_function.hello_world",
+		Type:            "google_cloudfunctions_function",
+		AttributeValues: map[string]interface{}{"runtime": expectedFunctionRuntime},
+	})
+	_, concurrency, err := functionConcurrency(gen1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, concurrency, "A 1st gen instance serves one request at a time")
+	assertConcurrency(t, gen1, devRequestConcurrency)
+
+	gen2 := stateWithResources(&tfjson.StateResource{
+		Address: "google_cloudfunctions2_function.hello_world",
+		Type:    "google_cloudfunctions2_function",
+		AttributeValues: map[string]interface{}{"service_config": []interface{}{
+			map[string]interface{}{"max_instance_request_concurrency": 80.0},
+		}},
+	})
+	_, concurrency, err = functionConcurrency(gen2)
+	assert.NoError(t, err)
+	assert.Equal(t, 80, concurrency)
+	assertConcurrency(t, gen2, 80)
+}
+
+// TestFunctionConcurrencyScaling checks the dev function's per-instance
+// concurrency against devRequestConcurrency, then sends five more slow
+// requests at once than one instance may serve. They must all succeed within
+// twice concurrencyProbeDelay, which they only can when Cloud Functions starts
+// more instances instead of queueing the extra requests behind the first.
+func TestFunctionConcurrencyScaling(t *testing.T) {
+	projectID := gcptest.GetProjectID(t)
+
+	terraformOptions := buildOptions(t, devEnvironmentDir, map[string]interface{}{
+		"project_id": projectID,
+	})
+
+	if !initTerraform(t, terraformOptions) {
+		return
+	}
+	withWorkspace(t, terraformOptions, "terratest-concurrency")
+	defer destroyAndVerify(t, terraformOptions)
+
+	applyTerraform(t, terraformOptions)
+	state := readState(t, terraformOptions)
+	assertConcurrency(t, state, devRequestConcurrency)
+	_, concurrency, err := functionConcurrency(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	functionURL := checkFunctionURL(t, terraformOptions, devEnvironment)
+	requestURL := withQuery(t, functionURL, url.Values{"delay": {strconv.Itoa(int(concurrencyProbeDelay.Seconds()))}})
+	requests := concurrency + 5
+	t.Logf("Sending %d simultaneous %s requests to a function serving %d per instance", requests, concurrencyProbeDelay, concurrency)
+	result, err := gcptest.RunRequests(requestURL, requests, requests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("Concurrency probe summary:\n%s", result.Summary())
+
+	assert.Zero(t, result.Errors, "Every simultaneous request should be served")
+	assert.Less(t, result.Percentile(100), 2*concurrencyProbeDelay,
+		"The slowest request waited for another to finish, so the function queued instead of scaling out")
+}
@@ -0,0 +1,409 @@
+package test
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tfjson "github.com/hashicorp/terraform-json"
+	"github.com/stretchr/testify/assert"
+
+	"hello-world-test/gcptest"
+)
+
+// TestFunctionIngressRestricted deploys the dev environment with ingress
+// limited to the load balancer and internal traffic, then expects a direct
+// request to function_url from outside to be turned away while the load
+// balancer still serves the greeting.
+func TestFunctionIngressRestricted(t *testing.T) {
+	projectID := gcptest.GetProjectID(t)
+
+	terraformOptions := buildOptions(t, devEnvironmentDir, map[string]interface{}{
+		"project_id":       projectID,
+		"ingress_settings": ingressAllowInternalAndLB,
+	})
+
+	if !initTerraform(t, terraformOptions) {
+		return
+	}
+	withWorkspace(t, terraformOptions, "terratest-ingress")
+	defer destroyAndVerify(t, terraformOptions)
+
+	applyTerraform(t, terraformOptions)
+	assertIngressSettings(t, readState(t, terraformOptions), ingressAllowInternalAndLB)
+
+	functionURL := getRequiredOutput(t, terraformOptions, "function_url")
+	assertValidURL(t, functionURL)
+	loadBalancerURL := getRequiredOutput(t, terraformOptions, "load_balancer_url")
+	assertValidURL(t, loadBalancerURL)
+	err := gcptest.WaitForHealthy(t, healthEndpoint(loadBalancerURL), loadBalancerReadyTimeout)
+	if !assert.NoError(t, err, "Load balancer should still serve the function with ingress restricted") {
+		return
+	}
+	assertOnlyLBAccessible(t, functionURL, loadBalancerURL, ingressBlockedStatuses)
+}
+
+// ingressBlockedStatuses are what a direct request to a function behind the
+// load balancer answers with: Google's front end rejects it with a 403 or a
+// 404 depending on where it is turned away.
+var ingressBlockedStatuses = []int{http.StatusForbidden, http.StatusNotFound}
+
+// assertOnlyLBAccessible checks the hardened network posture: a direct GET
+// to functionURL is turned away with one of blockedStatuses, and never
+// reaches the function, while a GET to lbURL answers 200. Ingress modes
+// reject direct requests with different codes, hence blockedStatuses.
+func assertOnlyLBAccessible(t testing.TB, functionURL, lbURL string, blockedStatuses []int) {
+	t.Helper()
+
+	problems, err := lbOnlyProblems(functionURL, lbURL, blockedStatuses)
+	if err != nil {
+		t.Fatalf("Could not check access to the function: %v", err)
+	}
+	if len(problems) > 0 {
+		t.Errorf("Function is not reachable only through the load balancer:\n  %s", strings.Join(problems, "\n  "))
+	}
+}
+
+// lbOnlyProblems returns what assertOnlyLBAccessible would report.
+func lbOnlyProblems(functionURL, lbURL string, blockedStatuses []int) ([]string, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	get := func(url string) (int, string, error) {
+		resp, err := client.Get(url)
+		if err != nil {
+			return 0, "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body), err
+	}
+
+	var problems []string
+	statusCode, body, err := get(functionURL)
+	if err != nil {
+		return nil, fmt.Errorf("direct request to %s failed: %w", functionURL, err)
+	}
+	if !slices.Contains(blockedStatuses, statusCode) {
+		problems = append(problems, fmt.Sprintf("direct request to %s got %d, expected one of %v", functionURL, statusCode, blockedStatuses))
+	}
+	if strings.Contains(body, "Hello") {
+		problems = append(problems, fmt.Sprintf("direct request to %s reached the function", functionURL))
+	}
+
+	statusCode, _, err = get(lbURL)
+	if err != nil {
+		return nil, fmt.Errorf("request through the load balancer %s failed: %w", lbURL, err)
+	}
+	if statusCode != http.StatusOK {
+		problems = append(problems, fmt.Sprintf("request through the load balancer %s got %d, expected 200", lbURL, statusCode))
+	}
+	return problems, nil
+}
+
+func TestLBOnlyProblems(t *testing.T) {
+	serve := func(status int, body string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+			io.WriteString(w, body)
+		}))
+	}
+	blocked := serve(http.StatusForbidden, "Error: Forbidden")
+	defer blocked.Close()
+	open := serve(http.StatusOK, "Hello World!")
+	defer open.Close()
+	broken := serve(http.StatusBadGateway, "")
+	defer broken.Close()
+
+	problems, err := lbOnlyProblems(blocked.URL, open.URL, ingressBlockedStatuses)
+	assert.NoError(t, err)
+	assert.Empty(t, problems)
+
+	problems, err = lbOnlyProblems(open.URL, broken.URL, ingressBlockedStatuses)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"direct request to " + open.URL + " got 200, expected one of [403 404]",
+		"direct request to " + open.URL + " reached the function",
+		"request through the load balancer " + broken.URL + " got 502, expected 200",
+	}, problems)
+
+	problems, err = lbOnlyProblems(blocked.URL, open.URL, []int{http.StatusNotFound})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"direct request to " + blocked.URL + " got 403, expected one of [404]"}, problems,
+		"Only the configured statuses count as blocked")
+}
+
+// TestLoadBalancerHTTPSRedirect deploys dev with https_redirect and checks
+// that the load balancer's plain HTTP frontend sends clients to HTTPS.
+func TestLoadBalancerHTTPSRedirect(t *testing.T) {
+	projectID := gcptest.GetProjectID(t)
+
+	terraformOptions := buildOptions(t, devEnvironmentDir, map[string]interface{}{
+		"project_id":     projectID,
+		"https_redirect": true,
+	})
+
+	if !initTerraform(t, terraformOptions) {
+		return
+	}
+	withWorkspace(t, terraformOptions, "terratest-redirect")
+	defer destroyAndVerify(t, terraformOptions)
+
+	applyTerraform(t, terraformOptions)
+
+	// Every HTTP path redirects, /healthz included, so there is nothing to
+	// wait on but the redirect itself
+	loadBalancerURL := getRequiredOutput(t, terraformOptions, "load_balancer_url")
+	assertHTTPRedirectsToHTTPS(t, assertValidURL(t, loadBalancerURL).Host)
+}
+
+// httpsRedirectPath is requested by assertHTTPRedirectsToHTTPS, with a query
+// so a redirect that drops either of them is caught.
+const httpsRedirectPath = "/redirect-check?from=http"
+
+// assertHTTPRedirectsToHTTPS requests http://host without following
+// redirects and expects a 301 or 308 to the https:// equivalent of the same
+// host and path. It retries while the load balancer's configuration
+// propagates, then fails with the last status and Location header seen.
+func assertHTTPRedirectsToHTTPS(t *testing.T, host string) {
+	t.Helper()
+
+	err := loadHTTPRetryConfig(t).do(t, fmt.Sprintf("HTTPS redirect from %s", host), func() error {
+		return httpsRedirectProblem(host, httpsRedirectPath)
+	})
+	if err != nil {
+		t.Fatalf("Load balancer does not redirect HTTP to HTTPS: %v", err)
+	}
+	t.Logf("http://%s%s redirects to HTTPS", host, httpsRedirectPath)
+}
+
+// httpsRedirectProblem returns why GET http://host+path isn't a permanent
+// redirect to https://host+path, or nil when it is.
+func httpsRedirectProblem(host, path string) error {
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get("http://" + host + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	location := resp.Header.Get("Location")
+	if resp.StatusCode != http.StatusMovedPermanently && resp.StatusCode != http.StatusPermanentRedirect {
+		return fmt.Errorf("expected 301 or 308, got %d with Location %q", resp.StatusCode, location)
+	}
+	// The port of a non-default HTTP frontend doesn't carry over to HTTPS
+	if expected := "https://" + hostWithoutPort(host) + path; location != expected {
+		return fmt.Errorf("expected a %d to %s, got Location %q", resp.StatusCode, expected, location)
+	}
+	return nil
+}
+
+// hostWithoutPort strips the port, if any, from a URL host.
+func hostWithoutPort(host string) string {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		if strings.Contains(name, ":") {
+			return "[" + name + "]"
+		}
+		return name
+	}
+	return host
+}
+
+func TestHTTPSRedirectProblem(t *testing.T) {
+	redirect := func(status int, location func(r *http.Request) string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Location", location(r))
+			w.WriteHeader(status)
+		}))
+	}
+	host := func(server *httptest.Server) string { return strings.TrimPrefix(server.URL, "http://") }
+
+	good := redirect(http.StatusMovedPermanently, func(r *http.Request) string {
+		return "https://" + hostWithoutPort(r.Host) + r.URL.RequestURI()
+	})
+	defer good.Close()
+	assert.NoError(t, httpsRedirectProblem(host(good), httpsRedirectPath))
+
+	temporary := redirect(http.StatusFound, func(r *http.Request) string {
+		return "https://" + hostWithoutPort(r.Host) + r.URL.RequestURI()
+	})
+	defer temporary.Close()
+	assert.ErrorContains(t, httpsRedirectProblem(host(temporary), httpsRedirectPath), "got 302")
+
+	rootOnly := redirect(http.StatusPermanentRedirect, func(r *http.Request) string {
+		return "https://" + hostWithoutPort(r.Host) + "/"
+	})
+	defer rootOnly.Close()
+	assert.ErrorContains(t, httpsRedirectProblem(host(rootOnly), httpsRedirectPath), `got Location "https://127.0.0.1/"`,
+		"A redirect that drops the path should be reported with its Location")
+
+	served := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "Hello World!")
+	}))
+	defer served.Close()
+	assert.ErrorContains(t, httpsRedirectProblem(host(served), httpsRedirectPath), "got 200")
+}
+
+// TestLoadBalancerCDN deploys the dev configuration with Cloud CDN enabled
+// and checks both that the backend service has it enabled and that a repeated
+// request through the load balancer is answered from the cache.
+func TestLoadBalancerCDN(t *testing.T) {
+	projectID := gcptest.GetProjectID(t)
+
+	terraformOptions := buildOptions(t, devEnvironmentDir, map[string]interface{}{
+		"project_id": projectID,
+		"enable_cdn": true,
+	})
+
+	if !initTerraform(t, terraformOptions) {
+		return
+	}
+	withWorkspace(t, terraformOptions, "terratest-cdn")
+	defer destroyAndVerify(t, terraformOptions)
+
+	applyTerraform(t, terraformOptions)
+
+	assertCDNEnabledInState(t, readState(t, terraformOptions))
+	assertCDNCaching(t, getRequiredOutput(t, terraformOptions, "load_balancer_url"))
+}
+
+// cdnHitWait is how long assertCDNCaching waits between its two requests, so
+// a cached response has an Age of at least a second.
+const cdnHitWait = 2 * time.Second
+
+// assertCDNEnabledInState fails the test unless every backend service in
+// state has enable_cdn set.
+func assertCDNEnabledInState(t testing.TB, state *tfjson.State) {
+	t.Helper()
+
+	for _, problem := range cdnStateProblems(state) {
+		t.Error(problem)
+	}
+}
+
+// cdnStateProblems describes every backend service in state without Cloud
+// CDN enabled, or a missing backend service.
+func cdnStateProblems(state *tfjson.State) []string {
+	services := stateResources(state, "google_compute_backend_service")
+	if len(services) == 0 {
+		return []string{"No google_compute_backend_service in state"}
+	}
+	var problems []string
+	for _, service := range services {
+		if enabled, _ := service.AttributeValues["enable_cdn"].(bool); !enabled {
+			problems = append(problems, fmt.Sprintf("%s does not have enable_cdn set", service.Address))
+		}
+	}
+	return problems
+}
+
+// assertCDNCaching requests url twice with the same, otherwise unused, query
+// and expects the second response to come from Cloud CDN. It retries, with a
+// fresh query each time, while the load balancer's configuration propagates.
+func assertCDNCaching(t *testing.T, url string) {
+	t.Helper()
+
+	err := loadHTTPRetryConfig(t).do(t, fmt.Sprintf("CDN cache hit from %s", url), func() error {
+		return cdnCacheProblem(withQuery(t, url, map[string][]string{"cdn-check": {newTraceID()}}), cdnHitWait)
+	})
+	if err != nil {
+		t.Fatalf("%s is not served from Cloud CDN: %v", url, err)
+	}
+	t.Logf("Repeated requests to %s are served from Cloud CDN", url)
+}
+
+// cdnCacheProblem GETs url, waits for wait and GETs it again, returning why
+// the second response isn't a cache hit, or nil when it is.
+func cdnCacheProblem(url string, wait time.Duration) error {
+	client := &http.Client{Timeout: 10 * time.Second}
+	get := func() (http.Header, error) {
+		resp, err := client.Get(url)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+		}
+		return resp.Header, nil
+	}
+
+	if _, err := get(); err != nil {
+		return err
+	}
+	time.Sleep(wait)
+	header, err := get()
+	if err != nil {
+		return err
+	}
+	if !cacheHit(header) {
+		return fmt.Errorf("second response has Age %q and X-Cache %q, not a cache hit",
+			header.Get("Age"), header.Get("X-Cache"))
+	}
+	return nil
+}
+
+// cacheHit reports whether a response's headers mark it as served from a
+// cache: an Age above zero, which Cloud CDN adds, or an X-Cache hit.
+func cacheHit(header http.Header) bool {
+	if age, err := strconv.Atoi(header.Get("Age")); err == nil && age > 0 {
+		return true
+	}
+	return strings.HasPrefix(strings.ToUpper(header.Get("X-Cache")), "HIT")
+}
+
+func TestCDNStateProblems(t *testing.T) {
+	assert.Empty(t, cdnStateProblems(stateWithResources(&tfjson.StateResource{
+		Address:         "google_compute_backend_service.backend_service",
+		Type:            "google_compute_backend_service",
+		AttributeValues: map[string]interface{}{"enable_cdn": true},
+	})))
+	assert.Equal(t, []string{"google_compute_backend_service.backend_service does not have enable_cdn set"},
+		cdnStateProblems(stateWithResources(&tfjson.StateResource{
+			Address:         "google_compute_backend_service.backend_service",
+			Type:            "google_compute_backend_service",
+			AttributeValues: map[string]interface{}{"enable_cdn": false},
+		})))
+	assert.Len(t, cdnStateProblems(stateWithResources()), 1, "A missing backend service is a problem too")
+}
+
+func TestCDNCacheProblem(t *testing.T) {
+	// cached answers like Cloud CDN: the first request for a URL is a miss,
+	// repeats carry an Age
+	seen := map[string]bool{}
+	var mu sync.Mutex
+	cached := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if seen[r.URL.String()] {
+			w.Header().Set("Age", "1")
+		}
+		seen[r.URL.String()] = true
+		io.WriteString(w, "Hello, World!")
+	}))
+	defer cached.Close()
+	assert.NoError(t, cdnCacheProblem(cached.URL+"/?cdn-check=1", 0))
+
+	uncached := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Age", "0")
+		io.WriteString(w, "Hello, World!")
+	}))
+	defer uncached.Close()
+	assert.ErrorContains(t, cdnCacheProblem(uncached.URL, 0), "not a cache hit")
+
+	assert.True(t, cacheHit(http.Header{"X-Cache": {"HIT from edge"}}))
+	assert.False(t, cacheHit(http.Header{"X-Cache": {"MISS"}}))
+}
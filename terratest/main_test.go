@@ -0,0 +1,348 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+
+	"hello-world-test/gcptest"
+)
+
+// minTerraformVersion is the oldest Terraform CLI the tests support.
+const minTerraformVersion = "1.5.0"
+
+var (
+	terraformVersionOnce sync.Once
+	installedTerraform   string
+	terraformVersionErr  error
+)
+
+// localFunctionURLEnvVar points the HTTP tests at a locally running Functions
+// Framework instance; Terraform-backed tests are skipped while it is set.
+const localFunctionURLEnvVar = "FUNCTION_LOCAL_URL"
+
+// smokeURLEnvVar points TestSmoke at an already deployed URL, e.g. a canary or
+// production during a release gate; Terraform-backed tests are skipped while
+// it is set.
+const smokeURLEnvVar = "SMOKE_URL"
+
+// offlineSafeTests run Terraform through buildOptions without needing GCP
+// access, e.g. to validate the configuration. Under OFFLINE=1 (see
+// gcptest.OfflineEnvVar) buildOptions skips every other test, as
+// gcptest.GetProjectID does; tests that need neither run as usual.
+var offlineSafeTests = []string{
+	"TestTerraformValidation",
+}
+
+// isOffline reports whether OFFLINE=1 is set.
+func isOffline() bool {
+	return gcptest.IsOffline()
+}
+
+// isOfflineSafe reports whether the top-level test t belongs to is in
+// offlineSafeTests.
+func isOfflineSafe(t testing.TB) bool {
+	name, _, _ := strings.Cut(t.Name(), "/")
+	for _, safe := range offlineSafeTests {
+		if name == safe {
+			return true
+		}
+	}
+	return false
+}
+
+// skipIfOffline skips t under OFFLINE=1 unless it is offline-safe, for tests
+// that need GCP before they reach buildOptions or gcptest.GetProjectID.
+func skipIfOffline(t testing.TB) {
+	t.Helper()
+
+	if isOffline() && !isOfflineSafe(t) {
+		t.Skipf("Skipping: %s=1 is set and this test needs GCP", gcptest.OfflineEnvVar)
+	}
+}
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+
+	if isOffline() {
+		fmt.Fprintf(os.Stderr, "%s=1: tests that need GCP will be skipped\n", gcptest.OfflineEnvVar)
+	}
+
+	// With JUNIT_OUTPUT or TEST_REPORT_JSON set, run the tests in a child
+	// process and report on them
+	junitPath, reportPath := os.Getenv("JUNIT_OUTPUT"), os.Getenv(testReportEnvVar)
+	if (junitPath != "" || reportPath != "") && os.Getenv(junitChildEnvVar) == "" {
+		os.Exit(runWithReports(junitPath, reportPath))
+	}
+
+	// Report once up front why Terraform-backed tests will skip, if they will
+	if url := os.Getenv(localFunctionURLEnvVar); url != "" {
+		fmt.Fprintf(os.Stderr, "Testing the local function at %s, tests that run Terraform will be skipped\n", url)
+	} else if url := os.Getenv(smokeURLEnvVar); url != "" {
+		fmt.Fprintf(os.Stderr, "Smoke testing %s, tests that run Terraform will be skipped\n", url)
+	} else if version, err := detectTerraformVersion(); err != nil {
+		fmt.Fprintf(os.Stderr, "Terraform CLI unavailable, tests that run it will be skipped: %v\n", err)
+	} else if !versionAtLeast(version, minTerraformVersion) {
+		fmt.Fprintf(os.Stderr, "Terraform %s is older than %s, tests that run it will be skipped\n", version, minTerraformVersion)
+	}
+
+	// Likewise for credentials, instead of an obscure error halfway through an apply
+	if projectID, ok := gcptest.LookupProjectID(); ok && checkCredentialsEnabled() {
+		if identity, err := checkCredentials(projectID); err != nil {
+			fmt.Fprintf(os.Stderr, "GCP credentials are not usable, tests that deploy will be skipped: %v\n", err)
+		} else {
+			fmt.Fprintf(os.Stderr, "Authenticated to GCP as %s for project %s\n", identity, projectID)
+		}
+	}
+	code := m.Run()
+	if path := os.Getenv(testReportMeasurementsEnvVar); path != "" {
+		if err := testReportMeasurements.write(path); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not save measurements for %s: %v\n", testReportEnvVar, err)
+		}
+	}
+	os.Exit(code)
+}
+
+var (
+	credentialsOnce     sync.Once
+	credentialsIdentity string
+	credentialsErr      error
+)
+
+// checkCredentials runs gcptest.CheckCredentials against projectID once per
+// process and returns its result.
+func checkCredentials(projectID string) (string, error) {
+	credentialsOnce.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		credentialsIdentity, credentialsErr = gcptest.CheckCredentials(ctx, projectID)
+	})
+	return credentialsIdentity, credentialsErr
+}
+
+// checkCredentialsEnabled reports whether the suite checks credentials up
+// front. DRY_RUN, offline, local function and smoke test runs don't deploy
+// anything, so they skip it.
+func checkCredentialsEnabled() bool {
+	return os.Getenv("DRY_RUN") != "1" && os.Getenv(localFunctionURLEnvVar) == "" &&
+		os.Getenv(smokeURLEnvVar) == "" && !isOffline()
+}
+
+// requireCredentials skips the test when the credential check TestMain ran
+// for projectID failed.
+func requireCredentials(t testing.TB, projectID string) {
+	t.Helper()
+
+	if !checkCredentialsEnabled() {
+		return
+	}
+	if _, err := checkCredentials(projectID); err != nil {
+		t.Skipf("Skipping: GCP credentials are not usable: %v", err)
+	}
+}
+
+// buildOptions is gcptest.BuildOptions for tests that run the Terraform CLI:
+// it skips the test first when testing a local function or smoke testing a
+// deployed URL, when offline unless the test is offline-safe, when the
+// installed CLI is missing or too old, and when vars has a project_id the
+// credentials can't access. Unless vars sets name_suffix, resources get this
+// run's runNameSuffix so concurrent runs against one project don't collide.
+// When dir is one of testEnvironments the test report records it for t.
+func buildOptions(t testing.TB, dir string, vars map[string]interface{}, varFiles ...string) *terraform.Options {
+	t.Helper()
+
+	if os.Getenv(localFunctionURLEnvVar) != "" {
+		t.Skipf("Skipping: %s is set, so only the local function is tested", localFunctionURLEnvVar)
+	}
+	if os.Getenv(smokeURLEnvVar) != "" {
+		t.Skipf("Skipping: %s is set, so only the smoke test runs", smokeURLEnvVar)
+	}
+	skipIfOffline(t)
+	assertTerraformVersion(t, minTerraformVersion)
+	if projectID, ok := vars["project_id"].(string); ok && projectID != "" {
+		requireCredentials(t, projectID)
+	}
+
+	if _, ok := vars["name_suffix"]; !ok {
+		withSuffix := map[string]interface{}{"name_suffix": runNameSuffix()}
+		for key, value := range vars {
+			withSuffix[key] = value
+		}
+		vars = withSuffix
+	}
+	for _, env := range testEnvironments {
+		if filepath.Clean(env.dir) == filepath.Clean(dir) {
+			testReportMeasurements.recordEnvironment(t.Name(), env.name)
+		}
+	}
+	return gcptest.BuildOptions(t, dir, vars, varFiles...)
+}
+
+// nameSuffixLength keeps suffixed names within GCP's limits; service account
+// IDs, the tightest, allow 30 characters.
+const nameSuffixLength = 6
+
+// nameSuffixAlphabet is safe in resource names, DNS labels and label values.
+const nameSuffixAlphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+var (
+	runNameSuffixOnce sync.Once
+	runNameSuffixVal  string
+)
+
+// runNameSuffix returns the name suffix shared by every deployment in this
+// test process.
+func runNameSuffix() string {
+	runNameSuffixOnce.Do(func() { runNameSuffixVal = randomSuffix() })
+	return runNameSuffixVal
+}
+
+// uniqueName returns prefix followed by a dash and a random suffix of
+// nameSuffixLength lowercase letters and digits.
+func uniqueName(prefix string) string {
+	return prefix + "-" + randomSuffix()
+}
+
+func randomSuffix() string {
+	b := make([]byte, nameSuffixLength)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("reading random bytes: %v", err))
+	}
+	for i := range b {
+		b[i] = nameSuffixAlphabet[int(b[i])%len(nameSuffixAlphabet)]
+	}
+	return string(b)
+}
+
+// assertTerraformVersion skips the test unless the installed Terraform (or
+// OpenTofu) CLI is at least minVersion.
+func assertTerraformVersion(t testing.TB, minVersion string) {
+	t.Helper()
+
+	version, err := detectTerraformVersion()
+	if err != nil {
+		t.Skipf("Skipping: could not determine the Terraform CLI version: %v", err)
+	}
+	if !versionAtLeast(version, minVersion) {
+		t.Skipf("Skipping: Terraform %s is installed but %s or newer is required", version, minVersion)
+	}
+}
+
+// detectTerraformVersion runs `terraform version -json`, with the CLI
+// TF_BINARY selects, once per process and returns the reported version.
+func detectTerraformVersion() (string, error) {
+	terraformVersionOnce.Do(func() {
+		var stdout, stderr bytes.Buffer
+		cmd := exec.Command(gcptest.TerraformBinary(), "version", "-json")
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			terraformVersionErr = fmt.Errorf("%s version -json: %v %s", gcptest.TerraformBinary(), err, strings.TrimSpace(stderr.String()))
+			return
+		}
+		installedTerraform, terraformVersionErr = parseTerraformVersion(stdout.Bytes())
+	})
+	return installedTerraform, terraformVersionErr
+}
+
+// parseTerraformVersion extracts terraform_version from the output of
+// `terraform version -json`.
+func parseTerraformVersion(out []byte) (string, error) {
+	var parsed struct {
+		TerraformVersion string `json:"terraform_version"`
+	}
+	if err := json.Unmarshal(out, &parsed); err != nil {
+		return "", fmt.Errorf("parsing terraform version output: %w", err)
+	}
+	if parsed.TerraformVersion == "" {
+		return "", fmt.Errorf("terraform version output has no terraform_version: %s", out)
+	}
+	return parsed.TerraformVersion, nil
+}
+
+// versionAtLeast compares dotted numeric versions such as 1.5.7, ignoring a
+// leading "v" and any pre-release or build suffix.
+func versionAtLeast(version, minVersion string) bool {
+	have, want := versionParts(version), versionParts(minVersion)
+	for i := 0; i < len(want); i++ {
+		var h int
+		if i < len(have) {
+			h = have[i]
+		}
+		if h != want[i] {
+			return h > want[i]
+		}
+	}
+	return true
+}
+
+func versionParts(version string) []int {
+	version = strings.TrimPrefix(version, "v")
+	if i := strings.IndexAny(version, "-+"); i >= 0 {
+		version = version[:i]
+	}
+	var parts []int
+	for _, field := range strings.Split(version, ".") {
+		n, _ := strconv.Atoi(field)
+		parts = append(parts, n)
+	}
+	return parts
+}
+
+func TestParseTerraformVersion(t *testing.T) {
+	sample := []byte(`{
+  "terraform_version": "1.5.7",
+  "platform": "linux_amd64",
+  "provider_selections": {"registry.terraform.io/hashicorp/google": "4.85.0"},
+  "terraform_outdated": true
+}`)
+	version, err := parseTerraformVersion(sample)
+	assert.NoError(t, err)
+	assert.Equal(t, "1.5.7", version)
+
+	_, err = parseTerraformVersion([]byte(`Terraform v1.5.7`))
+	assert.Error(t, err)
+
+	_, err = parseTerraformVersion([]byte(`{"platform": "linux_amd64"}`))
+	assert.Error(t, err)
+}
+
+func TestVersionAtLeast(t *testing.T) {
+	assert.True(t, versionAtLeast("1.5.0", "1.5.0"))
+	assert.True(t, versionAtLeast("1.5.7", "1.5.0"))
+	assert.True(t, versionAtLeast("1.10.0", "1.5.0"), "Components compare numerically")
+	assert.True(t, versionAtLeast("v2.0", "1.5.0"))
+	assert.True(t, versionAtLeast("1.6.0-beta1", "1.5.0"))
+	assert.False(t, versionAtLeast("1.4.6", "1.5.0"))
+	assert.False(t, versionAtLeast("0.15.5", "1.5.0"))
+	assert.False(t, versionAtLeast("1.5.0-rc1", "1.5.1"))
+}
+
+func TestUniqueName(t *testing.T) {
+	first, second := uniqueName("terratest"), uniqueName("terratest")
+	assert.NotEqual(t, first, second, "Two calls should produce different names")
+
+	suffixPattern := regexp.MustCompile(fmt.Sprintf(`^terratest-[a-z0-9]{%d}$`, nameSuffixLength))
+	for _, name := range []string{first, second} {
+		assert.Regexp(t, suffixPattern, name, "Suffix should be lowercase alphanumeric and %d long", nameSuffixLength)
+	}
+
+	// The suffix also has to fit the Terraform variable's validation
+	assert.Regexp(t, `^[a-z0-9]{0,8}$`, runNameSuffix())
+	assert.Equal(t, runNameSuffix(), runNameSuffix(), "Every deployment in a run shares one suffix")
+}
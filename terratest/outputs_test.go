@@ -0,0 +1,262 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	tfjson "github.com/hashicorp/terraform-json"
+	"github.com/stretchr/testify/assert"
+
+	"hello-world-test/gcptest"
+)
+
+// requiredOutputs are the terraform outputs every deployment must populate.
+var requiredOutputs = map[string]bool{
+	"function_url":          true,
+	"service_account_email": true,
+	"function_name":         true,
+}
+
+// getOutputs fetches the named terraform outputs in one call. Outputs listed in
+// requiredOutputs must exist and be non-empty; any other output that can't be
+// read comes back as an empty string.
+func getOutputs(t *testing.T, terraformOptions *terraform.Options, keys ...string) map[string]string {
+	t.Helper()
+
+	outputs := make(map[string]string, len(keys))
+	var missing []string
+	for _, key := range keys {
+		value, err := terraform.OutputE(t, terraformOptions, key)
+		if err != nil {
+			t.Logf("Could not read output %q: %v", key, err)
+		}
+		if requiredOutputs[key] && value == "" {
+			missing = append(missing, key)
+		}
+		outputs[key] = value
+	}
+
+	if len(missing) > 0 {
+		t.Fatalf("Required terraform output(s) missing or empty: %s", strings.Join(missing, ", "))
+	}
+	return outputs
+}
+
+// getRequiredOutput returns the named terraform output, failing the test
+// immediately when it is missing or empty.
+func getRequiredOutput(t testing.TB, terraformOptions *terraform.Options, key string) string {
+	t.Helper()
+
+	value, err := terraform.OutputE(t, terraformOptions, key)
+	if err != nil {
+		t.Fatalf("Required terraform output %q could not be read: %v", key, err)
+	}
+	if value == "" {
+		t.Fatalf("Required terraform output %q is empty", key)
+	}
+	return value
+}
+
+// assertValidURL parses raw, typically a terraform output, and fails the
+// test with the raw value unless it is an absolute http or https URL with a
+// host, so a bad output isn't reported as an obscure HTTP client error later.
+func assertValidURL(t testing.TB, raw string) *url.URL {
+	t.Helper()
+
+	if err := urlProblem(raw); err != nil {
+		t.Fatalf("Terraform output %q is not a usable URL: %v", raw, err)
+	}
+	parsed, _ := url.Parse(raw)
+	testReportMeasurements.recordURL(raw)
+	return parsed
+}
+
+// urlProblem returns what is wrong with raw as an absolute http(s) URL, or
+// nil when nothing is.
+func urlProblem(raw string) error {
+	if strings.TrimSpace(raw) != raw {
+		return errors.New("it has surrounding whitespace")
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return fmt.Errorf("scheme %q is not http or https", parsed.Scheme)
+	}
+	if parsed.Host == "" {
+		return errors.New("it has no host")
+	}
+	return nil
+}
+
+func TestURLProblem(t *testing.T) {
+	assert.NoError(t, urlProblem("https://us-central1-my-project.cloudfunctions.net/hello-world-dev"))
+	assert.NoError(t, urlProblem("http://34.120.0.1"))
+
+	for _, raw := range []string{
+		"",
+		"us-central1-my-project.cloudfunctions.net/hello-world-dev",
+		"ftp://example.com",
+		"https://",
+		"https://example.com\n",
+		`"https://example.com"`,
+	} {
+		assert.Error(t, urlProblem(raw), "%q should be rejected", raw)
+	}
+}
+
+// TestOutputStability applies the dev configuration twice and expects every
+// output to come out of the second, no-op apply unchanged. An output that
+// moves between applies is fed by something non-deterministic, such as a
+// random_id or timestamp(), that consumers of the output can't rely on.
+func TestOutputStability(t *testing.T) {
+	projectID := gcptest.GetProjectID(t)
+
+	terraformOptions := buildOptions(t, devEnvironmentDir, map[string]interface{}{
+		"project_id": projectID,
+	})
+
+	if !initTerraform(t, terraformOptions) {
+		return
+	}
+	withWorkspace(t, terraformOptions, "terratest-outputs")
+	defer destroyAndVerify(t, terraformOptions)
+
+	applyTerraform(t, terraformOptions)
+	first := readState(t, terraformOptions)
+	applyTerraform(t, terraformOptions)
+	second := readState(t, terraformOptions)
+
+	for _, change := range changedOutputs(stateOutputs(first), stateOutputs(second)) {
+		t.Errorf("Output changed between two applies of the same configuration: %s", change)
+	}
+}
+
+// stateOutputs returns the root module outputs recorded in state.
+func stateOutputs(state *tfjson.State) map[string]*tfjson.StateOutput {
+	if state.Values == nil {
+		return nil
+	}
+	return state.Values.Outputs
+}
+
+// changedOutputs describes, sorted by name, every output that was added,
+// removed or given a different value between before and after. Values of
+// sensitive outputs are left out of the descriptions.
+func changedOutputs(before, after map[string]*tfjson.StateOutput) []string {
+	describe := func(output *tfjson.StateOutput) string {
+		if output.Sensitive {
+			return "(sensitive)"
+		}
+		return fmt.Sprintf("%v", output.Value)
+	}
+
+	var changes []string
+	for name, old := range before {
+		current, ok := after[name]
+		switch {
+		case !ok:
+			changes = append(changes, fmt.Sprintf("%s: removed, was %s", name, describe(old)))
+		case !reflect.DeepEqual(old.Value, current.Value):
+			changes = append(changes, fmt.Sprintf("%s: %s -> %s", name, describe(old), describe(current)))
+		}
+	}
+	for name, current := range after {
+		if _, ok := before[name]; !ok {
+			changes = append(changes, fmt.Sprintf("%s: added, now %s", name, describe(current)))
+		}
+	}
+	sort.Strings(changes)
+	return changes
+}
+
+func TestChangedOutputs(t *testing.T) {
+	before := map[string]*tfjson.StateOutput{
+		"function_url":      {Value: "https://us-central1-my-project.cloudfunctions.net/hello-world-dev"},
+		"load_balancer_url": {Value: "http://203.0.113.10"},
+		"deployed_at":       {Value: "2024-05-01T10:30:00Z"},
+		"api_key":           {Value: "first", Sensitive: true},
+		"domains":           {Value: []interface{}{"example.com"}},
+		"bucket_suffix":     {Value: "a1b2"},
+	}
+	after := map[string]*tfjson.StateOutput{
+		"function_url":      {Value: "https://us-central1-my-project.cloudfunctions.net/hello-world-dev"},
+		"load_balancer_url": {Value: "http://203.0.113.10"},
+		"deployed_at":       {Value: "2024-05-01T10:34:12Z"},
+		"api_key":           {Value: "second", Sensitive: true},
+		"domains":           {Value: []interface{}{"example.com"}},
+		"revision":          {Value: "2"},
+	}
+
+	assert.Empty(t, changedOutputs(before, before))
+	assert.Equal(t, []string{
+		"api_key: (sensitive) -> (sensitive)",
+		"bucket_suffix: removed, was a1b2",
+		"deployed_at: 2024-05-01T10:30:00Z -> 2024-05-01T10:34:12Z",
+		"revision: added, now 2",
+	}, changedOutputs(before, after))
+}
+
+// sensitiveOutputPattern matches output names that suggest a credential; such
+// outputs must be declared sensitive so their values stay out of logs.
+var sensitiveOutputPattern = regexp.MustCompile(`(?i)token|key|secret|password|credential`)
+
+// TestSensitiveOutputs plans the dev environment and fails for every output
+// whose name matches sensitiveOutputPattern but isn't marked sensitive.
+func TestSensitiveOutputs(t *testing.T) {
+	projectID := gcptest.GetProjectID(t)
+
+	terraformOptions := buildOptions(t, devEnvironmentDir, map[string]interface{}{
+		"project_id": projectID,
+	})
+
+	if !initTerraform(t, terraformOptions) {
+		return
+	}
+	withWorkspace(t, terraformOptions, "terratest-sensitive")
+	defer deleteWorkspace(t, terraformOptions)
+
+	plan := planAndShow(t, terraformOptions)
+	if plan.RawPlan.PlannedValues == nil {
+		t.Fatalf("Plan has no planned values")
+	}
+
+	for _, name := range insensitiveOutputs(plan.RawPlan.PlannedValues.Outputs, sensitiveOutputPattern) {
+		t.Errorf("Output %q looks like a credential but is not marked sensitive", name)
+	}
+}
+
+// insensitiveOutputs returns the sorted names of outputs matching pattern that
+// aren't flagged sensitive.
+func insensitiveOutputs(outputs map[string]*tfjson.StateOutput, pattern *regexp.Regexp) []string {
+	var names []string
+	for name, output := range outputs {
+		if pattern.MatchString(name) && (output == nil || !output.Sensitive) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestInsensitiveOutputs(t *testing.T) {
+	outputs := map[string]*tfjson.StateOutput{
+		"function_url":     {},
+		"api_key":          {Sensitive: true},
+		"access_token":     {},
+		"DB_PASSWORD":      {},
+		"client_secret":    {Sensitive: true},
+		"signing_key_name": nil,
+	}
+
+	assert.Equal(t, []string{"DB_PASSWORD", "access_token", "signing_key_name"},
+		insensitiveOutputs(outputs, sensitiveOutputPattern))
+}
@@ -0,0 +1,426 @@
+package test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	tfjson "github.com/hashicorp/terraform-json"
+	"github.com/stretchr/testify/assert"
+
+	"hello-world-test/gcptest"
+)
+
+func TestTfVarsFile(t *testing.T) {
+	projectID := gcptest.GetProjectID(t)
+
+	// region is only defined in the tfvars file, so seeing it in the plan proves the file was applied
+	terraformOptions := buildOptions(t, devEnvironmentDir, map[string]interface{}{
+		"project_id": projectID,
+	}, "testdata/region.tfvars")
+
+	if !initTerraform(t, terraformOptions) {
+		return
+	}
+	plan := planAndShow(t, terraformOptions)
+
+	bucketAddress := "module.hello_world_infrastructure.module.cloud_function.google_storage_bucket.function_source"
+	terraform.RequirePlannedValuesMapKeyExists(t, plan, bucketAddress)
+	location := plan.ResourcePlannedValuesMap[bucketAddress].AttributeValues["location"]
+	assert.Equal(t, "europe-west1", strings.ToLower(fmt.Sprint(location)), "Source bucket should use the region from the tfvars file")
+}
+
+// expectedManagedResources are the addresses of the managed resources a fresh
+// deployment of the dev environment creates with its default variables.
+// Update it when adding or removing resources.
+var expectedManagedResources = []string{
+	"module.hello_world_infrastructure.module.apis.google_project_service.cloud_build",
+	"module.hello_world_infrastructure.module.apis.google_project_service.cloud_functions",
+	"module.hello_world_infrastructure.module.apis.google_project_service.cloud_logging",
+	"module.hello_world_infrastructure.module.apis.google_project_service.cloud_storage",
+	"module.hello_world_infrastructure.module.apis.google_project_service.compute_engine",
+	"module.hello_world_infrastructure.module.apis.google_project_service.iam",
+	"module.hello_world_infrastructure.module.apis.time_sleep.wait_for_apis",
+	"module.hello_world_infrastructure.module.cloud_armor.google_compute_health_check.health_check",
+	"module.hello_world_infrastructure.module.cloud_armor.google_compute_security_policy.policy",
+	"module.hello_world_infrastructure.module.cloud_function.google_cloudfunctions_function.hello_world",
+	"module.hello_world_infrastructure.module.cloud_function.google_cloudfunctions_function_iam_binding.invoker",
+	"module.hello_world_infrastructure.module.cloud_function.google_logging_project_sink.function_logs",
+	"module.hello_world_infrastructure.module.cloud_function.google_service_account.function",
+	"module.hello_world_infrastructure.module.cloud_function.google_storage_bucket.function_source",
+	"module.hello_world_infrastructure.module.cloud_function.google_storage_bucket_object.function_source",
+	"module.hello_world_infrastructure.module.load_balancer[0].google_compute_backend_service.backend_service",
+	"module.hello_world_infrastructure.module.load_balancer[0].google_compute_global_forwarding_rule.http_forwarding_rule",
+	"module.hello_world_infrastructure.module.load_balancer[0].google_compute_global_forwarding_rule.https_forwarding_rule",
+	"module.hello_world_infrastructure.module.load_balancer[0].google_compute_managed_ssl_certificate.ssl_cert",
+	"module.hello_world_infrastructure.module.load_balancer[0].google_compute_region_network_endpoint_group.neg",
+	"module.hello_world_infrastructure.module.load_balancer[0].google_compute_target_http_proxy.http_proxy",
+	"module.hello_world_infrastructure.module.load_balancer[0].google_compute_target_https_proxy.https_proxy",
+	"module.hello_world_infrastructure.module.load_balancer[0].google_compute_url_map.url_map",
+}
+
+func TestTerraformPlan(t *testing.T) {
+	projectID := gcptest.GetProjectID(t)
+
+	terraformOptions := buildOptions(t, devEnvironmentDir, map[string]interface{}{
+		"project_id": projectID,
+	})
+
+	if !initTerraform(t, terraformOptions) {
+		return
+	}
+	// Plan against an empty workspace so every resource shows up as a create
+	withWorkspace(t, terraformOptions, "terratest-plan")
+	defer deleteWorkspace(t, terraformOptions)
+
+	plan := planAndShow(t, terraformOptions)
+
+	var planned []string
+	functionCreates := 0
+	for _, change := range plan.ResourceChangesMap {
+		if change.Mode != tfjson.ManagedResourceMode {
+			continue
+		}
+		planned = append(planned, change.Address)
+		if (change.Type == "google_cloudfunctions_function" || change.Type == "google_cloudfunctions2_function") &&
+			change.Change.Actions.Create() {
+			functionCreates++
+		}
+	}
+
+	if problems := addressProblems(planned, expectedManagedResources); len(problems) > 0 {
+		t.Errorf("Planned resources differ from expectedManagedResources:\n  %s", strings.Join(problems, "\n  "))
+	}
+	assert.Equal(t, 1, functionCreates, "Exactly one Cloud Function should be planned for creation")
+
+	// A plan into an empty workspace must be purely additive
+	assertCountsMatch(t, plan, len(expectedManagedResources), 0, 0)
+}
+
+// addressProblems describes every address in actual that isn't in expected
+// and every one of expected missing from actual, in address order.
+func addressProblems(actual, expected []string) []string {
+	expectedSet := map[string]bool{}
+	for _, address := range expected {
+		expectedSet[address] = true
+	}
+	actualSet := map[string]bool{}
+	var problems []string
+	for _, address := range actual {
+		actualSet[address] = true
+		if !expectedSet[address] {
+			problems = append(problems, "unexpected "+address)
+		}
+	}
+	for _, address := range expected {
+		if !actualSet[address] {
+			problems = append(problems, "missing "+address)
+		}
+	}
+	sort.Slice(problems, func(i, j int) bool {
+		return strings.SplitN(problems[i], " ", 2)[1] < strings.SplitN(problems[j], " ", 2)[1]
+	})
+	return problems
+}
+
+func TestAddressProblems(t *testing.T) {
+	assert.Empty(t, addressProblems([]string{"b.y", "a.x"}, []string{"a.x", "b.y"}), "Order doesn't matter")
+	assert.Equal(t, []string{"missing a.x", "unexpected c.z"},
+		addressProblems([]string{"b.y", "c.z"}, []string{"a.x", "b.y"}))
+}
+
+// TestPlanStability applies the dev configuration and then plans again against
+// the resulting state. Applying the same config twice must be a no-op, so any
+// pending change points at a resource that drifts on every run.
+func TestPlanStability(t *testing.T) {
+	projectID := gcptest.GetProjectID(t)
+
+	terraformOptions := buildOptions(t, devEnvironmentDir, map[string]interface{}{
+		"project_id": projectID,
+	})
+
+	if !initTerraform(t, terraformOptions) {
+		return
+	}
+	withWorkspace(t, terraformOptions, "terratest-stability")
+	defer destroyAndVerify(t, terraformOptions)
+
+	applyTerraform(t, terraformOptions)
+
+	exitCode, err := terraform.PlanExitCodeE(t, terraformOptions)
+	if err != nil {
+		t.Fatalf("Terraform plan after apply failed: %v", err)
+	}
+	switch exitCode {
+	case terraform.DefaultSuccessExitCode:
+		return
+	case terraform.TerraformPlanChangesPresentExitCode:
+		t.Errorf("Plan after a clean apply is not empty, pending changes: %s",
+			strings.Join(pendingChanges(planAndShow(t, terraformOptions)), ", "))
+	default:
+		t.Fatalf("Terraform plan after apply exited with code %d", exitCode)
+	}
+}
+
+// TestNoDrift plans the existing dev deployment, in the default workspace or
+// the one TF_WORKSPACE names, and fails when it no longer matches its state,
+// e.g. because someone changed a resource in the console. Unlike
+// TestPlanStability it neither applies nor destroys anything. It only runs
+// with CHECK_DRIFT=1:
+//
+//	CHECK_DRIFT=1 go test -v -run TestNoDrift
+func TestNoDrift(t *testing.T) {
+	if os.Getenv("CHECK_DRIFT") != "1" {
+		t.Skip("Set CHECK_DRIFT=1 to check the existing deployment for drift")
+	}
+
+	// A long-lived deployment has no per-run suffix on its names, unless its
+	// vars file says otherwise
+	vars := loadEnvVars(t, devEnvironment.name)
+	if _, ok := vars["name_suffix"]; !ok {
+		vars["name_suffix"] = ""
+	}
+	terraformOptions := buildOptions(t, devEnvironmentDir, vars)
+
+	if !initTerraform(t, terraformOptions) {
+		return
+	}
+	useEnvWorkspace(t, terraformOptions)
+	out, err := terraform.RunTerraformCommandAndGetStdoutE(t, terraformOptions, "state", "list")
+	if err != nil {
+		t.Fatalf("Could not read the state in %s: %v", terraformOptions.TerraformDir, err)
+	}
+	if len(parseStateList(out)) == 0 {
+		t.Fatalf("The state in %s is empty, there is no deployment to check for drift", terraformOptions.TerraformDir)
+	}
+
+	exitCode, err := terraform.PlanExitCodeE(t, terraformOptions)
+	if err != nil {
+		t.Fatalf("Terraform plan against the existing deployment failed: %v", err)
+	}
+	switch exitCode {
+	case terraform.DefaultSuccessExitCode:
+		t.Log("No drift: the deployment matches its state and configuration")
+	case terraform.TerraformPlanChangesPresentExitCode:
+		plan := planAndShow(t, terraformOptions)
+		if drifted := driftedResources(plan); len(drifted) > 0 {
+			t.Errorf("Resources changed outside Terraform: %s", strings.Join(drifted, ", "))
+		}
+		t.Errorf("Plan against the existing deployment is not empty, pending changes: %s",
+			strings.Join(pendingChanges(plan), ", "))
+	default:
+		t.Fatalf("Terraform plan against the existing deployment exited with code %d", exitCode)
+	}
+}
+
+// pendingChanges lists "address (actions)" for every resource the plan would
+// touch.
+func pendingChanges(plan *terraform.PlanStruct) []string {
+	var changes []string
+	for address, change := range plan.ResourceChangesMap {
+		if change.Change == nil || change.Change.Actions.NoOp() || change.Change.Actions.Read() {
+			continue
+		}
+		changes = append(changes, fmt.Sprintf("%s %v", address, change.Change.Actions))
+	}
+	sort.Strings(changes)
+	return changes
+}
+
+// driftedResources lists "address (actions)" for every resource whose real
+// state, as refreshed by the plan, no longer matches what Terraform recorded.
+func driftedResources(plan *terraform.PlanStruct) []string {
+	var drifted []string
+	for _, change := range plan.RawPlan.ResourceDrift {
+		if change.Change == nil || change.Change.Actions.NoOp() {
+			continue
+		}
+		drifted = append(drifted, fmt.Sprintf("%s %v", change.Address, change.Change.Actions))
+	}
+	sort.Strings(drifted)
+	return drifted
+}
+
+func TestDriftedResources(t *testing.T) {
+	plan := &terraform.PlanStruct{RawPlan: tfjson.Plan{ResourceDrift: []*tfjson.ResourceChange{
+		{Address: "module.load_balancer.google_compute_backend_service.backend", Change: &tfjson.Change{Actions: tfjson.Actions{tfjson.ActionUpdate}}},
+		{Address: "module.cloud_function.google_cloudfunctions_function.function", Change: &tfjson.Change{Actions: tfjson.Actions{tfjson.ActionDelete}}},
+		{Address: "module.apis.google_project_service.apis", Change: &tfjson.Change{Actions: tfjson.Actions{tfjson.ActionNoop}}},
+	}}}
+	assert.Equal(t, []string{
+		"module.cloud_function.google_cloudfunctions_function.function [delete]",
+		"module.load_balancer.google_compute_backend_service.backend [update]",
+	}, driftedResources(plan))
+
+	assert.Empty(t, driftedResources(&terraform.PlanStruct{}))
+}
+
+// anyCount tells assertPlanCounts not to check that count.
+const anyCount = -1
+
+// assertPlanCounts runs a plan with terraformOptions and checks how many
+// resources it would add, change and destroy, as in the summary line of
+// `terraform plan`; pass anyCount for a count the test doesn't care about.
+func assertPlanCounts(t *testing.T, terraformOptions *terraform.Options, add, change, destroy int) {
+	t.Helper()
+
+	assertCountsMatch(t, planAndShow(t, terraformOptions), add, change, destroy)
+}
+
+// assertCountsMatch is assertPlanCounts for a plan that has already been made.
+func assertCountsMatch(t testing.TB, plan *terraform.PlanStruct, add, change, destroy int) {
+	t.Helper()
+
+	counts := countChanges(plan)
+	for _, c := range []struct {
+		action   string
+		expected int
+		actual   int
+	}{
+		{"add", add, counts.add},
+		{"change", change, counts.change},
+		{"destroy", destroy, counts.destroy},
+	} {
+		if c.expected != anyCount {
+			assert.Equal(t, c.expected, c.actual, "Plan would %s %d resource(s), expected %d:\n  %s",
+				c.action, c.actual, c.expected, strings.Join(pendingChanges(plan), "\n  "))
+		}
+	}
+}
+
+// planCounts are the numbers in the "Plan: N to add, N to change, N to
+// destroy" summary.
+type planCounts struct {
+	add, change, destroy int
+}
+
+// countChanges tallies the managed resource changes in plan the way
+// Terraform's summary does: a replacement counts as one add and one destroy.
+func countChanges(plan *terraform.PlanStruct) planCounts {
+	var counts planCounts
+	for _, rc := range plan.ResourceChangesMap {
+		if rc.Mode != tfjson.ManagedResourceMode || rc.Change == nil {
+			continue
+		}
+		actions := rc.Change.Actions
+		switch {
+		case actions.Replace():
+			counts.add++
+			counts.destroy++
+		case actions.Create():
+			counts.add++
+		case actions.Update():
+			counts.change++
+		case actions.Delete():
+			counts.destroy++
+		}
+	}
+	return counts
+}
+
+func TestCountChanges(t *testing.T) {
+	change := func(mode tfjson.ResourceMode, actions ...tfjson.Action) *tfjson.ResourceChange {
+		return &tfjson.ResourceChange{Mode: mode, Change: &tfjson.Change{Actions: actions}}
+	}
+	plan := &terraform.PlanStruct{ResourceChangesMap: map[string]*tfjson.ResourceChange{
+		"google_storage_bucket.a":           change(tfjson.ManagedResourceMode, tfjson.ActionCreate),
+		"google_storage_bucket.b":           change(tfjson.ManagedResourceMode, tfjson.ActionCreate),
+		"google_cloudfunctions_function.f":  change(tfjson.ManagedResourceMode, tfjson.ActionUpdate),
+		"google_service_account.sa":         change(tfjson.ManagedResourceMode, tfjson.ActionDelete, tfjson.ActionCreate),
+		"google_logging_project_sink.s":     change(tfjson.ManagedResourceMode, tfjson.ActionDelete),
+		"google_compute_global_address.ip":  change(tfjson.ManagedResourceMode, tfjson.ActionNoop),
+		"data.archive_file.function_source": change(tfjson.DataResourceMode, tfjson.ActionRead),
+	}}
+
+	assert.Equal(t, planCounts{add: 3, change: 1, destroy: 2}, countChanges(plan))
+	assertCountsMatch(t, plan, 3, anyCount, 2)
+	assertCountsMatch(t, plan, anyCount, 1, anyCount)
+}
+
+// delayParamEnvVar is the function environment variable that makes it honour
+// ?delay=.
+const delayParamEnvVar = "ENABLE_DELAY_PARAM"
+
+// TestDelayParamGated plans every environment and checks that only those
+// whose thresholds allow it set delayParamEnvVar, so prd never sleeps on
+// request.
+func TestDelayParamGated(t *testing.T) {
+	gcptest.GetProjectID(t)
+
+	for _, env := range testEnvironments {
+		t.Run(env.name, func(t *testing.T) {
+			terraformOptions := buildOptions(t, env.dir, loadEnvVars(t, env.name))
+
+			if !initTerraform(t, terraformOptions) {
+				return
+			}
+			withWorkspace(t, terraformOptions, "terratest-delay-"+env.name)
+			defer deleteWorkspace(t, terraformOptions)
+
+			variables := plannedFunctionEnvironment(t, planAndShow(t, terraformOptions))
+			_, set := variables[delayParamEnvVar]
+			assert.Equal(t, thresholdsFor(env.name).delayParam, set,
+				"%s should be set in %s only if it honours ?delay=", delayParamEnvVar, env.name)
+		})
+	}
+}
+
+// plannedFunctionEnvironment returns the environment_variables planned for
+// the Cloud Function in plan, failing the test when none is planned.
+func plannedFunctionEnvironment(t *testing.T, plan *terraform.PlanStruct) map[string]interface{} {
+	t.Helper()
+
+	for _, change := range plan.ResourceChangesMap {
+		if change.Type != "google_cloudfunctions_function" || change.Change == nil {
+			continue
+		}
+		after, _ := change.Change.After.(map[string]interface{})
+		variables, _ := after["environment_variables"].(map[string]interface{})
+		return variables
+	}
+	t.Fatalf("Plan has no google_cloudfunctions_function")
+	return nil
+}
+
+func TestPlannedFunctionEnvironment(t *testing.T) {
+	plan := &terraform.PlanStruct{ResourceChangesMap: map[string]*tfjson.ResourceChange{
+		"module.x.google_storage_bucket.source": {Type: "google_storage_bucket", Change: &tfjson.Change{}},
+		"module.x.google_cloudfunctions_function.hello_world": {
+			Type: "google_cloudfunctions_function",
+			Change: &tfjson.Change{After: map[string]interface{}{
+				"environment_variables": map[string]interface{}{"ENV": "dev", delayParamEnvVar: "1"},
+			}},
+		},
+	}}
+
+	assert.Equal(t, map[string]interface{}{"ENV": "dev", delayParamEnvVar: "1"}, plannedFunctionEnvironment(t, plan))
+}
+
+// planAndShow runs terraform plan into a temporary plan file and returns the
+// parsed plan. The plan file is detached from terraformOptions afterwards so a
+// later apply doesn't pick it up.
+func planAndShow(t *testing.T, terraformOptions *terraform.Options) *terraform.PlanStruct {
+	t.Helper()
+
+	terraformOptions.PlanFilePath = filepath.Join(t.TempDir(), "plan.out")
+	defer func() { terraformOptions.PlanFilePath = "" }()
+
+	terraform.Plan(t, terraformOptions)
+	return terraform.ShowWithStruct(t, terraformOptions)
+}
+
+// TestMissingProjectIDFails guards the contract that project_id is required:
+// planning without it must fail up front and name the variable, rather than
+// surfacing as a provider error halfway through an apply.
+func TestMissingProjectIDFails(t *testing.T) {
+	terraformOptions := buildOptions(t, devEnvironmentDir, map[string]interface{}{})
+
+	_, err := terraform.InitAndPlanE(t, terraformOptions)
+	if assert.Error(t, err, "Plan without project_id should fail") {
+		assert.Contains(t, err.Error(), `"project_id"`, "Error should name the missing variable")
+	}
+}
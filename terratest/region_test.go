@@ -0,0 +1,78 @@
+package test
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+
+	"hello-world-test/gcptest"
+)
+
+// applyTerraformInRegion is applyTerraform for tests that deploy to a region
+// of their choosing: it also skips when the project can't use region.
+func applyTerraformInRegion(t testing.TB, terraformOptions *terraform.Options, region string) {
+	t.Helper()
+
+	err := runApply(t, terraformOptions)
+	if err != nil && containsAny(err.Error(), regionUnavailableErrors) {
+		t.Skipf("Skipping test: region %s is not available to the project: %v", region, err)
+	}
+	if err != nil {
+		reportApplyError(t, terraformOptions, err)
+	}
+}
+
+// regionUnavailableErrors are substrings GCP returns when a region can't be
+// used by the project. Only applyTerraformInRegion checks them: anywhere else
+// a bad region is a configuration error.
+var regionUnavailableErrors = []string{
+	"Invalid location",
+	"is not available in region",
+	"Location is not supported",
+}
+
+func TestRegionUnavailableErrors(t *testing.T) {
+	assert.True(t, containsAny(`Error 400: Invalid location: moon-central1`, regionUnavailableErrors))
+	assert.True(t, containsAny(`Error 400: Cloud Functions is not available in region moon-central1`, regionUnavailableErrors))
+	assert.False(t, containsAny(`Error 403: Location projects/p/locations/us-central1 is not found or access is unauthorized`, regionUnavailableErrors),
+		"The generic not-found-or-unauthorized error isn't specific to regions")
+}
+
+// multiRegionRegions are the regions TestMultiRegion deploys the function to.
+var multiRegionRegions = []string{"us-central1", "europe-west1"}
+
+func TestMultiRegion(t *testing.T) {
+	projectID := gcptest.GetProjectID(t)
+
+	// Regions run one after another: bucket and load balancer names are shared
+	// per environment, so two regions can't be deployed side by side
+	for _, region := range multiRegionRegions {
+		t.Run(region, func(t *testing.T) {
+			terraformOptions := buildOptions(t, devEnvironmentDir, map[string]interface{}{
+				"project_id": projectID,
+				"region":     region,
+			})
+
+			if !initTerraform(t, terraformOptions) {
+				return
+			}
+			withWorkspace(t, terraformOptions, "terratest-"+region)
+
+			defer destroyAndVerify(t, terraformOptions)
+
+			applyTerraformInRegion(t, terraformOptions, region)
+
+			// Gen1 function URLs look like https://<region>-<project>.cloudfunctions.net/<name>
+			functionURL := checkFunctionURL(t, terraformOptions, devEnvironment)
+			parsed, err := url.Parse(functionURL)
+			if err != nil {
+				t.Fatalf("Could not parse function URL %q: %v", functionURL, err)
+			}
+			assert.True(t, strings.HasPrefix(parsed.Hostname(), region+"-"),
+				"Function URL %s should point at region %s", functionURL, region)
+		})
+	}
+}
@@ -0,0 +1,292 @@
+package test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"hello-world-test/gcptest"
+)
+
+// testReportEnvVar is where a TestReport of the run is written, for release
+// automation to gate on.
+const testReportEnvVar = "TEST_REPORT_JSON"
+
+// testReportMeasurementsEnvVar is the file the child test process started by
+// runWithReports saves its measurements to.
+const testReportMeasurementsEnvVar = "TEST_REPORT_MEASUREMENTS"
+
+// TestReport summarizes a test run: where it ran, what it checked, the
+// numbers it measured and how every test ended. Environment is set when
+// every test that deployed one used the same environment, and is empty when
+// the run spans several; each TestResult has its own.
+type TestReport struct {
+	Environment      string    `json:"environment,omitempty"`
+	Workspace        string    `json:"workspace,omitempty"`
+	ProjectID        string    `json:"project_id,omitempty"`
+	TerraformVersion string    `json:"terraform_version,omitempty"`
+	StartedAt        time.Time `json:"started_at"`
+	FinishedAt       time.Time `json:"finished_at"`
+	Passed           bool      `json:"passed"`
+	reportMeasurements
+	Tests []TestResult `json:"tests"`
+}
+
+// reportMeasurements is what the tests record while they run.
+type reportMeasurements struct {
+	URLs         []string            `json:"urls,omitempty"`
+	Certificates []CertificateReport `json:"certificates,omitempty"`
+	Latencies    []LatencyReport     `json:"latencies,omitempty"`
+}
+
+// CertificateReport is a TLS certificate assertValidTLS inspected.
+type CertificateReport struct {
+	Host     string    `json:"host"`
+	NotAfter time.Time `json:"not_after"`
+}
+
+// LatencyReport is one percentile measured by measureLatencyPercentiles.
+type LatencyReport struct {
+	URL          string  `json:"url"`
+	Percentile   string  `json:"percentile"`
+	Milliseconds float64 `json:"milliseconds"`
+}
+
+// TestResult is how one test or subtest ended: PASS, FAIL or SKIP, and the
+// environment it ran Terraform against, if any.
+type TestResult struct {
+	Name        string  `json:"name"`
+	Environment string  `json:"environment,omitempty"`
+	Status      string  `json:"status"`
+	Seconds     float64 `json:"seconds"`
+	SkipReason  string  `json:"skip_reason,omitempty"`
+	Output      string  `json:"output,omitempty"`
+}
+
+// reportRecorder collects measurements from concurrently running tests.
+type reportRecorder struct {
+	mu           sync.Mutex
+	measurements reportMeasurements
+	// environments maps a test name to the environment it ran against
+	environments map[string]string
+}
+
+// savedMeasurements is what a reportRecorder writes for writeTestReport.
+type savedMeasurements struct {
+	reportMeasurements
+	Environments map[string]string `json:"environments,omitempty"`
+}
+
+// testReportMeasurements is where the tests of this process record into.
+var testReportMeasurements = &reportRecorder{}
+
+// recordURL notes that url was checked; each URL is recorded once.
+func (r *reportRecorder) recordURL(url string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, seen := range r.measurements.URLs {
+		if seen == url {
+			return
+		}
+	}
+	r.measurements.URLs = append(r.measurements.URLs, url)
+}
+
+// recordEnvironment notes that the test named test runs against env.
+func (r *reportRecorder) recordEnvironment(test, env string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.environments == nil {
+		r.environments = map[string]string{}
+	}
+	r.environments[test] = env
+}
+
+func (r *reportRecorder) recordCertificate(host string, notAfter time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.measurements.Certificates = append(r.measurements.Certificates, CertificateReport{Host: host, NotAfter: notAfter})
+}
+
+func (r *reportRecorder) recordLatency(url, percentile string, latency time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.measurements.Latencies = append(r.measurements.Latencies, LatencyReport{
+		URL:          url,
+		Percentile:   percentile,
+		Milliseconds: float64(latency) / float64(time.Millisecond),
+	})
+}
+
+// write saves the recorded measurements to path as JSON.
+func (r *reportRecorder) write(path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data, err := json.Marshal(savedMeasurements{reportMeasurements: r.measurements, Environments: r.environments})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// writeTestReport writes the TestReport of a run that started at started and
+// ended in cases to path, with the measurements the tests saved to
+// measurementsPath. The run passed when no test failed.
+func writeTestReport(path, measurementsPath string, started time.Time, cases []*junitCase) error {
+	var saved savedMeasurements
+	if data, err := os.ReadFile(measurementsPath); err == nil && len(data) > 0 {
+		if err := json.Unmarshal(data, &saved); err != nil {
+			return err
+		}
+	}
+	report := buildTestReport(started, time.Now(), cases, saved.Environments)
+	report.reportMeasurements = saved.reportMeasurements
+	if version, err := detectTerraformVersion(); err == nil {
+		report.TerraformVersion = version
+	}
+	report.Workspace = gcptest.EnvWorkspace()
+	report.ProjectID, _ = gcptest.LookupProjectID()
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// buildTestReport turns cases into a TestReport. environments maps test
+// names to the environment each ran against; a subtest without an entry
+// inherits its parent's.
+func buildTestReport(started, finished time.Time, cases []*junitCase, environments map[string]string) TestReport {
+	report := TestReport{
+		StartedAt:  started,
+		FinishedAt: finished,
+		Passed:     true,
+		Tests:      []TestResult{},
+	}
+	seen := map[string]bool{}
+	for _, c := range cases {
+		result := TestResult{Name: c.Name, Environment: testEnvironmentOf(c.Name, environments), Status: c.Status, Seconds: c.Seconds}
+		if result.Environment != "" {
+			seen[result.Environment] = true
+			report.Environment = result.Environment
+		}
+		switch c.Status {
+		case "FAIL":
+			report.Passed = false
+			result.Output = strings.TrimSpace(strings.Join(c.Output, "\n"))
+		case "SKIP":
+			result.SkipReason = c.skipMessage()
+		}
+		report.Tests = append(report.Tests, result)
+	}
+	if len(seen) > 1 {
+		report.Environment = ""
+	}
+	return report
+}
+
+// testEnvironmentOf returns the environment recorded for the test named name
+// or, failing that, for the closest parent test that has one.
+func testEnvironmentOf(name string, environments map[string]string) string {
+	for {
+		if env, ok := environments[name]; ok {
+			return env
+		}
+		i := strings.LastIndex(name, "/")
+		if i < 0 {
+			return ""
+		}
+		name = name[:i]
+	}
+}
+
+func TestWriteTestReport(t *testing.T) {
+	output := `=== RUN   TestHelloWorld
+    hello_world_test.go:70: Function URL negotiated HTTP/1.1
+--- PASS: TestHelloWorld (212.40s)
+=== RUN   TestCostBudget
+    cost_test.go:67: Cost gating unavailable: infracost is not installed
+--- SKIP: TestCostBudget (0.01s)
+=== RUN   TestFunctionTimeout
+    hello_world_test.go:1250: Request was aborted before the function timeout
+--- FAIL: TestFunctionTimeout (61.20s)
+`
+	cases := parseTestOutput(strings.NewReader(output))
+
+	recorder := &reportRecorder{}
+	recorder.recordEnvironment("TestHelloWorld", "dev")
+	recorder.recordEnvironment("TestFunctionTimeout", "dev")
+	recorder.recordURL("https://us-central1-my-project.cloudfunctions.net/hello-world-dev")
+	recorder.recordURL("https://us-central1-my-project.cloudfunctions.net/hello-world-dev")
+	notAfter := time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC)
+	recorder.recordCertificate("us-central1-my-project.cloudfunctions.net", notAfter)
+	recorder.recordLatency("https://us-central1-my-project.cloudfunctions.net/hello-world-dev", "p99", 420*time.Millisecond)
+
+	dir := t.TempDir()
+	measurementsPath := filepath.Join(dir, "measurements.json")
+	if err := recorder.write(measurementsPath); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "report.json")
+	started := time.Now().Add(-5 * time.Minute)
+	if err := writeTestReport(path, measurementsPath, started, cases); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report TestReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("Report is not valid JSON: %v\n%s", err, data)
+	}
+
+	assert.Equal(t, "dev", report.Environment)
+	assert.False(t, report.Passed, "A failed test fails the run")
+	assert.Equal(t, []string{"https://us-central1-my-project.cloudfunctions.net/hello-world-dev"}, report.URLs)
+	assert.Equal(t, []CertificateReport{{Host: "us-central1-my-project.cloudfunctions.net", NotAfter: notAfter}}, report.Certificates)
+	assert.Equal(t, []LatencyReport{{URL: "https://us-central1-my-project.cloudfunctions.net/hello-world-dev", Percentile: "p99", Milliseconds: 420}}, report.Latencies)
+	if assert.Len(t, report.Tests, 3) {
+		assert.Equal(t, TestResult{Name: "TestHelloWorld", Environment: "dev", Status: "PASS", Seconds: 212.4}, report.Tests[0])
+		assert.Equal(t, "Cost gating unavailable: infracost is not installed", report.Tests[1].SkipReason)
+		assert.Empty(t, report.Tests[1].Environment, "A test that deployed nothing has no environment")
+		assert.Contains(t, report.Tests[2].Output, "aborted before the function timeout")
+	}
+
+	assert.Contains(t, string(data), `"skip_reason": "Cost gating unavailable`, "Field names should be stable snake_case")
+}
+
+func TestBuildTestReportEnvironments(t *testing.T) {
+	cases := []*junitCase{
+		{Name: "TestAllEnvironments", Status: "PASS"},
+		{Name: "TestAllEnvironments/dev", Status: "PASS"},
+		{Name: "TestAllEnvironments/prd", Status: "PASS"},
+		{Name: "TestAllEnvironments/prd/Ingress", Status: "PASS"},
+		{Name: "TestTerraformValidation", Status: "PASS"},
+	}
+	now := time.Now()
+
+	report := buildTestReport(now, now, cases, map[string]string{
+		"TestAllEnvironments/dev": "dev",
+		"TestAllEnvironments/prd": "prd",
+	})
+	assert.Empty(t, report.Environment, "A run over several environments has no single one")
+	var environments []string
+	for _, result := range report.Tests {
+		environments = append(environments, result.Environment)
+	}
+	assert.Equal(t, []string{"", "dev", "prd", "prd", ""}, environments)
+
+	report = buildTestReport(now, now, cases, map[string]string{"TestAllEnvironments/prd": "prd"})
+	assert.Equal(t, "prd", report.Environment)
+
+	assert.Empty(t, buildTestReport(now, now, cases, nil).Environment)
+}
@@ -0,0 +1,122 @@
+package test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"hello-world-test/gcptest"
+)
+
+// envVarsFile is the file, relative to an environment's directory, that holds
+// the environment's test inputs. Terraform auto-loads *.auto.tfvars.json too,
+// so only put values there that are also safe for manual applies.
+const envVarsFile = "test.auto.tfvars.json"
+
+// loadEnvVars returns the terraform vars for testing environment env: the
+// defaults every test passes, overridden by ../environments/<env>/test.auto.tfvars.json
+// when that file exists.
+func loadEnvVars(t *testing.T, env string) map[string]interface{} {
+	t.Helper()
+
+	defaults := map[string]interface{}{
+		"project_id": gcptest.GetProjectID(t),
+	}
+	return loadVarsFile(t, filepath.Join("../environments", env, envVarsFile), defaults)
+}
+
+// loadVarsFile merges the JSON object in path over defaults. A missing file
+// leaves the defaults untouched; malformed JSON fails the test.
+func loadVarsFile(t *testing.T, path string, defaults map[string]interface{}) map[string]interface{} {
+	t.Helper()
+
+	vars := make(map[string]interface{}, len(defaults))
+	for key, value := range defaults {
+		vars[key] = value
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return vars
+	}
+	if err != nil {
+		t.Fatalf("Could not read %s: %v", path, err)
+	}
+
+	var fileVars map[string]interface{}
+	if err := json.Unmarshal(data, &fileVars); err != nil {
+		t.Fatalf("%s is not a valid JSON object of terraform variables: %v", path, err)
+	}
+	for key, value := range fileVars {
+		vars[key] = value
+	}
+	return vars
+}
+
+func TestLoadVarsFile(t *testing.T) {
+	dir := t.TempDir()
+	defaults := map[string]interface{}{"project_id": "my-project", "region": "us-central1"}
+
+	assert.Equal(t, defaults, loadVarsFile(t, filepath.Join(dir, "missing.json"), defaults))
+
+	path := filepath.Join(dir, envVarsFile)
+	if err := os.WriteFile(path, []byte(`{"region": "europe-west1", "allowed_origins": ["https://app.example.com"]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, map[string]interface{}{
+		"project_id":      "my-project",
+		"region":          "europe-west1",
+		"allowed_origins": []interface{}{"https://app.example.com"},
+	}, loadVarsFile(t, path, defaults))
+	assert.Equal(t, "us-central1", defaults["region"], "Defaults should not be modified")
+}
+
+// envInt reads an integer setting from the environment, returning def when unset.
+func envInt(t *testing.T, name string, def int) int {
+	t.Helper()
+
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		t.Fatalf("Invalid %s %q: %v", name, raw, err)
+	}
+	return value
+}
+
+// envFloat reads a float setting from the environment, returning def when unset.
+func envFloat(t *testing.T, name string, def float64) float64 {
+	t.Helper()
+
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def
+	}
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		t.Fatalf("Invalid %s %q: %v", name, raw, err)
+	}
+	return value
+}
+
+// envDuration reads a Go duration setting from the environment, returning def when unset.
+func envDuration(t testing.TB, name string, def time.Duration) time.Duration {
+	t.Helper()
+
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def
+	}
+	value, err := time.ParseDuration(raw)
+	if err != nil {
+		t.Fatalf("Invalid %s %q: %v", name, raw, err)
+	}
+	return value
+}
@@ -0,0 +1,99 @@
+package test
+
+import (
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	http_helper "github.com/gruntwork-io/terratest/modules/http-helper"
+	"github.com/stretchr/testify/assert"
+
+	"hello-world-test/gcptest"
+)
+
+// TestLocalFunction runs the HTTP-level checks against a Functions Framework
+// instance at FUNCTION_LOCAL_URL, e.g. one started with
+// `functions-framework --source modules/cloud_function/main.py --target hello_world`,
+// for quick feedback on function changes without deploying.
+func TestLocalFunction(t *testing.T) {
+	functionURL := os.Getenv(localFunctionURLEnvVar)
+	if functionURL == "" {
+		t.Skipf("Skipping local function test: set %s to a running Functions Framework URL", localFunctionURLEnvVar)
+	}
+
+	gcptest.AssertFunctionResponse(t, functionURL, gcptest.ResponseFormatText, nil)
+	assertSecurityHeaders(t, functionURL, defaultSecurityHeaders, nil)
+	assertHealthEndpoint(t, functionURL, nil)
+
+	// Without ALLOWED_ORIGINS the function allows every origin
+	gcptest.AssertCORS(t, functionURL, "https://app.example.com", []string{http.MethodGet, http.MethodPost})
+
+	statusCode, body := http_helper.HTTPDo(t, http.MethodGet, functionURL, nil,
+		map[string]string{featureFlagHeader: "beta"}, nil)
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Contains(t, body, "(beta)", "The beta feature flag should change the greeting")
+
+	assertAllowedMethods(t, functionURL, functionAllowedMethods)
+}
+
+// TestSmoke runs the HTTP-level checks, on content, headers, TLS, CORS and
+// latency, against the deployed URL in SMOKE_URL without touching Terraform,
+// so a release gate can point them at a canary or production:
+//
+//	SMOKE_URL=https://example.com/hello go test -v -run TestSmoke
+//
+// SMOKE_CORS_ORIGIN is an origin the deployment allows; without it the CORS
+// check is skipped, since what a deployment allows varies. The response SLA
+// is that of the environment in SMOKE_ENV, dev by default; RESPONSE_SLA_MS,
+// LATENCY_SAMPLES and LATENCY_P99_MS work as in TestHelloWorld. Each check is
+// a subtest, so one failing doesn't hide the others.
+func TestSmoke(t *testing.T) {
+	smokeURL := os.Getenv(smokeURLEnvVar)
+	if smokeURL == "" {
+		t.Skipf("Skipping smoke test: set %s to a deployed URL", smokeURLEnvVar)
+	}
+	skipIfOffline(t)
+	parsed := assertValidURL(t, smokeURL)
+
+	if err := gcptest.WaitForHealthy(t, smokeURL, time.Minute); err != nil {
+		t.Fatalf("%s is not answering: %v", smokeURL, err)
+	}
+
+	t.Run("Content", func(t *testing.T) {
+		gcptest.AssertFunctionResponse(t, smokeURL, gcptest.ResponseFormatText, nil)
+		gcptest.AssertResponseSizeUnder(t, smokeURL, maxResponseBytes, nil)
+		gcptest.AssertGzipSupported(t, smokeURL, "Hello")
+		assertHealthEndpoint(t, smokeURL, nil)
+	})
+	t.Run("Headers", func(t *testing.T) {
+		assertSecurityHeaders(t, smokeURL, defaultSecurityHeaders, nil)
+		assertAllowedMethods(t, smokeURL, functionAllowedMethods)
+	})
+	t.Run("TLS", func(t *testing.T) {
+		if parsed.Scheme != "https" {
+			t.Skipf("%s is served over plain HTTP", smokeURL)
+		}
+		assertValidTLS(t, smokeURL)
+	})
+	t.Run("CORS", func(t *testing.T) {
+		origin := os.Getenv("SMOKE_CORS_ORIGIN")
+		if origin == "" {
+			t.Skip("Set SMOKE_CORS_ORIGIN to an allowed origin to check CORS")
+		}
+		gcptest.AssertCORS(t, smokeURL, origin, []string{http.MethodGet, http.MethodPost})
+	})
+	t.Run("Latency", func(t *testing.T) {
+		gcptest.AssertResponseTimeUnder(t, smokeURL, responseSLA(t, smokeEnvironment()), nil)
+		assertLatencyPercentiles(t, smokeURL)
+	})
+}
+
+// smokeEnvironment returns the environment TestSmoke holds SMOKE_URL to the
+// thresholds of: SMOKE_ENV, or dev when it isn't set.
+func smokeEnvironment() string {
+	if env := os.Getenv("SMOKE_ENV"); env != "" {
+		return env
+	}
+	return devEnvironment.name
+}
@@ -0,0 +1,85 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	test_structure "github.com/gruntwork-io/terratest/modules/test-structure"
+
+	"hello-world-test/gcptest"
+)
+
+// stagedWorkingDir is where TestStagedDeployment keeps the options of the
+// shared deployment between stages, under .test-data/.
+const stagedWorkingDir = "."
+
+// TestStagedDeployment deploys the dev environment once and runs several
+// checks against it, in three stages CI can split across jobs by setting
+// SKIP_setup, SKIP_validate or SKIP_teardown:
+//
+//   - setup applies and saves the terraform options to .test-data/
+//   - validate loads them and runs each check as a subtest
+//   - teardown destroys the deployment and removes .test-data/
+func TestStagedDeployment(t *testing.T) {
+	projectID := gcptest.GetProjectID(t)
+
+	defer test_structure.RunTestStage(t, "teardown", func() {
+		// Nothing to tear down when setup skipped before saving anything
+		if !test_structure.IsTestDataPresent(t, test_structure.FormatTestDataPath(stagedWorkingDir, "TerraformOptions.json")) {
+			return
+		}
+		terraformOptions := test_structure.LoadTerraformOptions(t, stagedWorkingDir)
+		// Keep the saved options too, so SKIP_setup reruns can reach the deployment
+		if preserveOnFailure(t, terraformOptions) {
+			return
+		}
+		destroyAndVerify(t, terraformOptions)
+		test_structure.CleanupTestDataFolder(t, stagedWorkingDir)
+	})
+
+	test_structure.RunTestStage(t, "setup", func() {
+		terraformOptions := buildOptions(t, devEnvironmentDir, map[string]interface{}{
+			"project_id": projectID,
+		})
+		if !initTerraform(t, terraformOptions) {
+			t.Fatal("Terraform init failed")
+		}
+		withWorkspace(t, terraformOptions, "terratest-staged")
+
+		// Saved before apply so teardown can clean up a partial deployment
+		test_structure.SaveTerraformOptions(t, stagedWorkingDir, terraformOptions)
+		applyTerraform(t, terraformOptions)
+	})
+
+	test_structure.RunTestStage(t, "validate", func() {
+		terraformOptions := test_structure.LoadTerraformOptions(t, stagedWorkingDir)
+		for _, check := range stagedChecks {
+			t.Run(check.name, func(t *testing.T) {
+				check.run(t, projectID, terraformOptions)
+			})
+		}
+	})
+}
+
+// stagedChecks run against the shared deployment in TestStagedDeployment's
+// validate stage.
+var stagedChecks = []struct {
+	name string
+	run  func(t *testing.T, projectID string, terraformOptions *terraform.Options)
+}{
+	{"FunctionResponds", func(t *testing.T, projectID string, terraformOptions *terraform.Options) {
+		checkFunctionURL(t, terraformOptions, devEnvironment)
+	}},
+	{"ServiceAccountRoles", func(t *testing.T, projectID string, terraformOptions *terraform.Options) {
+		serviceAccountEmail := getRequiredOutput(t, terraformOptions, "service_account_email")
+		gcptest.AssertServiceAccountRoles(t, projectID, serviceAccountEmail, forbiddenServiceAccountRoles)
+	}},
+	{"SourceBucketLifecycle", func(t *testing.T, projectID string, terraformOptions *terraform.Options) {
+		bucket := getRequiredOutput(t, terraformOptions, "source_bucket_name")
+		gcptest.AssertBucketLifecycle(t, projectID, bucket, maxSourceRetentionDays)
+		assertSourceBucketRegion(t, projectID, bucket, readState(t, terraformOptions))
+	}},
+	{"FunctionEnvVars", func(t *testing.T, projectID string, terraformOptions *terraform.Options) {
+		assertFunctionEnvVars(t, readState(t, terraformOptions), map[string]string{"ENV": "dev"}, forbiddenFunctionEnvVars)
+	}},
+}
@@ -0,0 +1,263 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/hashicorp/hcl/v2"
+	tfjson "github.com/hashicorp/terraform-json"
+	"github.com/stretchr/testify/assert"
+)
+
+// localStateAllowedEnvVar lists, comma separated, the environments that may
+// keep their state locally, overriding defaultLocalStateAllowed.
+const localStateAllowedEnvVar = "LOCAL_STATE_ALLOWED"
+
+// defaultLocalStateAllowed are the environments whose state may live on the
+// machine running Terraform; every other one must use a remote backend.
+var defaultLocalStateAllowed = []string{"dev"}
+
+// backendConfig is the Terraform backend an environment stores its state in.
+type backendConfig struct {
+	Type string
+	// Config holds the backend's string settings, e.g. bucket and prefix
+	Config map[string]string
+}
+
+// assertRemoteBackend fails the test when the environment in dir, named
+// after the directory, keeps its state in the local backend without being in
+// the LOCAL_STATE_ALLOWED allowlist.
+func assertRemoteBackend(t testing.TB, dir string) {
+	t.Helper()
+
+	backend, err := readBackend(dir)
+	if err != nil {
+		t.Fatalf("Could not read the backend of %s: %v", dir, err)
+	}
+
+	env := filepath.Base(dir)
+	if backend.Type != "local" {
+		t.Logf("%s stores its state in the %s backend", env, backend.Type)
+		return
+	}
+	for _, allowed := range localStateAllowed() {
+		if allowed == env {
+			t.Logf("%s stores its state locally, which %s allows", env, localStateAllowedEnvVar)
+			return
+		}
+	}
+	t.Errorf("%s stores its state locally; it needs a remote backend such as gcs, or an entry in %s",
+		env, localStateAllowedEnvVar)
+}
+
+// localStateAllowed returns LOCAL_STATE_ALLOWED as a list, or
+// defaultLocalStateAllowed when it isn't set.
+func localStateAllowed() []string {
+	value, ok := os.LookupEnv(localStateAllowedEnvVar)
+	if !ok {
+		return defaultLocalStateAllowed
+	}
+	var envs []string
+	for _, env := range strings.Split(value, ",") {
+		if env = strings.TrimSpace(env); env != "" {
+			envs = append(envs, env)
+		}
+	}
+	return envs
+}
+
+// readBackend returns the backend of the Terraform root in dir. The one
+// recorded by the last terraform init, in .terraform/terraform.tfstate, wins;
+// otherwise the first backend block in dir's .tf files is used, and without
+// one Terraform falls back to the local backend.
+func readBackend(dir string) (backendConfig, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ".terraform", "terraform.tfstate"))
+	if err == nil {
+		var initialized struct {
+			Backend *struct {
+				Type   string                 `json:"type"`
+				Config map[string]interface{} `json:"config"`
+			} `json:"backend"`
+		}
+		if err := json.Unmarshal(data, &initialized); err != nil {
+			return backendConfig{}, fmt.Errorf("parsing .terraform/terraform.tfstate: %w", err)
+		}
+		if initialized.Backend != nil {
+			config := map[string]string{}
+			for name, value := range initialized.Backend.Config {
+				if s, ok := value.(string); ok {
+					config[name] = s
+				}
+			}
+			return backendConfig{Type: initialized.Backend.Type, Config: config}, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return backendConfig{}, err
+	}
+
+	bodies, err := parseTerraformFiles(dir)
+	if err != nil {
+		return backendConfig{}, err
+	}
+	for _, body := range bodies {
+		if backend, ok := backendBlock(body); ok {
+			return backend, nil
+		}
+	}
+	return backendConfig{Type: "local", Config: map[string]string{}}, nil
+}
+
+// backendBlock finds the backend block nested in a terraform block of body.
+// Only literal string settings end up in Config.
+func backendBlock(body hcl.Body) (backendConfig, bool) {
+	content, _, _ := body.PartialContent(&hcl.BodySchema{
+		Blocks: []hcl.BlockHeaderSchema{{Type: "terraform"}},
+	})
+	for _, terraformBlock := range content.Blocks {
+		inner, _, _ := terraformBlock.Body.PartialContent(&hcl.BodySchema{
+			Blocks: []hcl.BlockHeaderSchema{{Type: "backend", LabelNames: []string{"type"}}},
+		})
+		for _, block := range inner.Blocks {
+			config := map[string]string{}
+			attributes, _ := block.Body.JustAttributes()
+			for name := range attributes {
+				if value := attributeString(attributes, name); value != "" {
+					config[name] = value
+				}
+			}
+			return backendConfig{Type: block.Labels[0], Config: config}, true
+		}
+	}
+	return backendConfig{}, false
+}
+
+// TestRemoteStateBackends checks every environment's backend against the
+// LOCAL_STATE_ALLOWED allowlist. It only reads files, so it needs neither
+// credentials nor the Terraform CLI.
+func TestRemoteStateBackends(t *testing.T) {
+	for _, env := range testEnvironments {
+		t.Run(env.name, func(t *testing.T) {
+			if _, err := os.Stat(env.dir); os.IsNotExist(err) {
+				t.Skipf("Skipping environment %s: directory %s does not exist", env.name, env.dir)
+			}
+			assertRemoteBackend(t, env.dir)
+		})
+	}
+}
+
+func TestReadBackend(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(path, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeFile(filepath.Join(dir, "main.tf"), `variable "project_id" {}`)
+	backend, err := readBackend(dir)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "local", backend.Type, "No backend block means local state")
+	}
+
+	writeFile(filepath.Join(dir, "backend.tf"), `terraform {
+  backend "gcs" {
+    bucket = "state-bucket"
+    prefix = "prd/terraform/state"
+  }
+}`)
+	backend, err = readBackend(dir)
+	if assert.NoError(t, err) {
+		assert.Equal(t, backendConfig{Type: "gcs", Config: map[string]string{
+			"bucket": "state-bucket",
+			"prefix": "prd/terraform/state",
+		}}, backend)
+	}
+
+	// What terraform init recorded takes precedence over the configuration
+	writeFile(filepath.Join(dir, ".terraform", "terraform.tfstate"),
+		`{"version": 3, "backend": {"type": "local", "config": {"path": "terraform.tfstate", "workspace_dir": null}}}`)
+	backend, err = readBackend(dir)
+	if assert.NoError(t, err) {
+		assert.Equal(t, backendConfig{Type: "local", Config: map[string]string{"path": "terraform.tfstate"}}, backend)
+	}
+}
+
+func TestLocalStateAllowed(t *testing.T) {
+	t.Setenv(localStateAllowedEnvVar, "dev, test,")
+	assert.Equal(t, []string{"dev", "test"}, localStateAllowed())
+
+	t.Setenv(localStateAllowedEnvVar, "")
+	assert.Empty(t, localStateAllowed(), "An empty allowlist requires remote state everywhere")
+}
+
+// stateWithResources returns a state whose root module holds resources, all
+// managed, as a fixture for the functions that inspect state.
+func stateWithResources(resources ...*tfjson.StateResource) *tfjson.State {
+	for _, resource := range resources {
+		resource.Mode = tfjson.ManagedResourceMode
+	}
+	return &tfjson.State{Values: &tfjson.StateValues{RootModule: &tfjson.StateModule{Resources: resources}}}
+}
+
+// readState returns the parsed state of terraformOptions' current workspace,
+// for assertions on the attributes of deployed resources.
+func readState(t testing.TB, terraformOptions *terraform.Options) *tfjson.State {
+	t.Helper()
+
+	out, err := terraform.ShowE(t, terraformOptions)
+	if err != nil {
+		t.Fatalf("Could not show terraform state: %v", err)
+	}
+
+	var state tfjson.State
+	if err := json.Unmarshal([]byte(out), &state); err != nil {
+		t.Fatalf("Could not parse terraform state: %v", err)
+	}
+	return &state
+}
+
+// stateResources returns every managed resource of resourceType in state,
+// including those nested in child modules.
+func stateResources(state *tfjson.State, resourceType string) []*tfjson.StateResource {
+	var found []*tfjson.StateResource
+	for _, resource := range managedResources(state) {
+		if resource.Type == resourceType {
+			found = append(found, resource)
+		}
+	}
+	return found
+}
+
+// managedResources returns every managed resource in state, including those
+// nested in child modules.
+func managedResources(state *tfjson.State) []*tfjson.StateResource {
+	if state.Values == nil {
+		return nil
+	}
+
+	var found []*tfjson.StateResource
+	modules := []*tfjson.StateModule{state.Values.RootModule}
+	for len(modules) > 0 {
+		module := modules[0]
+		modules = modules[1:]
+		if module == nil {
+			continue
+		}
+		for _, resource := range module.Resources {
+			if resource.Mode == tfjson.ManagedResourceMode {
+				found = append(found, resource)
+			}
+		}
+		modules = append(modules, module.ChildModules...)
+	}
+	return found
+}
@@ -0,0 +1,161 @@
+package test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// streamingURLEnvVar points TestStreamingEndpoint at an endpoint that
+// streams its response.
+const streamingURLEnvVar = "STREAMING_URL"
+
+// defaultStreamingChunks and defaultStreamingTimeout are what
+// TestStreamingEndpoint expects unless STREAMING_CHUNKS and
+// STREAMING_TIMEOUT say otherwise.
+const (
+	defaultStreamingChunks  = 3
+	defaultStreamingTimeout = 30 * time.Second
+)
+
+// TestStreamingEndpoint checks that the endpoint at STREAMING_URL streams at
+// least STREAMING_CHUNKS chunks within STREAMING_TIMEOUT. It is skipped until
+// there is a streaming endpoint to point it at.
+func TestStreamingEndpoint(t *testing.T) {
+	streamingURL := os.Getenv(streamingURLEnvVar)
+	if streamingURL == "" {
+		t.Skipf("Skipping streaming test: set %s to a streaming endpoint", streamingURLEnvVar)
+	}
+
+	assertValidURL(t, streamingURL)
+	assertStreamingResponse(t, streamingURL,
+		envInt(t, "STREAMING_CHUNKS", defaultStreamingChunks),
+		envDuration(t, "STREAMING_TIMEOUT", defaultStreamingTimeout))
+}
+
+// assertStreamingResponse GETs url and reads the response as it arrives,
+// failing the test unless expectedChunks chunks come in before timeout. A
+// text/event-stream response is counted in server-sent events with data,
+// anything else in non-empty lines. It stops reading, and closes the
+// connection, once enough chunks arrived, so an endless stream can't hang it.
+func assertStreamingResponse(t testing.TB, url string, expectedChunks int, timeout time.Duration) {
+	t.Helper()
+
+	chunks, elapsed, err := countStreamedChunks(url, expectedChunks, timeout)
+	if err != nil {
+		t.Fatalf("Expected %d streamed chunk(s) from %s within %s, got %d: %v", expectedChunks, url, timeout, chunks, err)
+	}
+	t.Logf("%s streamed %d chunk(s) in %s", url, chunks, elapsed)
+}
+
+// countStreamedChunks returns how many chunks url streamed, up to
+// expectedChunks, and how long they took. The error says why fewer arrived.
+func countStreamedChunks(url string, expectedChunks int, timeout time.Duration) (int, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	req.Header.Set("Accept", "text/event-stream, */*")
+
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, 0, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	sse := strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream")
+
+	chunks := 0
+	eventHasData := false
+	reader := bufio.NewReader(resp.Body)
+	for chunks < expectedChunks {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			// The last line of a stream doesn't need a trailing newline
+			if errors.Is(err, io.EOF) && strings.TrimSpace(line) != "" && !sse {
+				chunks++
+				continue
+			}
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			} else if errors.Is(err, io.EOF) {
+				err = errors.New("stream ended")
+			}
+			return chunks, time.Since(start), err
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case !sse:
+			if strings.TrimSpace(line) != "" {
+				chunks++
+			}
+		case strings.HasPrefix(line, "data:"):
+			eventHasData = true
+		case line == "":
+			// A blank line ends an event
+			if eventHasData {
+				chunks++
+			}
+			eventHasData = false
+		}
+	}
+	return chunks, time.Since(start), nil
+}
+
+func TestCountStreamedChunks(t *testing.T) {
+	// stream writes each chunk with a flush, then keeps the connection open
+	// until the client goes away, like an endless stream
+	stream := func(contentType string, chunks ...string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", contentType)
+			for _, chunk := range chunks {
+				io.WriteString(w, chunk)
+				w.(http.Flusher).Flush()
+			}
+			<-r.Context().Done()
+		}))
+	}
+
+	lines := stream("text/plain", "one\n", "\n", "two\n", "three\n")
+	defer lines.Close()
+	chunks, _, err := countStreamedChunks(lines.URL, 3, 5*time.Second)
+	assert.NoError(t, err, "Reading should stop after the expected chunks, not at the end of the stream")
+	assert.Equal(t, 3, chunks)
+
+	events := stream("text/event-stream", ": comment\n\n", "data: one\ndata: more\n\n", "event: ping\n\n", "data: two\n\n")
+	defer events.Close()
+	chunks, _, err = countStreamedChunks(events.URL, 2, 5*time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, chunks, "Only events with data count")
+
+	chunks, elapsed, err := countStreamedChunks(lines.URL, 4, 200*time.Millisecond)
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "A stream that stalls should time out")
+	assert.Equal(t, 3, chunks)
+	assert.Less(t, elapsed, 5*time.Second)
+
+	short := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "one\ntwo")
+	}))
+	defer short.Close()
+	chunks, _, err = countStreamedChunks(short.URL, 3, 5*time.Second)
+	assert.EqualError(t, err, "stream ended")
+	assert.Equal(t, 2, chunks)
+}
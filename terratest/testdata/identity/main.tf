@@ -0,0 +1,47 @@
+# Reports the identity the Google provider authenticates as, so tests can
+# check that service account impersonation is in effect
+terraform {
+  required_version = ">= 1.0"
+  required_providers {
+    google = {
+      source  = "hashicorp/google"
+      version = "~> 4.0"
+    }
+  }
+}
+
+provider "google" {
+  project = var.project_id
+  region  = var.region
+}
+
+variable "project_id" {
+  description = "The GCP project ID"
+  type        = string
+}
+
+# Accepted so the shared test options, including GCP_REGION, can be used unchanged
+variable "region" {
+  description = "The GCP region"
+  type        = string
+  default     = "us-central1"
+}
+
+variable "labels" {
+  description = "Unused; nothing here supports labels"
+  type        = map(string)
+  default     = {}
+}
+
+variable "name_suffix" {
+  description = "Unused; nothing here is named"
+  type        = string
+  default     = ""
+}
+
+data "google_client_openid_userinfo" "current" {}
+
+output "email" {
+  description = "The email of the identity Terraform runs as"
+  value       = data.google_client_openid_userinfo.current.email
+}
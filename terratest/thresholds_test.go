@@ -0,0 +1,77 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// thresholds are what the assertions expect of one environment's deployment.
+type thresholds struct {
+	// responseSLA is how fast a warm request must be answered, unless
+	// RESPONSE_SLA_MS says otherwise
+	responseSLA time.Duration
+	// minInstances and maxInstances bound the function's scaling; a minimum
+	// of 0 allows scale-to-zero and the cold starts that come with it
+	minInstances, maxInstances int
+	// requireAuth marks environments whose function doesn't allow
+	// unauthenticated invocations, so requests must carry an identity token
+	requireAuth bool
+	// delayParam marks environments whose function honours ?delay=, which
+	// lets any caller keep an instance busy
+	delayParam bool
+}
+
+// environmentThresholds are the thresholds of each environment in
+// testEnvironments. Dev and test scale to zero when idle; prd stays warm.
+var environmentThresholds = map[string]thresholds{
+	"dev":  {responseSLA: 2 * time.Second, minInstances: 0, maxInstances: 10, delayParam: true},
+	"test": {responseSLA: 2 * time.Second, minInstances: 0, maxInstances: 10, delayParam: true},
+	"prd":  {responseSLA: 1 * time.Second, minInstances: 1, maxInstances: 10},
+}
+
+// defaultThresholds apply to environments without an entry in
+// environmentThresholds. They are as strict as prd's, so a new environment
+// is held to production expectations until it is given its own.
+var defaultThresholds = thresholds{responseSLA: 1 * time.Second, minInstances: 1, maxInstances: 10, requireAuth: true}
+
+// thresholdsFor returns the thresholds of the environment named env.
+func thresholdsFor(env string) thresholds {
+	if th, ok := environmentThresholds[env]; ok {
+		return th
+	}
+	return defaultThresholds
+}
+
+// responseSLA returns RESPONSE_SLA_MS, or the responseSLA of env when it
+// isn't set.
+func responseSLA(t *testing.T, env string) time.Duration {
+	t.Helper()
+
+	sla := int(thresholdsFor(env).responseSLA / time.Millisecond)
+	return time.Duration(envInt(t, "RESPONSE_SLA_MS", sla)) * time.Millisecond
+}
+
+func TestThresholdsFor(t *testing.T) {
+	for _, env := range testEnvironments {
+		_, ok := environmentThresholds[env.name]
+		assert.True(t, ok, "Environment %s should have its own thresholds", env.name)
+	}
+
+	assert.Zero(t, thresholdsFor("dev").minInstances, "Dev scales to zero")
+	assert.Positive(t, thresholdsFor("prd").minInstances, "Prd stays warm")
+
+	unknown := thresholdsFor("staging-eu")
+	assert.Equal(t, defaultThresholds, unknown)
+	assert.True(t, unknown.requireAuth, "Unknown environments should require auth")
+	assert.False(t, unknown.delayParam, "Unknown environments shouldn't honour ?delay=")
+	assert.False(t, thresholdsFor("prd").delayParam, "Prd mustn't honour ?delay=")
+	assert.LessOrEqual(t, unknown.responseSLA, thresholdsFor("prd").responseSLA)
+	assert.Equal(t, defaultThresholds, thresholdsFor(""))
+
+	t.Setenv("RESPONSE_SLA_MS", "")
+	assert.Equal(t, 2*time.Second, responseSLA(t, "dev"))
+	t.Setenv("RESPONSE_SLA_MS", "500")
+	assert.Equal(t, 500*time.Millisecond, responseSLA(t, "prd"), "RESPONSE_SLA_MS overrides every environment")
+}
@@ -0,0 +1,166 @@
+package test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	tfjson "github.com/hashicorp/terraform-json"
+	"github.com/stretchr/testify/assert"
+
+	"hello-world-test/gcptest"
+)
+
+// httpTrigger and pubSubTrigger are what getTriggerType returns for a
+// function invoked over HTTP and one invoked by messages on a Pub/Sub topic.
+const (
+	httpTrigger   = "http"
+	pubSubTrigger = "pubsub"
+)
+
+// eventProcessedLogLine starts the line hello_event logs, followed by the
+// message ID, for every Pub/Sub message it handles.
+const eventProcessedLogLine = "Processed message"
+
+// getTriggerType returns how the Cloud Function in state is invoked:
+// httpTrigger, pubSubTrigger, or the event type of any other event trigger.
+func getTriggerType(t testing.TB, state *tfjson.State) string {
+	t.Helper()
+
+	trigger, _, err := functionTrigger(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return trigger
+}
+
+// functionTrigger returns the trigger type of the Cloud Function in state, as
+// getTriggerType describes it, and the resource its events come from, such
+// as the Pub/Sub topic. A 1st gen function has either trigger_http or an
+// event_trigger; a 2nd gen one is invoked over HTTP unless it has an
+// event_trigger.
+func functionTrigger(state *tfjson.State) (string, string, error) {
+	function, err := deployedFunction(state)
+	if err != nil {
+		return "", "", err
+	}
+	eventTriggers, _ := function.AttributeValues["event_trigger"].([]interface{})
+	if len(eventTriggers) == 0 {
+		if function.Type == "google_cloudfunctions_function" {
+			if triggerHTTP, _ := function.AttributeValues["trigger_http"].(bool); !triggerHTTP {
+				return "", "", fmt.Errorf("%s has neither trigger_http nor an event_trigger", function.Address)
+			}
+		}
+		return httpTrigger, "", nil
+	}
+
+	eventTrigger, _ := eventTriggers[0].(map[string]interface{})
+	eventType, _ := eventTrigger["event_type"].(string)
+	resource, _ := eventTrigger["resource"].(string)
+	if topic, _ := eventTrigger["pubsub_topic"].(string); topic != "" {
+		resource = topic
+	}
+	switch eventType {
+	case "google.pubsub.topic.publish", "providers/cloud.pubsub/eventTypes/topic.publish",
+		"google.cloud.pubsub.topic.v1.messagePublished":
+		return pubSubTrigger, resource, nil
+	}
+	return eventType, resource, nil
+}
+
+// publishAndAssertProcessed publishes a message to topic and fails the test
+// unless the Cloud Function functionName logs that it processed it.
+func publishAndAssertProcessed(t *testing.T, projectID, functionName, topic string) {
+	t.Helper()
+
+	publishedAt := time.Now()
+	messageID := gcptest.PublishMessage(t, projectID, topic, []byte("terratest "+newTraceID()))
+	t.Logf("Published message %s to %s", messageID, topic)
+	gcptest.AssertFunctionLogged(t, projectID, functionName, "", publishedAt, eventProcessedLogLine+" "+messageID+":")
+}
+
+// TestFunctionPubSubTrigger deploys the dev environment with
+// enable_pubsub_trigger, so the function runs hello_event for messages on its
+// own topic, then publishes one and waits for it to be logged as processed.
+func TestFunctionPubSubTrigger(t *testing.T) {
+	projectID := gcptest.GetProjectID(t)
+
+	terraformOptions := buildOptions(t, devEnvironmentDir, map[string]interface{}{
+		"project_id":            projectID,
+		"enable_pubsub_trigger": true,
+	})
+
+	if !initTerraform(t, terraformOptions) {
+		return
+	}
+	withWorkspace(t, terraformOptions, "terratest-pubsub")
+	defer destroyAndVerify(t, terraformOptions)
+
+	applyTerraform(t, terraformOptions)
+
+	state := readState(t, terraformOptions)
+	trigger, topic, err := functionTrigger(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trigger != pubSubTrigger {
+		t.Fatalf("Expected the function to be triggered by %s with enable_pubsub_trigger, got %s", pubSubTrigger, trigger)
+	}
+	// The load balancer has no HTTPS endpoint to route to, so it must be left out
+	if negs := stateResources(state, "google_compute_region_network_endpoint_group"); len(negs) > 0 {
+		t.Errorf("Expected no load balancer with enable_pubsub_trigger, found %s", negs[0].Address)
+	}
+	publishAndAssertProcessed(t, projectID, getRequiredOutput(t, terraformOptions, "function_name"), topic)
+}
+
+func TestFunctionTrigger(t *testing.T) {
+	trigger, _, err := functionTrigger(stateWithResources(&tfjson.StateResource{
+		Address:         "google_cloudfunctions_function.hello_world",
+		Type:            "google_cloudfunctions_function",
+		AttributeValues: map[string]interface{}{"trigger_http": true, "event_trigger": []interface{}{}},
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, httpTrigger, trigger)
+
+	trigger, topic, err := functionTrigger(stateWithResources(&tfjson.StateResource{
+		Address: "google_cloudfunctions_function.hello_world",
+		Type:    "google_cloudfunctions_function",
+		AttributeValues: map[string]interface{}{"event_trigger": []interface{}{map[string]interface{}{
+			"event_type": "google.pubsub.topic.publish",
+			"resource":   "projects/my-project/topics/hello",
+		}}},
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, pubSubTrigger, trigger)
+	assert.Equal(t, "projects/my-project/topics/hello", topic)
+
+	trigger, topic, err = functionTrigger(stateWithResources(&tfjson.StateResource{
+		Address: "google_cloudfunctions2_function.hello_world",
+		Type:    "google_cloudfunctions2_function",
+		AttributeValues: map[string]interface{}{"event_trigger": []interface{}{map[string]interface{}{
+			"event_type":   "google.cloud.pubsub.topic.v1.messagePublished",
+			"pubsub_topic": "projects/my-project/topics/hello",
+		}}},
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, pubSubTrigger, trigger, "2nd gen functions name the event type differently")
+	assert.Equal(t, "projects/my-project/topics/hello", topic)
+
+	trigger, _, err = functionTrigger(stateWithResources(&tfjson.StateResource{
+		Address: "google_cloudfunctions_function.hello_world",
+		Type:    "google_cloudfunctions_function",
+		AttributeValues: map[string]interface{}{"event_trigger": []interface{}{map[string]interface{}{
+			"event_type": "google.storage.object.finalize",
+			"resource":   "my-bucket",
+		}}},
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, "google.storage.object.finalize", trigger)
+
+	_, _, err = functionTrigger(stateWithResources(&tfjson.StateResource{
+		Address:         "google_cloudfunctions_function.hello_world",
+		Type:            "google_cloudfunctions_function",
+		AttributeValues: map[string]interface{}{},
+	}))
+	assert.Error(t, err)
+}
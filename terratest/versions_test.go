@@ -0,0 +1,412 @@
+package test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/stretchr/testify/assert"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// versionRange is the half-open range [min, below) of allowed versions.
+type versionRange struct {
+	min, below string
+}
+
+func (r versionRange) contains(version string) bool {
+	return versionAtLeast(version, r.min) && !versionAtLeast(version, r.below)
+}
+
+func (r versionRange) String() string {
+	return fmt.Sprintf(">= %s, < %s", r.min, r.below)
+}
+
+// allowedProviderVersions are the provider versions the configuration is
+// reviewed against, keyed by the local name used in required_providers.
+var allowedProviderVersions = map[string]versionRange{
+	"google":      {min: "4.0.0", below: "5.0.0"},
+	"google-beta": {min: "4.0.0", below: "5.0.0"},
+	"archive":     {min: "2.0.0", below: "3.0.0"},
+	"time":        {min: "0.9.0", below: "1.0.0"},
+}
+
+// floatingRefs are git refs that move, so a module pinned to one isn't pinned.
+var floatingRefs = map[string]bool{"main": true, "master": true, "develop": true, "trunk": true, "HEAD": true}
+
+// pinnedRef matches a release tag or a commit hash.
+var pinnedRef = regexp.MustCompile(`^(v?\d+\.\d+\.\d+\S*|[0-9a-f]{7,40})$`)
+
+// registrySource matches a Terraform registry module address, with an
+// optional hostname in front of namespace/name/provider.
+var registrySource = regexp.MustCompile(`^([a-z0-9.-]+\.[a-z]+/)?[A-Za-z0-9_-]+/[A-Za-z0-9_-]+/[A-Za-z0-9_-]+$`)
+
+// TestProviderVersionsPinned checks, without running Terraform, that every
+// environment in testEnvironments constrains the google provider and each
+// provider it declares to a range within allowedProviderVersions, that its
+// .terraform.lock.hcl, where one is committed, locks them within that
+// range, and that no
+// module it uses, directly or through local modules, comes from a floating
+// source.
+func TestProviderVersionsPinned(t *testing.T) {
+	for _, env := range testEnvironments {
+		t.Run(env.name, func(t *testing.T) {
+			constraints, err := providerConstraints(env.dir)
+			if err != nil {
+				t.Fatalf("Could not read required_providers in %s: %v", env.dir, err)
+			}
+			if _, ok := constraints["google"]; !ok {
+				t.Errorf("%s does not declare the google provider in required_providers", env.dir)
+			}
+			for name, constraint := range constraints {
+				allowed, ok := allowedProviderVersions[name]
+				if !ok {
+					t.Errorf("Provider %s in %s has no entry in allowedProviderVersions", name, env.dir)
+				} else if problem := constraintProblem(constraint, allowed); problem != "" {
+					t.Errorf("Provider %s in %s: %s", name, env.dir, problem)
+				}
+			}
+
+			// The lock file pins the exact versions. Only a committed one is
+			// checked; terraform init writes it, with the provider hashes
+			lockFile := filepath.Join(env.dir, ".terraform.lock.hcl")
+			locked, err := lockedProviderVersions(lockFile)
+			if os.IsNotExist(err) {
+				t.Logf("%s is not committed, so only the constraints are checked; run terraform init in %s and commit it to pin the versions", lockFile, env.dir)
+				return
+			}
+			if err != nil {
+				t.Fatalf("Could not parse %s: %v", lockFile, err)
+			}
+			for name := range constraints {
+				allowed, ok := allowedProviderVersions[name]
+				if !ok {
+					continue
+				}
+				version, ok := locked["registry.terraform.io/hashicorp/"+name]
+				if !ok {
+					t.Errorf("%s does not lock provider %s", lockFile, name)
+				} else if !allowed.contains(version) {
+					t.Errorf("%s locks provider %s at %s, outside the allowed %s", lockFile, name, version, allowed)
+				}
+			}
+		})
+	}
+
+	problems, err := unpinnedModules(devEnvironmentDir)
+	if err != nil {
+		t.Fatalf("Could not read module sources: %v", err)
+	}
+	for _, problem := range problems {
+		t.Error(problem)
+	}
+}
+
+// constraintProblem returns why the version constraint doesn't keep a
+// provider within allowed, or "" when every version it admits is allowed.
+// Constraints are comma-separated =, !=, >, >=, <, <= and ~> terms; != terms
+// don't narrow the range, so they are ignored.
+func constraintProblem(constraint string, allowed versionRange) string {
+	if strings.TrimSpace(constraint) == "" {
+		return "no version constraint"
+	}
+
+	// lower and upper bound the admitted versions; upperInclusive tells
+	// <= and = apart from < and ~>
+	var lower, upper string
+	upperInclusive := false
+	raise := func(version string) {
+		if lower == "" || versionAtLeast(version, lower) {
+			lower = version
+		}
+	}
+	cap := func(version string, inclusive bool) {
+		if upper == "" || !versionAtLeast(version, upper) || (version == upper && !inclusive) {
+			upper, upperInclusive = version, inclusive
+		}
+	}
+	for _, term := range strings.Split(constraint, ",") {
+		term = strings.TrimSpace(term)
+		operator := strings.TrimRight(term[:len(term)-len(strings.TrimLeft(term, "=!<>~ "))], " ")
+		version := strings.TrimSpace(term[len(operator):])
+		switch operator {
+		case "", "=":
+			raise(version)
+			cap(version, true)
+		case ">", ">=":
+			raise(version)
+		case "<":
+			cap(version, false)
+		case "<=":
+			cap(version, true)
+		case "~>":
+			raise(version)
+			cap(pessimisticBound(version), false)
+		case "!=":
+		default:
+			return fmt.Sprintf("constraint %q has an unknown operator %q", constraint, operator)
+		}
+	}
+
+	switch {
+	case lower == "" || !versionAtLeast(lower, allowed.min):
+		return fmt.Sprintf("constraint %q admits versions below %s, outside the allowed %s", constraint, allowed.min, allowed)
+	case upper == "":
+		return fmt.Sprintf("constraint %q has no upper bound, outside the allowed %s", constraint, allowed)
+	case upperInclusive && versionAtLeast(upper, allowed.below),
+		!upperInclusive && !versionAtLeast(allowed.below, upper):
+		return fmt.Sprintf("constraint %q admits versions up to %s, outside the allowed %s", constraint, upper, allowed)
+	}
+	return ""
+}
+
+// pessimisticBound returns the exclusive upper bound of ~> version: the
+// next release of the second-to-last component given, so ~> 4.0 stops below
+// 5.0 and ~> 4.85.0 below 4.86.0.
+func pessimisticBound(version string) string {
+	parts := versionParts(version)
+	if len(parts) == 1 {
+		parts = append(parts, 0)
+	}
+	bumped := len(parts) - 2
+	parts[bumped]++
+	for i := bumped + 1; i < len(parts); i++ {
+		parts[i] = 0
+	}
+	fields := make([]string, len(parts))
+	for i, part := range parts {
+		fields[i] = fmt.Sprint(part)
+	}
+	return strings.Join(fields, ".")
+}
+
+// providerConstraints returns the version constraint of each provider in the
+// required_providers blocks of dir's .tf files, keyed by local name.
+func providerConstraints(dir string) (map[string]string, error) {
+	bodies, err := parseTerraformFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	constraints := map[string]string{}
+	for _, body := range bodies {
+		content, _, _ := body.PartialContent(&hcl.BodySchema{Blocks: []hcl.BlockHeaderSchema{{Type: "terraform"}}})
+		for _, terraformBlock := range content.Blocks {
+			inner, _, _ := terraformBlock.Body.PartialContent(&hcl.BodySchema{Blocks: []hcl.BlockHeaderSchema{{Type: "required_providers"}}})
+			for _, block := range inner.Blocks {
+				attributes, _ := block.Body.JustAttributes()
+				for name, attribute := range attributes {
+					value, diags := attribute.Expr.Value(nil)
+					if diags.HasErrors() || !value.Type().IsObjectType() || !value.Type().HasAttribute("version") {
+						constraints[name] = ""
+						continue
+					}
+					constraints[name] = stringValue(value.GetAttr("version"))
+				}
+			}
+		}
+	}
+	return constraints, nil
+}
+
+// lockedProviderVersions returns the version of each provider in the
+// dependency lock file at path, keyed by provider address.
+func lockedProviderVersions(path string) (map[string]string, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	file, diags := hclparse.NewParser().ParseHCLFile(path)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	content, _, diags := file.Body.PartialContent(&hcl.BodySchema{
+		Blocks: []hcl.BlockHeaderSchema{{Type: "provider", LabelNames: []string{"address"}}},
+	})
+	if diags.HasErrors() {
+		return nil, diags
+	}
+	versions := map[string]string{}
+	for _, block := range content.Blocks {
+		attributes, _ := block.Body.JustAttributes()
+		if attribute, ok := attributes["version"]; ok {
+			value, _ := attribute.Expr.Value(nil)
+			versions[block.Labels[0]] = stringValue(value)
+		}
+	}
+	return versions, nil
+}
+
+// unpinnedModules describes every module call reachable from dir, following
+// local module sources, whose source can change underneath it.
+func unpinnedModules(dir string) ([]string, error) {
+	var problems []string
+	visited := map[string]bool{}
+	pending := []string{filepath.Clean(dir)}
+	for len(pending) > 0 {
+		current := pending[0]
+		pending = pending[1:]
+		if visited[current] {
+			continue
+		}
+		visited[current] = true
+
+		bodies, err := parseTerraformFiles(current)
+		if err != nil {
+			return nil, err
+		}
+		for _, body := range bodies {
+			content, _, _ := body.PartialContent(&hcl.BodySchema{
+				Blocks: []hcl.BlockHeaderSchema{{Type: "module", LabelNames: []string{"name"}}},
+			})
+			for _, block := range content.Blocks {
+				attributes, _ := block.Body.JustAttributes()
+				source, version := attributeString(attributes, "source"), attributeString(attributes, "version")
+				if strings.HasPrefix(source, "./") || strings.HasPrefix(source, "../") {
+					pending = append(pending, filepath.Join(current, source))
+					continue
+				}
+				if problem := moduleSourceProblem(source, version); problem != "" {
+					problems = append(problems, fmt.Sprintf("module %q in %s: %s", block.Labels[0], current, problem))
+				}
+			}
+		}
+	}
+	return problems, nil
+}
+
+// moduleSourceProblem returns why a non-local module source isn't pinned, or
+// "" when it is: registry modules need a version, git sources a ref that is a
+// tag or commit rather than a branch.
+func moduleSourceProblem(source, version string) string {
+	if registrySource.MatchString(source) {
+		if version == "" {
+			return fmt.Sprintf("registry source %s has no version", source)
+		}
+		return ""
+	}
+
+	isGit := strings.HasPrefix(source, "git::") || strings.HasPrefix(source, "github.com/") ||
+		strings.HasPrefix(source, "bitbucket.org/") || strings.Contains(source, ".git")
+	if !isGit {
+		return ""
+	}
+	ref := ""
+	if i := strings.Index(source, "?"); i >= 0 {
+		for _, param := range strings.Split(source[i+1:], "&") {
+			if value, ok := strings.CutPrefix(param, "ref="); ok {
+				ref = value
+			}
+		}
+	}
+	switch {
+	case ref == "":
+		return fmt.Sprintf("git source %s has no ?ref=, so it follows the default branch", source)
+	case floatingRefs[ref] || !pinnedRef.MatchString(ref):
+		return fmt.Sprintf("git source %s is pinned to %q, use a release tag or commit instead", source, ref)
+	}
+	return ""
+}
+
+// parseTerraformFiles parses every .tf file in dir.
+func parseTerraformFiles(dir string) ([]hcl.Body, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.tf"))
+	if err != nil {
+		return nil, err
+	}
+	parser := hclparse.NewParser()
+	var bodies []hcl.Body
+	for _, path := range files {
+		file, diags := parser.ParseHCLFile(path)
+		if diags.HasErrors() {
+			return nil, diags
+		}
+		bodies = append(bodies, file.Body)
+	}
+	return bodies, nil
+}
+
+// attributeString evaluates the literal string attribute name, or returns ""
+// when it is missing or not a literal string.
+func attributeString(attributes hcl.Attributes, name string) string {
+	attribute, ok := attributes[name]
+	if !ok {
+		return ""
+	}
+	value, diags := attribute.Expr.Value(nil)
+	if diags.HasErrors() {
+		return ""
+	}
+	return stringValue(value)
+}
+
+func stringValue(value cty.Value) string {
+	if value.IsNull() || !value.IsKnown() || value.Type() != cty.String {
+		return ""
+	}
+	return value.AsString()
+}
+
+func TestLockedProviderVersions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".terraform.lock.hcl")
+	lock := `provider "registry.terraform.io/hashicorp/google" {
+  version     = "4.85.0"
+  constraints = "~> 4.0"
+  hashes = [
+    "h1:abc=",
+  ]
+}
+`
+	if err := os.WriteFile(path, []byte(lock), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	versions, err := lockedProviderVersions(path)
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]string{"registry.terraform.io/hashicorp/google": "4.85.0"}, versions)
+	}
+	assert.True(t, allowedProviderVersions["google"].contains("4.85.0"))
+	assert.False(t, allowedProviderVersions["google"].contains("5.1.0"))
+}
+
+func TestConstraintProblem(t *testing.T) {
+	allowed := versionRange{min: "4.0.0", below: "5.0.0"}
+	for _, constraint := range []string{"~> 4.0", "~> 4", "~> 4.85.0", ">= 4.10, < 5.0", "4.85.0", "= 4.85.0", ">= 4.0, <= 4.99.0, != 4.50.0"} {
+		assert.Empty(t, constraintProblem(constraint, allowed), "%s should be within %s", constraint, allowed)
+	}
+	for _, constraint := range []string{"", ">= 0", ">= 4.0", "~> 3.90", ">= 4.0, <= 5.0.0", "< 5.0", "^4.0"} {
+		assert.NotEmpty(t, constraintProblem(constraint, allowed), "%s should fall outside %s", constraint, allowed)
+	}
+}
+
+func TestPessimisticBound(t *testing.T) {
+	assert.Equal(t, "5.0", pessimisticBound("4.0"))
+	assert.Equal(t, "4.86.0", pessimisticBound("4.85.0"))
+	assert.Equal(t, "5.0", pessimisticBound("4"))
+}
+
+func TestModuleSourceProblem(t *testing.T) {
+	for source, version := range map[string]string{
+		"terraform-google-modules/network/google":                     "~> 9.0",
+		"git::https://github.com/example/modules.git//vpc?ref=v1.2.0": "",
+		"github.com/example/modules//vpc?ref=3f2a9c1":                 "",
+		"https://example.com/modules/vpc.zip":                         "",
+		"app.terraform.io/example/vpc/google":                         "1.0.0",
+	} {
+		assert.Empty(t, moduleSourceProblem(source, version), "%s should count as pinned", source)
+	}
+
+	for source, version := range map[string]string{
+		"terraform-google-modules/network/google":                   "",
+		"git::https://github.com/example/modules.git//vpc":          "",
+		"github.com/example/modules//vpc?ref=main":                  "",
+		"git::https://github.com/example/modules.git?ref=feature-x": "",
+	} {
+		assert.NotEmpty(t, moduleSourceProblem(source, version), "%s should count as floating", source)
+	}
+}
@@ -0,0 +1,193 @@
+package test
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+
+	"hello-world-test/gcptest"
+)
+
+// TestWorkspaceIsolation applies into one workspace and checks that another
+// workspace of the same configuration sees none of its state. Only one of
+// them is applied since both would create resources with the same names.
+func TestWorkspaceIsolation(t *testing.T) {
+	projectID := gcptest.GetProjectID(t)
+
+	newOptions := func() *terraform.Options {
+		return buildOptions(t, devEnvironmentDir, map[string]interface{}{
+			"project_id": projectID,
+		})
+	}
+
+	applied := newOptions()
+	if !initTerraform(t, applied) {
+		return
+	}
+	withWorkspace(t, applied, "terratest-isolation-a")
+	defer destroyAndVerify(t, applied)
+
+	applyTerraform(t, applied)
+	assert.NotEmpty(t, stateAddresses(t, applied), "Applied workspace should have state")
+
+	other := newOptions()
+	withWorkspace(t, other, "terratest-isolation-b")
+	defer deleteWorkspace(t, other)
+
+	assert.Empty(t, stateAddresses(t, other), "A second workspace must not see the first one's resources")
+	assert.NotEmpty(t, stateAddresses(t, applied), "Selecting another workspace must not touch the applied one")
+}
+
+// stateAddresses lists the resource addresses in terraformOptions' state.
+func stateAddresses(t testing.TB, terraformOptions *terraform.Options) []string {
+	t.Helper()
+
+	out, err := terraform.RunTerraformCommandAndGetStdoutE(t, terraformOptions, "state", "list")
+	if err != nil {
+		t.Fatalf("Could not list state: %v", err)
+	}
+	return parseStateList(out)
+}
+
+// TestCleanupOrphans destroys whatever a crashed run left behind in the dev
+// environment, in the default workspace and every terratest-* workspace the
+// other tests apply into. It only runs with RUN_CLEANUP=1:
+//
+//	RUN_CLEANUP=1 go test -v -run TestCleanupOrphans
+func TestCleanupOrphans(t *testing.T) {
+	if os.Getenv("RUN_CLEANUP") != "1" {
+		t.Skip("Set RUN_CLEANUP=1 to destroy leaked test infrastructure")
+	}
+
+	projectID := gcptest.GetProjectID(t)
+
+	terraformOptions := buildOptions(t, devEnvironmentDir, map[string]interface{}{
+		"project_id": projectID,
+	})
+	if err := initWithRetry(t, terraformOptions, defaultInitAttempts); err != nil {
+		t.Fatalf("Could not init %s to find leaked workspaces: %v", devEnvironmentDir, err)
+	}
+
+	out, err := terraform.RunTerraformCommandAndGetStdoutE(t, terraformOptions, "workspace", "list")
+	if err != nil {
+		t.Fatalf("Could not list workspaces: %v", err)
+	}
+
+	for _, workspace := range parseWorkspaceList(out) {
+		if workspace != "default" && !strings.HasPrefix(workspace, "terratest-") {
+			continue
+		}
+		t.Run(workspace, func(t *testing.T) {
+			workspaceOptions, err := terraformOptions.Clone()
+			if err != nil {
+				t.Fatalf("Could not copy terraform options: %v", err)
+			}
+			withWorkspace(t, workspaceOptions, workspace)
+			destroyAndVerify(t, workspaceOptions)
+		})
+	}
+}
+
+// TestReapStaleResources deletes functions and buckets labelled by earlier
+// terratest runs that are older than REAP_OLDER_THAN_HOURS (6 by default).
+// Everything else those runs created is left for TestCleanupOrphans, see
+// gcptest.ReapByLabel. It only runs with RUN_REAPER=1:
+//
+//	RUN_REAPER=1 go test -v -run TestReapStaleResources
+func TestReapStaleResources(t *testing.T) {
+	if os.Getenv("RUN_REAPER") != "1" {
+		t.Skip("Set RUN_REAPER=1 to delete stale labelled test resources")
+	}
+
+	// An invalid TEST_RUN_ID labelled nothing, so nothing of this run is spared
+	if err := gcptest.ValidateRunID(gcptest.RunID()); err != nil {
+		t.Fatal(err)
+	}
+	projectID := gcptest.GetProjectID(t)
+	olderThan := time.Duration(envInt(t, "REAP_OLDER_THAN_HOURS", 6)) * time.Hour
+	gcptest.ReapByLabel(t, projectID, gcptest.RunID(), olderThan)
+}
+
+// parseWorkspaceList extracts workspace names from `terraform workspace list`
+// output, where the current workspace is marked with a leading "*".
+func parseWorkspaceList(out string) []string {
+	var workspaces []string
+	for _, line := range parseStateList(out) {
+		workspaces = append(workspaces, strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	}
+	return workspaces
+}
+
+// withWorkspace selects (creating if needed) the named Terraform workspace and
+// pins it through TF_WORKSPACE so every later command on terraformOptions,
+// including a deferred destroy, runs against that workspace's state.
+//
+// An explicit withWorkspace call takes precedence over a TF_WORKSPACE set in
+// the environment: tests that need their own state (parallel subtests, plan
+// checks against an empty workspace) keep it whatever the environment says.
+func withWorkspace(t testing.TB, terraformOptions *terraform.Options, name string) {
+	t.Helper()
+
+	if terraformOptions.EnvVars == nil {
+		terraformOptions.EnvVars = map[string]string{}
+	}
+	// Select with TF_WORKSPACE masked, terraform refuses to switch while it's set
+	terraformOptions.EnvVars["TF_WORKSPACE"] = ""
+	terraform.WorkspaceSelectOrNew(t, terraformOptions, name)
+	terraformOptions.EnvVars["TF_WORKSPACE"] = name
+}
+
+// useEnvWorkspace pins the workspace named by TF_WORKSPACE in the
+// environment, unless terraformOptions already has one from withWorkspace.
+func useEnvWorkspace(t testing.TB, terraformOptions *terraform.Options) {
+	t.Helper()
+
+	workspace := gcptest.EnvWorkspace()
+	if workspace == "" || pinnedWorkspace(terraformOptions) != "" {
+		return
+	}
+	t.Logf("Using workspace %s from TF_WORKSPACE", workspace)
+	withWorkspace(t, terraformOptions, workspace)
+}
+
+// pinnedWorkspace returns the workspace withWorkspace pinned on
+// terraformOptions, or "" when none is.
+func pinnedWorkspace(terraformOptions *terraform.Options) string {
+	return terraformOptions.EnvVars["TF_WORKSPACE"]
+}
+
+// resetWorkspace switches the working directory back to the default
+// workspace, so a manual terraform run there doesn't land in a test's state.
+func resetWorkspace(t testing.TB, terraformOptions *terraform.Options) {
+	t.Helper()
+
+	if workspace := pinnedWorkspace(terraformOptions); workspace == "" || workspace == "default" {
+		return
+	}
+	terraformOptions.EnvVars["TF_WORKSPACE"] = ""
+	if _, err := terraform.RunTerraformCommandE(t, terraformOptions, "workspace", "select", "default"); err != nil {
+		t.Logf("Could not switch back to the default workspace: %v", err)
+	}
+}
+
+// deleteWorkspace switches back to the default workspace and deletes the one
+// terraformOptions had pinned, so test workspaces don't pile up in the state
+// bucket. default and the workspace TF_WORKSPACE names are never deleted, and
+// terraform refuses to delete one whose state still tracks resources, which
+// keeps a failed destroy's leftovers for TestCleanupOrphans.
+func deleteWorkspace(t testing.TB, terraformOptions *terraform.Options) {
+	t.Helper()
+
+	workspace := pinnedWorkspace(terraformOptions)
+	resetWorkspace(t, terraformOptions)
+	if workspace == "" || workspace == "default" || workspace == gcptest.EnvWorkspace() {
+		return
+	}
+	if _, err := terraform.RunTerraformCommandE(t, terraformOptions, "workspace", "delete", workspace); err != nil {
+		t.Logf("Could not delete workspace %s: %v", workspace, err)
+	}
+}